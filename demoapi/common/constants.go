@@ -3,8 +3,46 @@ package common
 const StackName = "JITestDemoAPIStack"
 const UserTableName = "JITestDemoUserTable"
 const ProductTableName = "JITestDemoProductTable"
+const ProductPriceIndex = "catalog-price-index"
+const ProductNameIndex = "catalog-nameLower-index"
+const ProductCreatedAtIndex = "catalog-createdAt-index"
+const ProductManagerIndex = "manager-createdAt-index"
+const RefreshTokenTableName = "JITestDemoRefreshTokenTable"
+const RefreshTokenFamilyIndex = "familyId-index"
+const RevokedTokenTableName = "JITestDemoRevokedTokenTable"
+const PasswordResetTableName = "JITestDemoPasswordResetTable"
+const LoginAttemptTableName = "JITestDemoLoginAttemptTable"
+const RoleTableName = "JITestDemoRoleTable"
 const QueueName = "JITestDemoQueue"
 const UserFunctionName = "JITestDemoUserFunction"
 const ProductFunctionName = "JITestDemoProductFunction"
 const UserGatewayName = "JITestDemoUserGateway"
 const ProductGatewayName = "JITestDemoProductGateway"
+const SigningKeyName = "JITestDemoSigningKey"
+const SigningKeyIdsEnv = "SIGNING_KEY_IDS"
+const JwksUrlEnv = "JWKS_URL"
+const PasswordMinLengthEnv = "PASSWORD_MIN_LENGTH"
+const PasswordMinClassesEnv = "PASSWORD_MIN_CLASSES"
+const PasswordHashCostEnv = "PASSWORD_HASH_COST"
+const PasswordMinLength = "12"
+const PasswordMinClasses = "3"
+const PasswordHashCost = "12"
+const LoginMaxFailuresEnv = "LOGIN_MAX_FAILURES"
+const LoginMaxIPFailuresEnv = "LOGIN_MAX_IP_FAILURES"
+const LoginFailureWindowEnv = "LOGIN_FAILURE_WINDOW_SECONDS"
+const LoginLockoutEnv = "LOGIN_LOCKOUT_SECONDS"
+const LoginMaxLockoutEnv = "LOGIN_MAX_LOCKOUT_SECONDS"
+const LoginMaxFailures = "5"
+const LoginMaxIPFailures = "20"
+const LoginFailureWindow = "900"
+const LoginLockout = "60"
+const LoginMaxLockout = "3600"
+const MfaIssuerEnv = "MFA_ISSUER"
+const MfaRequiredForAdminsEnv = "MFA_REQUIRED_FOR_ADMINS"
+const MfaIssuer = "JITestDemo"
+const MfaRequiredForAdmins = "false"
+const EmailVerificationRequiredEnv = "EMAIL_VERIFICATION_REQUIRED"
+const EmailVerificationRequired = "false"
+const IfMatchRequiredEnv = "IF_MATCH_REQUIRED"
+const IfMatchRequired = "false"
+const JwksPath = "/.well-known/jwks.json"
@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigateway"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
 	"github.com/aws/constructs-go/constructs/v10"
@@ -47,22 +48,148 @@ func NewDemoapiStack(scope constructs.Construct, id string, props *DemoapiStackP
 		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
 	})
 
+	// sorted and manager-scoped product listings query these instead of scanning the table;
+	// every product has catalog = "product", so the catalog indexes hold the whole catalog
+	productIndexes := []struct {
+		name         string
+		partitionKey string
+		sortKey      string
+		sortKeyType  awsdynamodb.AttributeType
+	}{
+		{name: common.ProductPriceIndex, partitionKey: "catalog", sortKey: "price", sortKeyType: awsdynamodb.AttributeType_NUMBER},
+		{name: common.ProductNameIndex, partitionKey: "catalog", sortKey: "nameLower", sortKeyType: awsdynamodb.AttributeType_STRING},
+		{name: common.ProductCreatedAtIndex, partitionKey: "catalog", sortKey: "createdAt", sortKeyType: awsdynamodb.AttributeType_STRING},
+		{name: common.ProductManagerIndex, partitionKey: "manager", sortKey: "createdAt", sortKeyType: awsdynamodb.AttributeType_STRING},
+	}
+	for _, index := range productIndexes {
+		tableProducts.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
+			IndexName: jsii.String(index.name),
+			PartitionKey: &awsdynamodb.Attribute{
+				Name: jsii.String(index.partitionKey),
+				Type: awsdynamodb.AttributeType_STRING,
+			},
+			SortKey: &awsdynamodb.Attribute{
+				Name: jsii.String(index.sortKey),
+				Type: index.sortKeyType,
+			},
+			ProjectionType: awsdynamodb.ProjectionType_ALL,
+		})
+	}
+
+	tableRefreshTokens := awsdynamodb.NewTable(stack, jsii.String(common.RefreshTokenTableName), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("tokenHash"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName:           jsii.String(common.RefreshTokenTableName),
+		TimeToLiveAttribute: jsii.String("expiresAt"),
+		RemovalPolicy:       awscdk.RemovalPolicy_DESTROY,
+	})
+
+	tableRefreshTokens.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
+		IndexName: jsii.String(common.RefreshTokenFamilyIndex),
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("familyId"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		ProjectionType: awsdynamodb.ProjectionType_KEYS_ONLY,
+	})
+
+	tableRevokedTokens := awsdynamodb.NewTable(stack, jsii.String(common.RevokedTokenTableName), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName:           jsii.String(common.RevokedTokenTableName),
+		TimeToLiveAttribute: jsii.String("expiresAt"),
+		RemovalPolicy:       awscdk.RemovalPolicy_DESTROY,
+	})
+
+	tablePasswordResets := awsdynamodb.NewTable(stack, jsii.String(common.PasswordResetTableName), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("tokenHash"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName:           jsii.String(common.PasswordResetTableName),
+		TimeToLiveAttribute: jsii.String("expiresAt"),
+		RemovalPolicy:       awscdk.RemovalPolicy_DESTROY,
+	})
+
+	tableLoginAttempts := awsdynamodb.NewTable(stack, jsii.String(common.LoginAttemptTableName), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName:           jsii.String(common.LoginAttemptTableName),
+		TimeToLiveAttribute: jsii.String("expiresAt"),
+		RemovalPolicy:       awscdk.RemovalPolicy_DESTROY,
+	})
+
+	// roles beyond the built-in ones, each item lists the permissions of a role as a string set
+	tableRoles := awsdynamodb.NewTable(stack, jsii.String(common.RoleTableName), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("role"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName:     jsii.String(common.RoleTableName),
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+
+	// to rotate, add a new key and list it after the current one in SIGNING_KEY_IDS so it is
+	// published before it signs; move it to the front once the product service has cached it
+	signingKey := awskms.NewKey(stack, jsii.String(common.SigningKeyName), &awskms.KeyProps{
+		Description:   jsii.String("Signs JITestDemo access tokens"),
+		KeySpec:       awskms.KeySpec_RSA_2048,
+		KeyUsage:      awskms.KeyUsage_SIGN_VERIFY,
+		PendingWindow: awscdk.Duration_Days(jsii.Number(7)),
+		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+	})
+
 	functionUsers := awslambda.NewFunction(stack, jsii.String(common.UserFunctionName), &awslambda.FunctionProps{
 		Runtime: awslambda.Runtime_PROVIDED_AL2023(),
 		Code:    awslambda.AssetCode_FromAsset(jsii.String("lambda_user/user_function.zip"), nil),
 		Handler: jsii.String("main"),
+		Environment: &map[string]*string{
+			common.SigningKeyIdsEnv:      signingKey.KeyArn(),
+			common.PasswordMinLengthEnv:  jsii.String(common.PasswordMinLength),
+			common.PasswordMinClassesEnv: jsii.String(common.PasswordMinClasses),
+			// raising the cost upgrades existing hashes as their users log in
+			common.PasswordHashCostEnv:          jsii.String(common.PasswordHashCost),
+			common.LoginMaxFailuresEnv:          jsii.String(common.LoginMaxFailures),
+			common.LoginMaxIPFailuresEnv:        jsii.String(common.LoginMaxIPFailures),
+			common.LoginFailureWindowEnv:        jsii.String(common.LoginFailureWindow),
+			common.LoginLockoutEnv:              jsii.String(common.LoginLockout),
+			common.LoginMaxLockoutEnv:           jsii.String(common.LoginMaxLockout),
+			common.MfaIssuerEnv:                 jsii.String(common.MfaIssuer),
+			common.MfaRequiredForAdminsEnv:      jsii.String(common.MfaRequiredForAdmins),
+			common.EmailVerificationRequiredEnv: jsii.String(common.EmailVerificationRequired),
+		},
 	})
 
 	functionProducts := awslambda.NewFunction(stack, jsii.String(common.ProductFunctionName), &awslambda.FunctionProps{
 		Runtime: awslambda.Runtime_PROVIDED_AL2023(),
 		Code:    awslambda.AssetCode_FromAsset(jsii.String("lambda_product/product_function.zip"), nil),
 		Handler: jsii.String("main"),
+		Environment: &map[string]*string{
+			// turning it on makes every product write name the version it changes
+			common.IfMatchRequiredEnv: jsii.String(common.IfMatchRequired),
+		},
 	})
 
 	tableUsers.GrantReadWriteData(functionUsers)
+	tableRefreshTokens.GrantReadWriteData(functionUsers)
+	tableRevokedTokens.GrantReadWriteData(functionUsers)
+	tablePasswordResets.GrantReadWriteData(functionUsers)
+	tableLoginAttempts.GrantReadWriteData(functionUsers)
+	tableRoles.GrantReadData(functionUsers)
 	queue.GrantSendMessages(functionUsers)
 
 	tableProducts.GrantReadWriteData(functionProducts)
+	tableRevokedTokens.GrantReadData(functionProducts)
+	tableRoles.GrantReadData(functionProducts)
+
+	// only the user service can sign; the product service verifies with the published public keys
+	signingKey.Grant(functionUsers, jsii.String("kms:Sign"), jsii.String("kms:GetPublicKey"))
 
 	apiUser := awsapigateway.NewRestApi(stack, jsii.String(common.UserGatewayName), &awsapigateway.RestApiProps{
 		DefaultCorsPreflightOptions: &awsapigateway.CorsOptions{
@@ -85,15 +212,56 @@ func NewDemoapiStack(scope constructs.Construct, id string, props *DemoapiStackP
 	registerResource := apiUser.Root().AddResource(jsii.String("register"), nil)
 	registerResource.AddMethod(jsii.String("POST"), integrationUser, nil)
 
+	verifyResource := apiUser.Root().AddResource(jsii.String("verify"), nil)
+	verifyResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	verifyResendResource := verifyResource.AddResource(jsii.String("resend"), nil)
+	verifyResendResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	wellKnownResource := apiUser.Root().AddResource(jsii.String(".well-known"), nil)
+	jwksResource := wellKnownResource.AddResource(jsii.String("jwks.json"), nil)
+	jwksResource.AddMethod(jsii.String("GET"), integrationUser, nil)
+
+	functionProducts.AddEnvironment(jsii.String(common.JwksUrlEnv), apiUser.UrlForPath(jsii.String(common.JwksPath)), nil)
+
 	loginResource := apiUser.Root().AddResource(jsii.String("login"), nil)
 	loginResource.AddMethod(jsii.String("POST"), integrationUser, nil)
 
+	loginMfaResource := loginResource.AddResource(jsii.String("mfa"), nil)
+	loginMfaResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	refreshResource := apiUser.Root().AddResource(jsii.String("refresh"), nil)
+	refreshResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	passwordResource := apiUser.Root().AddResource(jsii.String("password"), nil)
+	passwordResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	passwordResetResource := passwordResource.AddResource(jsii.String("reset"), nil)
+	passwordResetResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	passwordResetTokenResource := passwordResource.AddResource(jsii.String("reset-token"), nil)
+	passwordResetTokenResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	mfaResource := apiUser.Root().AddResource(jsii.String("mfa"), nil)
+
+	mfaSetupResource := mfaResource.AddResource(jsii.String("setup"), nil)
+	mfaSetupResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	mfaVerifyResource := mfaResource.AddResource(jsii.String("verify"), nil)
+	mfaVerifyResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
+	logoutResource := apiUser.Root().AddResource(jsii.String("logout"), nil)
+	logoutResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
 	meResource := apiUser.Root().AddResource(jsii.String("me"), nil)
 	meResource.AddMethod(jsii.String("GET"), integrationUser, nil)
 
 	roleResource := apiUser.Root().AddResource(jsii.String("role"), nil)
 	roleResource.AddMethod(jsii.String("PUT"), integrationUser, nil)
 
+	unlockResource := apiUser.Root().AddResource(jsii.String("unlock"), nil)
+	unlockResource.AddMethod(jsii.String("POST"), integrationUser, nil)
+
 	removeResource := apiUser.Root().AddResource(jsii.String("remove"), nil)
 	removeResource.AddMethod(jsii.String("DELETE"), integrationUser, nil)
 
@@ -102,7 +270,7 @@ func NewDemoapiStack(scope constructs.Construct, id string, props *DemoapiStackP
 
 	apiProduct := awsapigateway.NewRestApi(stack, jsii.String(common.ProductGatewayName), &awsapigateway.RestApiProps{
 		DefaultCorsPreflightOptions: &awsapigateway.CorsOptions{
-			AllowHeaders: jsii.Strings("Content-Type", "Authorization"),
+			AllowHeaders: jsii.Strings("Content-Type", "Authorization", "If-Match"),
 			AllowMethods: jsii.Strings("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"),
 			AllowOrigins: jsii.Strings("*"),
 		},
@@ -118,6 +286,17 @@ func NewDemoapiStack(scope constructs.Construct, id string, props *DemoapiStackP
 
 	integrationProduct := awsapigateway.NewLambdaIntegration(functionProducts, nil)
 
+	productsResource := apiProduct.Root().AddResource(jsii.String("products"), nil)
+	productsResource.AddMethod(jsii.String("GET"), integrationProduct, nil)
+	productsResource.AddMethod(jsii.String("POST"), integrationProduct, nil)
+
+	productResource := productsResource.AddResource(jsii.String("{id}"), nil)
+	productResource.AddMethod(jsii.String("GET"), integrationProduct, nil)
+	productResource.AddMethod(jsii.String("PUT"), integrationProduct, nil)
+	productResource.AddMethod(jsii.String("PATCH"), integrationProduct, nil)
+	productResource.AddMethod(jsii.String("DELETE"), integrationProduct, nil)
+
+	// deprecated verb-style routes, answered with Deprecation and Sunset headers until they are removed
 	productListResource := apiProduct.Root().AddResource(jsii.String("list"), nil)
 	productListResource.AddMethod(jsii.String("GET"), integrationProduct, nil)
 
@@ -1,26 +1,603 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in demoapi_test.go
-// func TestDemoapiStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewDemoapiStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack, nil)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"demoapi/common"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// lambda assets are built by the Makefiles, so the tests synthesize against
+// placeholder zips in a scratch directory instead of the real binaries
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "demoapi-test")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, asset := range []string{"lambda_user/user_function.zip", "lambda_product/product_function.zip"} {
+		path := filepath.Join(dir, asset)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(path, []byte("placeholder"), 0o644); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type testStack struct {
+	stack    awscdk.Stack
+	template assertions.Template
+}
+
+func newTestStack(t *testing.T) testStack {
+	t.Helper()
+
+	app := awscdk.NewApp(nil)
+	stack := NewDemoapiStack(app, "TestStack", nil)
+
+	return testStack{
+		stack:    stack,
+		template: assertions.Template_FromStack(stack, nil),
+	}
+}
+
+// logicalID resolves the CloudFormation logical id of the construct with the given id.
+func (s testStack) logicalID(t *testing.T, id string) string {
+	t.Helper()
+
+	child := s.stack.Node().TryFindChild(jsii.String(id))
+	if child == nil {
+		t.Fatalf("construct %s not found in stack", id)
+	}
+
+	element, ok := child.Node().DefaultChild().(awscdk.CfnElement)
+	if !ok {
+		t.Fatalf("construct %s has no CloudFormation element", id)
+	}
+
+	return *s.stack.GetLogicalId(element)
+}
+
+func (s testStack) resources(t *testing.T, resourceType string) map[string]map[string]interface{} {
+	t.Helper()
+
+	found := s.template.FindResources(jsii.String(resourceType), nil)
+	if found == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(*found)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resources map[string]map[string]interface{}
+	if err := json.Unmarshal(raw, &resources); err != nil {
+		t.Fatal(err)
+	}
+
+	return resources
+}
+
+// expect reports a failed template assertion on t instead of letting its panic abort the run.
+func expect(t *testing.T, assertion func()) {
+	t.Helper()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%v", r)
+		}
+	}()
+
+	assertion()
+}
+
+func properties(resource map[string]interface{}) map[string]interface{} {
+	props, _ := resource["Properties"].(map[string]interface{})
+	return props
+}
+
+// refs collects every logical id referenced through Ref or Fn::GetAtt inside v.
+func refs(v interface{}) map[string]bool {
+	found := map[string]bool{}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := value["Ref"].(string); ok {
+				found[ref] = true
+			}
+			if getAtt, ok := value["Fn::GetAtt"].([]interface{}); ok && len(getAtt) > 0 {
+				if target, ok := getAtt[0].(string); ok {
+					found[target] = true
+				}
+			}
+			for _, child := range value {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+
+	return found
+}
+
+// routes lists the "path METHOD" pairs of a rest api, skipping the CORS preflight methods.
+func (s testStack) routes(t *testing.T, apiID string) []string {
+	t.Helper()
+
+	apiResources := s.resources(t, "AWS::ApiGateway::Resource")
+
+	var fullPath func(resourceID map[string]interface{}) string
+	fullPath = func(resourceID map[string]interface{}) string {
+		if ref, ok := resourceID["Ref"].(string); ok {
+			props := properties(apiResources[ref])
+			parent := props["ParentId"].(map[string]interface{})
+			return strings.TrimSuffix(fullPath(parent), "/") + "/" + props["PathPart"].(string)
+		}
+		return "/"
+	}
+
+	var routes []string
+	for _, method := range s.resources(t, "AWS::ApiGateway::Method") {
+		props := properties(method)
+		if !refs(props["RestApiId"])[apiID] || props["HttpMethod"] == "OPTIONS" {
+			continue
+		}
+		resourceID := props["ResourceId"].(map[string]interface{})
+		routes = append(routes, fullPath(resourceID)+" "+props["HttpMethod"].(string))
+	}
+	sort.Strings(routes)
+
+	return routes
+}
+
+// policyFor returns the statements of the default policy attached to a function's role.
+func (s testStack) policyFor(t *testing.T, functionID string) []map[string]interface{} {
+	t.Helper()
+
+	function := properties(s.resources(t, "AWS::Lambda::Function")[functionID])
+	var roleID string
+	for ref := range refs(function["Role"]) {
+		roleID = ref
+	}
+
+	var statements []map[string]interface{}
+	for _, policy := range s.resources(t, "AWS::IAM::Policy") {
+		props := properties(policy)
+		if !refs(props["Roles"])[roleID] {
+			continue
+		}
+		document := props["PolicyDocument"].(map[string]interface{})
+		for _, statement := range document["Statement"].([]interface{}) {
+			statements = append(statements, statement.(map[string]interface{}))
+		}
+	}
+
+	if len(statements) == 0 {
+		t.Fatalf("no policy attached to %s", functionID)
+	}
+
+	return statements
+}
+
+func actions(statement map[string]interface{}) []string {
+	switch value := statement["Action"].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var result []string
+		for _, action := range value {
+			result = append(result, action.(string))
+		}
+		return result
+	}
+	return nil
+}
+
+func TestDynamoDBTables(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.ResourceCountIs(jsii.String("AWS::DynamoDB::Table"), jsii.Number(7))
+	})
+
+	tables := []struct {
+		name         string
+		partitionKey string
+	}{
+		{name: common.UserTableName, partitionKey: "username"},
+		{name: common.ProductTableName, partitionKey: "id"},
+		{name: common.RefreshTokenTableName, partitionKey: "tokenHash"},
+		{name: common.RevokedTokenTableName, partitionKey: "id"},
+		{name: common.PasswordResetTableName, partitionKey: "tokenHash"},
+		{name: common.LoginAttemptTableName, partitionKey: "id"},
+		{name: common.RoleTableName, partitionKey: "role"},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			expect(t, func() {
+				s.template.HasResource(jsii.String("AWS::DynamoDB::Table"), map[string]interface{}{
+					"Properties": map[string]interface{}{
+						"TableName": table.name,
+						"KeySchema": []interface{}{
+							map[string]interface{}{"AttributeName": table.partitionKey, "KeyType": "HASH"},
+						},
+						"AttributeDefinitions": assertions.Match_ArrayWith(&[]interface{}{
+							map[string]interface{}{"AttributeName": table.partitionKey, "AttributeType": "S"},
+						}),
+					},
+					"DeletionPolicy": "Delete",
+				})
+			})
+		})
+	}
+}
+
+func TestRefreshTokenTable(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.HasResourceProperties(jsii.String("AWS::DynamoDB::Table"), map[string]interface{}{
+			"TableName": common.RefreshTokenTableName,
+			"TimeToLiveSpecification": map[string]interface{}{
+				"AttributeName": "expiresAt",
+				"Enabled":       true,
+			},
+			"GlobalSecondaryIndexes": []interface{}{
+				map[string]interface{}{
+					"IndexName": common.RefreshTokenFamilyIndex,
+					"KeySchema": []interface{}{
+						map[string]interface{}{"AttributeName": "familyId", "KeyType": "HASH"},
+					},
+					"Projection": map[string]interface{}{"ProjectionType": "KEYS_ONLY"},
+				},
+			},
+		})
+	})
+}
+
+func TestProductTable(t *testing.T) {
+	s := newTestStack(t)
+
+	index := func(name string, partitionKey string, sortKey string) map[string]interface{} {
+		return map[string]interface{}{
+			"IndexName": name,
+			"KeySchema": []interface{}{
+				map[string]interface{}{"AttributeName": partitionKey, "KeyType": "HASH"},
+				map[string]interface{}{"AttributeName": sortKey, "KeyType": "RANGE"},
+			},
+			"Projection": map[string]interface{}{"ProjectionType": "ALL"},
+		}
+	}
+
+	expect(t, func() {
+		s.template.HasResourceProperties(jsii.String("AWS::DynamoDB::Table"), map[string]interface{}{
+			"TableName": common.ProductTableName,
+			"AttributeDefinitions": assertions.Match_ArrayWith(&[]interface{}{
+				map[string]interface{}{"AttributeName": "price", "AttributeType": "N"},
+				map[string]interface{}{"AttributeName": "manager", "AttributeType": "S"},
+			}),
+			"GlobalSecondaryIndexes": []interface{}{
+				index(common.ProductPriceIndex, "catalog", "price"),
+				index(common.ProductNameIndex, "catalog", "nameLower"),
+				index(common.ProductCreatedAtIndex, "catalog", "createdAt"),
+				index(common.ProductManagerIndex, "manager", "createdAt"),
+			},
+		})
+	})
+}
+
+func TestRevokedTokenTable(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.HasResourceProperties(jsii.String("AWS::DynamoDB::Table"), map[string]interface{}{
+			"TableName": common.RevokedTokenTableName,
+			"TimeToLiveSpecification": map[string]interface{}{
+				"AttributeName": "expiresAt",
+				"Enabled":       true,
+			},
+		})
+	})
+}
+
+func TestPasswordResetTable(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.HasResourceProperties(jsii.String("AWS::DynamoDB::Table"), map[string]interface{}{
+			"TableName": common.PasswordResetTableName,
+			"TimeToLiveSpecification": map[string]interface{}{
+				"AttributeName": "expiresAt",
+				"Enabled":       true,
+			},
+		})
+	})
+}
+
+func TestLoginAttemptTable(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.HasResourceProperties(jsii.String("AWS::DynamoDB::Table"), map[string]interface{}{
+			"TableName": common.LoginAttemptTableName,
+			"TimeToLiveSpecification": map[string]interface{}{
+				"AttributeName": "expiresAt",
+				"Enabled":       true,
+			},
+		})
+	})
+}
+
+func TestQueue(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.ResourceCountIs(jsii.String("AWS::SQS::Queue"), jsii.Number(1))
+	})
+	expect(t, func() {
+		s.template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
+			"QueueName":         common.QueueName,
+			"VisibilityTimeout": 300,
+		})
+	})
+}
+
+func TestLambdaFunctions(t *testing.T) {
+	s := newTestStack(t)
+
+	functions := s.resources(t, "AWS::Lambda::Function")
+	if len(functions) != 2 {
+		t.Fatalf("got %d functions, want 2", len(functions))
+	}
+
+	for _, id := range []string{common.UserFunctionName, common.ProductFunctionName} {
+		t.Run(id, func(t *testing.T) {
+			props := properties(functions[s.logicalID(t, id)])
+			if props["Runtime"] != "provided.al2023" {
+				t.Errorf("Runtime = %v, want provided.al2023", props["Runtime"])
+			}
+			if props["Handler"] != "main" {
+				t.Errorf("Handler = %v, want main", props["Handler"])
+			}
+		})
+	}
+}
+
+func TestFunctionPermissions(t *testing.T) {
+	s := newTestStack(t)
+
+	functions := []struct {
+		function string
+		// required actions per construct id; any resource not listed here must not be granted
+		grants map[string][]string
+	}{
+		{
+			function: common.UserFunctionName,
+			grants: map[string][]string{
+				common.UserTableName:          {"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:DeleteItem", "dynamodb:Scan"},
+				common.RefreshTokenTableName:  {"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:Query"},
+				common.RevokedTokenTableName:  {"dynamodb:BatchGetItem", "dynamodb:PutItem"},
+				common.PasswordResetTableName: {"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:DeleteItem"},
+				common.LoginAttemptTableName:  {"dynamodb:BatchGetItem", "dynamodb:UpdateItem", "dynamodb:PutItem", "dynamodb:DeleteItem"},
+				common.RoleTableName:          {"dynamodb:Scan"},
+				common.QueueName:              {"sqs:SendMessage"},
+				common.SigningKeyName:         {"kms:Sign", "kms:GetPublicKey"},
+			},
+		},
+		{
+			function: common.ProductFunctionName,
+			grants: map[string][]string{
+				common.ProductTableName:      {"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:DeleteItem", "dynamodb:Scan", "dynamodb:Query"},
+				common.RevokedTokenTableName: {"dynamodb:BatchGetItem"},
+				common.RoleTableName:         {"dynamodb:Scan"},
+			},
+		},
+	}
+
+	for _, function := range functions {
+		t.Run(function.function, func(t *testing.T) {
+			granted := map[string]map[string]bool{}
+			for _, statement := range s.policyFor(t, s.logicalID(t, function.function)) {
+				for target := range refs(statement["Resource"]) {
+					if target == "AWS::NoValue" {
+						continue
+					}
+					if granted[target] == nil {
+						granted[target] = map[string]bool{}
+					}
+					for _, action := range actions(statement) {
+						granted[target][action] = true
+					}
+				}
+			}
+
+			wanted := map[string]bool{}
+			for id, wantActions := range function.grants {
+				target := s.logicalID(t, id)
+				wanted[target] = true
+				for _, action := range wantActions {
+					if !granted[target][action] {
+						t.Errorf("%s is not granted %s on %s", function.function, action, id)
+					}
+				}
+			}
+
+			for target := range granted {
+				if !wanted[target] {
+					t.Errorf("%s is granted access to %s", function.function, target)
+				}
+			}
+		})
+	}
+}
+
+func TestFunctionEnvironment(t *testing.T) {
+	s := newTestStack(t)
+
+	functions := s.resources(t, "AWS::Lambda::Function")
+	variables := func(id string) map[string]interface{} {
+		environment, _ := properties(functions[s.logicalID(t, id)])["Environment"].(map[string]interface{})
+		variables, _ := environment["Variables"].(map[string]interface{})
+		return variables
+	}
+
+	userVariables := variables(common.UserFunctionName)
+	if !refs(userVariables[common.SigningKeyIdsEnv])[s.logicalID(t, common.SigningKeyName)] {
+		t.Errorf("%s = %v, want a reference to %s", common.SigningKeyIdsEnv, userVariables[common.SigningKeyIdsEnv], common.SigningKeyName)
+	}
+	policies := map[string]string{
+		common.PasswordMinLengthEnv:         common.PasswordMinLength,
+		common.PasswordMinClassesEnv:        common.PasswordMinClasses,
+		common.PasswordHashCostEnv:          common.PasswordHashCost,
+		common.LoginMaxFailuresEnv:          common.LoginMaxFailures,
+		common.LoginMaxIPFailuresEnv:        common.LoginMaxIPFailures,
+		common.LoginFailureWindowEnv:        common.LoginFailureWindow,
+		common.LoginLockoutEnv:              common.LoginLockout,
+		common.LoginMaxLockoutEnv:           common.LoginMaxLockout,
+		common.MfaIssuerEnv:                 common.MfaIssuer,
+		common.MfaRequiredForAdminsEnv:      common.MfaRequiredForAdmins,
+		common.EmailVerificationRequiredEnv: common.EmailVerificationRequired,
+	}
+	for name, want := range policies {
+		if userVariables[name] != want {
+			t.Errorf("%s = %v, want %s", name, userVariables[name], want)
+		}
+	}
+
+	productVariables := variables(common.ProductFunctionName)
+	if _, ok := productVariables[common.SigningKeyIdsEnv]; ok {
+		t.Errorf("%s must not see the signing key", common.ProductFunctionName)
+	}
+	jwksUrl, _ := json.Marshal(productVariables[common.JwksUrlEnv])
+	if !refs(productVariables[common.JwksUrlEnv])[s.logicalID(t, common.UserGatewayName)] || !strings.Contains(string(jwksUrl), common.JwksPath) {
+		t.Errorf("%s = %s, want the user API %s", common.JwksUrlEnv, jwksUrl, common.JwksPath)
+	}
+	if productVariables[common.IfMatchRequiredEnv] != common.IfMatchRequired {
+		t.Errorf("%s = %v, want %s", common.IfMatchRequiredEnv, productVariables[common.IfMatchRequiredEnv], common.IfMatchRequired)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.ResourceCountIs(jsii.String("AWS::SecretsManager::Secret"), jsii.Number(0))
+	})
+	expect(t, func() {
+		s.template.ResourceCountIs(jsii.String("AWS::KMS::Key"), jsii.Number(1))
+	})
+	expect(t, func() {
+		s.template.HasResource(jsii.String("AWS::KMS::Key"), map[string]interface{}{
+			"Properties": map[string]interface{}{
+				"KeySpec":             "RSA_2048",
+				"KeyUsage":            "SIGN_VERIFY",
+				"PendingWindowInDays": 7,
+			},
+			"DeletionPolicy": "Delete",
+		})
+	})
+}
+
+func TestApiRoutes(t *testing.T) {
+	s := newTestStack(t)
+
+	expect(t, func() {
+		s.template.ResourceCountIs(jsii.String("AWS::ApiGateway::RestApi"), jsii.Number(2))
+	})
+
+	apis := []struct {
+		gateway  string
+		function string
+		routes   []string
+	}{
+		{
+			gateway:  common.UserGatewayName,
+			function: common.UserFunctionName,
+			routes: []string{
+				"/.well-known/jwks.json GET",
+				"/list GET",
+				"/login POST",
+				"/login/mfa POST",
+				"/logout POST",
+				"/me GET",
+				"/mfa/setup POST",
+				"/mfa/verify POST",
+				"/password POST",
+				"/password/reset POST",
+				"/password/reset-token POST",
+				"/refresh POST",
+				"/register POST",
+				"/remove DELETE",
+				"/role PUT",
+				"/unlock POST",
+				"/verify POST",
+				"/verify/resend POST",
+			},
+		},
+		{
+			gateway:  common.ProductGatewayName,
+			function: common.ProductFunctionName,
+			routes: []string{
+				"/create POST",
+				"/delete DELETE",
+				"/list GET",
+				"/one GET",
+				"/products GET",
+				"/products POST",
+				"/products/{id} DELETE",
+				"/products/{id} GET",
+				"/products/{id} PATCH",
+				"/products/{id} PUT",
+				"/update PUT",
+			},
+		},
+	}
+
+	for _, api := range apis {
+		t.Run(api.gateway, func(t *testing.T) {
+			apiID := s.logicalID(t, api.gateway)
+
+			got := s.routes(t, apiID)
+			if strings.Join(got, ", ") != strings.Join(api.routes, ", ") {
+				t.Errorf("routes = %v, want %v", got, api.routes)
+			}
+
+			functionID := s.logicalID(t, api.function)
+			for _, method := range s.resources(t, "AWS::ApiGateway::Method") {
+				props := properties(method)
+				if !refs(props["RestApiId"])[apiID] || props["HttpMethod"] == "OPTIONS" {
+					continue
+				}
+				integration := props["Integration"].(map[string]interface{})
+				if integration["Type"] != "AWS_PROXY" || !refs(integration["Uri"])[functionID] {
+					t.Errorf("%v %v is not proxied to %s", props["ResourceId"], props["HttpMethod"], api.function)
+				}
+			}
+		})
+	}
+}
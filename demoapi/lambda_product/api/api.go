@@ -1,229 +1,306 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"lambda-func/common"
 	"lambda-func/database"
 	"lambda-func/types"
 	"net/http"
+	"net/url"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/validate"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
+var errIdMismatch = apierror.Validation("Invalid Request", map[string]string{
+	"id": "must match the product in the path",
+})
+
 type ApiHandler struct {
 	dbStore database.ProductStore
+	// requireIfMatch refuses writes that do not name the version they change
+	requireIfMatch bool
 }
 
-func NewApiHandler(dbStore database.ProductStore) ApiHandler {
+func NewApiHandler(dbStore database.ProductStore, requireIfMatch bool) ApiHandler {
 	return ApiHandler{
-		dbStore: dbStore,
+		dbStore:        dbStore,
+		requireIfMatch: requireIfMatch,
 	}
 }
 
-func (api ApiHandler) CreateProduct(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
-	var createProduct types.CreateProductRequest
-
-	result, err := checkAdmin(userContext)
+func (api ApiHandler) CreateProduct(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+	product, err := api.createProduct(request, userContext)
 	if err != nil {
-		return result, err
+		return response.Error(request, err)
 	}
 
-	err = json.Unmarshal([]byte(request.Body), &createProduct)
+	created, err := productJSON(http.StatusCreated, product)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Invalid Request",
-			StatusCode: http.StatusBadRequest,
-		}, err
+		return created, err
 	}
+	created.Headers["Location"] = productLocation(request, product.Id)
+
+	return created, nil
+}
+
+// createProduct stores the product of the request body, for both the REST and the legacy route.
+func (api ApiHandler) createProduct(request events.APIGatewayProxyRequest, userContext auth.UserContext) (types.Product, error) {
+	var createProduct types.CreateProductRequest
 
-	if createProduct.Name == "" {
-		return events.APIGatewayProxyResponse{
-			Body:       "Invalid Request",
-			StatusCode: http.StatusBadRequest,
-		}, fmt.Errorf("create productrequest is invalid")
+	err := validate.Decode(request.Body, &createProduct)
+	if err != nil {
+		return types.Product{}, err
 	}
 
 	product, err := types.NewProduct(createProduct, userContext.Username)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal Server error",
-			StatusCode: http.StatusConflict,
-		}, fmt.Errorf("error creating database product %w", err)
+		return types.Product{}, fmt.Errorf("error creating database product %w", err)
 	}
 
 	err = api.dbStore.CreateProduct(product)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, fmt.Errorf("error inserting product into the database %w", err)
+		return types.Product{}, fmt.Errorf("error inserting product into the database %w", err)
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       product.Id,
-		StatusCode: http.StatusOK,
-	}, nil
+	return product, nil
 }
 
 func (api ApiHandler) GetProduct(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	productId := request.QueryStringParameters["id"]
-
-	product, err := api.dbStore.GetProduct(productId)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+	product, err := api.dbStore.GetProduct(productId(request))
+	if errors.Is(err, database.ErrProductNotFound) {
+		return response.Error(request, apierror.NotFound("Product not found"))
 	}
-
-	jsonResponse, err := json.Marshal(product)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       string(jsonResponse),
-		StatusCode: http.StatusOK,
-	}, nil
+	return productJSON(http.StatusOK, product)
 }
 
-func (api ApiHandler) UpdateProduct(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
+// UpdateProduct replaces the fields of a product and needs all of them, PatchProduct
+// changes some. The product is the one in the path, or on the legacy route the one
+// named by the id field of the body. Without If-Match the write still fails with a
+// conflict when the product changes between reading and writing it.
+func (api ApiHandler) UpdateProduct(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
 
-	result, err := checkAdmin(userContext)
+	ifMatch, err := api.ifMatch(request)
 	if err != nil {
-		return result, err
+		return response.Error(request, err)
 	}
 
-	var updateProductRequest types.UpdateProductRequest
+	updateProductRequest := types.UpdateProductRequest{Id: request.PathParameters["id"]}
 
-	err = json.Unmarshal([]byte(request.Body), &updateProductRequest)
+	err = validate.Decode(request.Body, &updateProductRequest)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "invalid request",
-			StatusCode: http.StatusBadRequest,
-		}, err
+		return response.Error(request, err)
+	}
+
+	if pathId := request.PathParameters["id"]; pathId != "" && updateProductRequest.Id != pathId {
+		return response.Error(request, errIdMismatch)
 	}
 
 	product, err := api.dbStore.GetProduct(updateProductRequest.Id)
+	if errors.Is(err, database.ErrProductNotFound) {
+		return response.Error(request, apierror.NotFound("Product not found"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
+	}
+	if ifMatch != "" && !matchesIfMatch(ifMatch, product) {
+		return response.Error(request, errPreconditionFailed)
 	}
 
 	product.Name = updateProductRequest.Name
-	product.Description = updateProductRequest.Description
-	product.Price = updateProductRequest.Price
+	product.Description = *updateProductRequest.Description
+	product.Price = *updateProductRequest.Price
 
-	err = api.dbStore.UpdateProduct(product)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+	product, err = api.dbStore.UpdateProduct(product)
+	if errors.Is(err, database.ErrProductNotFound) {
+		return response.Error(request, apierror.NotFound("Product not found"))
+	}
+	if errors.Is(err, database.ErrVersionConflict) && ifMatch != "" {
+		return response.Error(request, errPreconditionFailed)
+	}
+	if errors.Is(err, database.ErrVersionConflict) {
+		return response.Error(request, errProductChanged)
 	}
-
-	jsonResponse, err := json.Marshal(product)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       string(jsonResponse),
-		StatusCode: http.StatusOK,
-	}, nil
+	return productJSON(http.StatusOK, product)
 }
 
-func (api ApiHandler) DeleteProduct(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
+// DeleteProduct removes the product as it was read, so like an update it does not remove
+// a product that another request changed in between, with or without If-Match.
+func (api ApiHandler) DeleteProduct(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
 
-	result, err := checkAdmin(userContext)
+	ifMatch, err := api.ifMatch(request)
 	if err != nil {
-		return result, err
+		return response.Error(request, err)
 	}
 
-	productId := request.QueryStringParameters["id"]
-
-	product, err := api.dbStore.GetProduct(productId)
+	product, err := api.dbStore.GetProduct(productId(request))
+	if errors.Is(err, database.ErrProductNotFound) {
+		return response.Error(request, apierror.NotFound("Product not found"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
+	}
+	if ifMatch != "" && !matchesIfMatch(ifMatch, product) {
+		return response.Error(request, errPreconditionFailed)
 	}
 
 	err = api.dbStore.DeleteProduct(product)
+	if errors.Is(err, database.ErrProductNotFound) {
+		return response.Error(request, apierror.NotFound("Product not found"))
+	}
+	if errors.Is(err, database.ErrVersionConflict) && ifMatch != "" {
+		return response.Error(request, errPreconditionFailed)
+	}
+	if errors.Is(err, database.ErrVersionConflict) {
+		return response.Error(request, errProductChanged)
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
-	successMsg := fmt.Sprintf(`product removed`, productId)
+	successMsg := fmt.Sprintf(`product %s removed`, product.Id)
 
-	return events.APIGatewayProxyResponse{
-		Body:       successMsg,
-		StatusCode: http.StatusOK,
-	}, nil
+	return response.Text(http.StatusOK, successMsg), nil
 }
 
 func (api ApiHandler) ListProducts(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
-	products, err := api.dbStore.ListProducts()
+	limit, cursor, err := parsePage(request)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	query, err := parseProductQuery(request)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	products, nextCursor, err := api.dbStore.ListProductsPage(query, limit, cursor)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return response.Error(request, apierror.BadRequest("Invalid cursor"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
-	var productResponse []types.ProductResponse
+	productResponse := types.ProductListResponse{
+		Items:      []types.ProductResponse{},
+		NextCursor: nextCursor,
+	}
 	for _, product := range products {
-		productResponse = append(productResponse, types.ProductResponse{
+		productResponse.Items = append(productResponse.Items, types.ProductResponse{
 			Id:          product.Id,
 			Name:        product.Name,
 			Description: product.Description,
 			Price:       product.Price,
+			CreatedAt:   product.CreatedAt,
 		})
 	}
 
-	jsonResponse, err := json.Marshal(productResponse)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+	return response.JSON(http.StatusOK, productResponse)
+}
+
+// productId is the product of the path, or of the id query parameter on the legacy routes.
+func productId(request events.APIGatewayProxyRequest) string {
+	if id := request.PathParameters["id"]; id != "" {
+		return id
 	}
+	return request.QueryStringParameters["id"]
+}
 
-	return events.APIGatewayProxyResponse{
-		Body:       string(jsonResponse),
-		StatusCode: http.StatusOK,
-	}, nil
+// productLocation is the URL path of a product. Behind API Gateway the request context
+// path still carries the stage the request path has lost, so it is put back in front.
+func productLocation(request events.APIGatewayProxyRequest, id string) string {
+	stage := strings.TrimSuffix(request.RequestContext.Path, request.Path)
+	return stage + "/products/" + url.PathEscape(id)
 }
 
-func checkAdmin(userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
-	if userContext.Username == "" {
-		return events.APIGatewayProxyResponse{
-			Body:       "Anauthorized error",
-			StatusCode: http.StatusUnauthorized,
-		}, fmt.Errorf("user context is nil")
+func parsePage(request events.APIGatewayProxyRequest) (int, string, error) {
+	limit := common.DefaultPageSize
+
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > common.MaxPageSize {
+			return 0, "", apierror.BadRequest(fmt.Sprintf("limit must be between 1 and %d", common.MaxPageSize))
+		}
+		limit = parsed
 	}
 
-	if userContext.Role != common.RoleAdmin {
-		return events.APIGatewayProxyResponse{
-			Body:       "Anauthorized error",
-			StatusCode: http.StatusUnauthorized,
-		}, fmt.Errorf("user does not have enogth privileges")
+	return limit, request.QueryStringParameters["cursor"], nil
+}
+
+// parseProductQuery reads the filters of a product listing: q, minPrice, maxPrice and
+// manager, and its order: sort, with order=asc (the default) or order=desc.
+func parseProductQuery(request events.APIGatewayProxyRequest) (types.ProductQuery, error) {
+	parameters := request.QueryStringParameters
+
+	query := types.ProductQuery{
+		Search:  strings.TrimSpace(parameters["q"]),
+		Manager: parameters["manager"],
+		Sort:    parameters["sort"],
 	}
 
-	return events.APIGatewayProxyResponse{}, nil
+	if utf8.RuneCountInString(query.Search) > common.MaxSearchLength {
+		return query, apierror.BadRequest(fmt.Sprintf("q must be at most %d characters", common.MaxSearchLength))
+	}
+
+	var err error
+	query.MinPrice, err = parsePrice(parameters, "minPrice")
+	if err != nil {
+		return query, err
+	}
+	query.MaxPrice, err = parsePrice(parameters, "maxPrice")
+	if err != nil {
+		return query, err
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return query, apierror.BadRequest("minPrice must not be above maxPrice")
+	}
+
+	switch query.Sort {
+	case "", common.SortByName, common.SortByPrice, common.SortByCreatedAt:
+	default:
+		return query, apierror.BadRequest(fmt.Sprintf("sort must be one of %s, %s, %s", common.SortByName, common.SortByPrice, common.SortByCreatedAt))
+	}
+
+	switch order := parameters["order"]; {
+	case order == "":
+	case query.Sort == "":
+		return query, apierror.BadRequest("order needs a sort")
+	case order == "asc" || order == "desc":
+		query.Descending = order == "desc"
+	default:
+		return query, apierror.BadRequest("order must be asc or desc")
+	}
+
+	return query, nil
+}
+
+func parsePrice(parameters map[string]string, name string) (*int, error) {
+	value, ok := parameters[name]
+	if !ok {
+		return nil, nil
+	}
+
+	price, err := strconv.Atoi(value)
+	if err != nil || price < 0 {
+		return nil, apierror.BadRequest(fmt.Sprintf("%s must be a whole number of at least 0", name))
+	}
+	return &price, nil
 }
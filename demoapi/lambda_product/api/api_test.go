@@ -0,0 +1,608 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lambda-func/database"
+	"lambda-func/types"
+	"net/http"
+	"reflect"
+	"shared/auth"
+	"shared/response"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var errStore = errors.New("store unavailable")
+
+// errorBody is named here because the handler tests call their responses response.
+type errorBody = response.ErrorBody
+
+type fakeProductStore struct {
+	products map[string]types.Product
+	errs     map[string]error
+	// patches records what PatchProduct was asked to write
+	patches []types.ProductPatch
+	// query is the last query ListProductsPage was asked for
+	query types.ProductQuery
+}
+
+func newFakeProductStore(products ...types.Product) *fakeProductStore {
+	store := &fakeProductStore{
+		products: map[string]types.Product{},
+		errs:     map[string]error{},
+	}
+	for _, product := range products {
+		store.products[product.Id] = product
+	}
+	return store
+}
+
+// ListProductsPage pages over the matching ids in order, leaving the sort to the stores;
+// the cursor is the last id returned.
+func (f *fakeProductStore) ListProductsPage(query types.ProductQuery, limit int, cursor string) ([]types.Product, string, error) {
+	f.query = query
+	if err := f.errs["ListProducts"]; err != nil {
+		return nil, "", err
+	}
+	if _, ok := f.products[cursor]; cursor != "" && !ok {
+		return nil, "", database.ErrInvalidCursor
+	}
+
+	var ids []string
+	for id, product := range f.products {
+		if id > cursor && query.Matches(product) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	nextCursor := ""
+	if len(ids) > limit {
+		ids = ids[:limit]
+		nextCursor = ids[limit-1]
+	}
+
+	var products []types.Product
+	for _, id := range ids {
+		products = append(products, f.products[id])
+	}
+	return products, nextCursor, nil
+}
+
+func (f *fakeProductStore) GetProduct(id string) (types.Product, error) {
+	if err := f.errs["GetProduct"]; err != nil {
+		return types.Product{}, err
+	}
+	product, ok := f.products[id]
+	if !ok {
+		return types.Product{}, database.ErrProductNotFound
+	}
+	return product, nil
+}
+
+func (f *fakeProductStore) CreateProduct(product types.Product) error {
+	if err := f.errs["CreateProduct"]; err != nil {
+		return err
+	}
+	f.products[product.Id] = product
+	return nil
+}
+
+func (f *fakeProductStore) UpdateProduct(product types.Product) (types.Product, error) {
+	if err := f.errs["UpdateProduct"]; err != nil {
+		return types.Product{}, err
+	}
+	stored, ok := f.products[product.Id]
+	if !ok {
+		return types.Product{}, database.ErrProductNotFound
+	}
+	if stored.Version != product.Version {
+		return types.Product{}, database.ErrVersionConflict
+	}
+	product.Version++
+	f.products[product.Id] = product
+	return product, nil
+}
+
+func (f *fakeProductStore) PatchProduct(id string, patch types.ProductPatch) (types.Product, error) {
+	if err := f.errs["PatchProduct"]; err != nil {
+		return types.Product{}, err
+	}
+	product, ok := f.products[id]
+	if !ok {
+		return types.Product{}, database.ErrProductNotFound
+	}
+	if patch.IfVersion != nil && product.Version != *patch.IfVersion {
+		return types.Product{}, database.ErrVersionConflict
+	}
+	f.patches = append(f.patches, patch)
+	f.products[id] = patch.ApplyTo(product)
+	return f.products[id], nil
+}
+
+func (f *fakeProductStore) DeleteProduct(product types.Product) error {
+	if err := f.errs["DeleteProduct"]; err != nil {
+		return err
+	}
+	stored, ok := f.products[product.Id]
+	if !ok {
+		return database.ErrProductNotFound
+	}
+	if stored.Version != product.Version {
+		return database.ErrVersionConflict
+	}
+	delete(f.products, product.Id)
+	return nil
+}
+
+var (
+	adminContext = auth.UserContext{Username: "root", Role: auth.RoleAdmin}
+	userContext  = auth.UserContext{Username: "alice", Role: auth.RoleUser}
+	widget       = types.Product{Id: "p1", Name: "widget", Description: "a widget", Price: 10, Manager: "root", Version: 3}
+)
+
+func decodeBody(t *testing.T, response events.APIGatewayProxyResponse, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(response.Body), v); err != nil {
+		t.Fatalf("body %q is not valid JSON: %v", response.Body, err)
+	}
+}
+
+func TestCreateProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		body       string
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", context: adminContext, body: `{"name":"gadget","description":"a gadget","price":5}`, wantStatus: http.StatusCreated},
+		{name: "malformed json", context: adminContext, body: `{"name":`, wantStatus: http.StatusBadRequest},
+		{name: "empty name", context: adminContext, body: `{"name":"","price":5}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "negative price", context: adminContext, body: `{"name":"gadget","price":-5}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "long description", context: adminContext, body: `{"name":"gadget","description":"` + strings.Repeat("a", 1001) + `"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown field", context: adminContext, body: `{"name":"gadget","manager":"mallory"}`, wantStatus: http.StatusBadRequest},
+		{name: "store fails", context: adminContext, body: `{"name":"gadget"}`, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeProductStore()
+			store.errs["CreateProduct"] = tt.storeErr
+
+			request := events.APIGatewayProxyRequest{
+				Path:           "/products",
+				Body:           tt.body,
+				RequestContext: events.APIGatewayProxyRequestContext{Path: "/prod/products"},
+			}
+
+			response, _ := NewApiHandler(store, false).CreateProduct(request, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusCreated {
+				var body types.Product
+				decodeBody(t, response, &body)
+				product, ok := store.products[body.Id]
+				if !ok {
+					t.Fatalf("product %q was not stored", body.Id)
+				}
+				if product != body || product.Name != "gadget" || product.Price != 5 || product.Manager != adminContext.Username {
+					t.Errorf("stored product = %+v, body = %+v", product, body)
+				}
+				if product.Version != 1 || response.Headers["ETag"] != `"1"` {
+					t.Errorf("version = %d, ETag = %q, want the first version", product.Version, response.Headers["ETag"])
+				}
+				if location := response.Headers["Location"]; location != "/prod/products/"+body.Id {
+					t.Errorf("Location = %q, want the product under the stage", location)
+				}
+			} else if len(store.products) != 0 {
+				t.Errorf("products stored on failure: %v", store.products)
+			}
+		})
+	}
+}
+
+func TestLegacyCreateProduct(t *testing.T) {
+	store := newFakeProductStore()
+	handler := NewApiHandler(store, false)
+
+	response, _ := handler.LegacyCreateProduct(events.APIGatewayProxyRequest{Path: "/create", Body: `{"name":"gadget","price":5}`}, adminContext)
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, http.StatusOK, response.Body)
+	}
+	if product, ok := store.products[response.Body]; !ok || product.Name != "gadget" {
+		t.Errorf("body = %q, want the id of the stored product in %v", response.Body, store.products)
+	}
+	if location := response.Headers["Location"]; location != "" {
+		t.Errorf("Location = %q on the legacy route", location)
+	}
+
+	response, _ = handler.LegacyCreateProduct(events.APIGatewayProxyRequest{Path: "/create", Body: `{"name":""}`}, adminContext)
+	if response.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("invalid product status = %d, want %d", response.StatusCode, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestGetProduct(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		pathId     string
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", id: "p1", wantStatus: http.StatusOK},
+		{name: "path parameter", pathId: "p1", wantStatus: http.StatusOK},
+		{name: "unknown id", id: "p2", wantStatus: http.StatusNotFound},
+		{name: "unknown path parameter", pathId: "p2", wantStatus: http.StatusNotFound},
+		{name: "store fails", id: "p1", storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeProductStore(widget)
+			store.errs["GetProduct"] = tt.storeErr
+			request := events.APIGatewayProxyRequest{
+				PathParameters:        map[string]string{"id": tt.pathId},
+				QueryStringParameters: map[string]string{"id": tt.id},
+			}
+
+			response, _ := NewApiHandler(store, false).GetProduct(request)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.Product
+				decodeBody(t, response, &body)
+				if body != widget {
+					t.Errorf("body = %+v, want %+v", body, widget)
+				}
+				if response.Headers["ETag"] != `"3"` {
+					t.Errorf("ETag = %q, want %q", response.Headers["ETag"], `"3"`)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	tests := []struct {
+		name           string
+		context        auth.UserContext
+		pathId         string
+		body           string
+		ifMatch        string
+		requireIfMatch bool
+		storeErrs      map[string]error
+		// clearsDescription is a body that replaces the description with an empty one
+		clearsDescription bool
+		wantStatus        int
+		wantDetails       map[string]string
+	}{
+		{name: "success", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "path parameter", context: adminContext, pathId: "p1", body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "path parameter and id", context: adminContext, pathId: "p1", body: `{"id":"p1","name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "id other than the path", context: adminContext, pathId: "p1", body: `{"id":"p2","name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown path parameter", context: adminContext, pathId: "p2", body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusNotFound},
+		{name: "malformed json", context: adminContext, body: `[]`, wantStatus: http.StatusBadRequest},
+		{name: "empty name", context: adminContext, body: `{"id":"p1","name":"","description":"better","price":20}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "negative price", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better","price":-1}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "missing id", context: adminContext, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "missing description", context: adminContext, body: `{"id":"p1","name":"widget 2","price":20}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"description": "is required"}},
+		{name: "empty description", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"","price":20}`, wantStatus: http.StatusOK, clearsDescription: true},
+		{name: "missing price", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better"}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"price": "is required"}},
+		{name: "null price", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better","price":null}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"price": "is required"}},
+		{name: "unknown field", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better","price":20,"manager":"mallory"}`, wantStatus: http.StatusBadRequest},
+		{name: "get fails", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better","price":20}`, storeErrs: map[string]error{"GetProduct": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "unknown id", context: adminContext, body: `{"id":"p2","name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusNotFound},
+		{name: "current version", context: adminContext, pathId: "p1", ifMatch: `"3"`, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "one of several versions", context: adminContext, pathId: "p1", ifMatch: `"1", "3"`, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "any version", context: adminContext, pathId: "p1", ifMatch: "*", body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "stale version", context: adminContext, pathId: "p1", ifMatch: `"2"`, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "weak tag", context: adminContext, pathId: "p1", ifMatch: `W/"3"`, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "If-Match required", context: adminContext, pathId: "p1", requireIfMatch: true, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusPreconditionRequired},
+		{name: "If-Match required and sent", context: adminContext, pathId: "p1", requireIfMatch: true, ifMatch: `"3"`, body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK},
+		{name: "changed while updating", context: adminContext, pathId: "p1", body: `{"name":"widget 2","description":"better","price":20}`, storeErrs: map[string]error{"UpdateProduct": database.ErrVersionConflict}, wantStatus: http.StatusConflict},
+		{name: "changed while updating with If-Match", context: adminContext, pathId: "p1", ifMatch: `"3"`, body: `{"name":"widget 2","description":"better","price":20}`, storeErrs: map[string]error{"UpdateProduct": database.ErrVersionConflict}, wantStatus: http.StatusPreconditionFailed},
+		{name: "removed while updating", context: adminContext, pathId: "p1", body: `{"name":"widget 2","description":"better","price":20}`, storeErrs: map[string]error{"UpdateProduct": database.ErrProductNotFound}, wantStatus: http.StatusNotFound},
+		{name: "update fails", context: adminContext, body: `{"id":"p1","name":"widget 2","description":"better","price":20}`, storeErrs: map[string]error{"UpdateProduct": errStore}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeProductStore(widget)
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+
+			request := events.APIGatewayProxyRequest{
+				PathParameters: map[string]string{"id": tt.pathId},
+				Headers:        map[string]string{"If-Match": tt.ifMatch},
+				Body:           tt.body,
+			}
+
+			response, _ := NewApiHandler(store, tt.requireIfMatch).UpdateProduct(request, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			for field, message := range tt.wantDetails {
+				var body errorBody
+				decodeBody(t, response, &body)
+				if body.Details[field] != message {
+					t.Errorf("details = %v, want %s %q", body.Details, field, message)
+				}
+			}
+			if tt.wantStatus == http.StatusOK {
+				want := types.Product{Id: "p1", Name: "widget 2", Description: "better", Price: 20, Manager: "root", Version: 4}
+				if tt.clearsDescription {
+					want.Description = ""
+				}
+				var body types.Product
+				decodeBody(t, response, &body)
+				if body != want {
+					t.Errorf("body = %+v, want %+v", body, want)
+				}
+				if store.products["p1"] != want {
+					t.Errorf("stored product = %+v, want %+v", store.products["p1"], want)
+				}
+				if response.Headers["ETag"] != `"4"` {
+					t.Errorf("ETag = %q, want %q", response.Headers["ETag"], `"4"`)
+				}
+			} else if store.products["p1"] != widget && tt.storeErrs["UpdateProduct"] == nil {
+				t.Errorf("product changed on failure: %+v", store.products["p1"])
+			}
+		})
+	}
+}
+
+func TestPatchProduct(t *testing.T) {
+	patched := func(change func(product *types.Product)) types.Product {
+		product := widget
+		change(&product)
+		product.Version++
+		return product
+	}
+
+	tests := []struct {
+		name           string
+		pathId         string
+		contentType    string
+		ifMatch        string
+		requireIfMatch bool
+		body           string
+		storeErrs      map[string]error
+		wantStatus     int
+		wantDetails    map[string]string
+		want           types.Product
+		wantFields     []string
+	}{
+		{name: "one field", pathId: "p1", body: `{"price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "every field", pathId: "p1", body: `{"name":"widget 2","description":"better","price":20}`, wantStatus: http.StatusOK, want: types.Product{Id: "p1", Name: "widget 2", Description: "better", Price: 20, Manager: "root", Version: 4}, wantFields: []string{"name", "description", "price"}},
+		{name: "zero price", pathId: "p1", body: `{"price":0}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 0 }), wantFields: []string{"price"}},
+		{name: "description removed", pathId: "p1", body: `{"description":null}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Description = "" }), wantFields: []string{"description"}},
+		{name: "empty patch", pathId: "p1", body: `{}`, wantStatus: http.StatusOK, want: widget},
+		{name: "same id", pathId: "p1", body: `{"id":"p1","price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "merge patch media type", pathId: "p1", contentType: "application/merge-patch+json", body: `{"price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "json with charset", pathId: "p1", contentType: "application/json; charset=utf-8", body: `{"price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "json patch media type", pathId: "p1", contentType: "application/json-patch+json", body: `[{"op":"remove","path":"/price"}]`, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "other id", pathId: "p1", body: `{"id":"p2"}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"id": "must match the product in the path"}},
+		{name: "name removed", pathId: "p1", body: `{"name":null}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"name": "cannot be removed"}},
+		{name: "price removed", pathId: "p1", body: `{"price":null}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"price": "cannot be removed"}},
+		{name: "empty name", pathId: "p1", body: `{"name":" "}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"name": "is required"}},
+		{name: "negative price", pathId: "p1", body: `{"price":-1}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"price": "must be at least 0"}},
+		{name: "long description", pathId: "p1", body: `{"description":"` + strings.Repeat("a", 1001) + `"}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"description": "must be at most 1000 characters"}},
+		{name: "wrong type", pathId: "p1", body: `{"price":"cheap"}`, wantStatus: http.StatusBadRequest},
+		{name: "not an object", pathId: "p1", body: `["price"]`, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", pathId: "p1", body: `{"manager":"mallory"}`, wantStatus: http.StatusBadRequest},
+		{name: "current version", pathId: "p1", ifMatch: `"3"`, body: `{"price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "any version", pathId: "p1", ifMatch: "*", body: `{"price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "stale version", pathId: "p1", ifMatch: `"2"`, body: `{"price":20}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "If-Match required", pathId: "p1", requireIfMatch: true, body: `{"price":20}`, wantStatus: http.StatusPreconditionRequired},
+		{name: "If-Match required and sent", pathId: "p1", requireIfMatch: true, ifMatch: `"3"`, body: `{"price":20}`, wantStatus: http.StatusOK, want: patched(func(p *types.Product) { p.Price = 20 }), wantFields: []string{"price"}},
+		{name: "If-Match on unknown id", pathId: "p2", ifMatch: `"3"`, body: `{"price":20}`, wantStatus: http.StatusNotFound},
+		{name: "changed while patching", pathId: "p1", ifMatch: `"3"`, body: `{"price":20}`, storeErrs: map[string]error{"PatchProduct": database.ErrVersionConflict}, wantStatus: http.StatusPreconditionFailed},
+		{name: "unknown id", pathId: "p2", body: `{"price":20}`, wantStatus: http.StatusNotFound},
+		{name: "patch fails", pathId: "p1", body: `{"price":20}`, storeErrs: map[string]error{"PatchProduct": errStore}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeProductStore(widget)
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+			request := events.APIGatewayProxyRequest{
+				PathParameters: map[string]string{"id": tt.pathId},
+				Headers:        map[string]string{},
+				Body:           tt.body,
+			}
+			if tt.contentType != "" {
+				request.Headers["content-type"] = tt.contentType
+			}
+			if tt.ifMatch != "" {
+				request.Headers["if-match"] = tt.ifMatch
+			}
+
+			response, _ := NewApiHandler(store, tt.requireIfMatch).PatchProduct(request, adminContext)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			for field, message := range tt.wantDetails {
+				var body errorBody
+				decodeBody(t, response, &body)
+				if body.Details[field] != message {
+					t.Errorf("details = %v, want %s %q", body.Details, field, message)
+				}
+			}
+			if tt.wantStatus != http.StatusOK {
+				if store.products["p1"] != widget {
+					t.Errorf("product changed on failure: %+v", store.products["p1"])
+				}
+				return
+			}
+
+			var body types.Product
+			decodeBody(t, response, &body)
+			if body != tt.want || store.products["p1"] != tt.want {
+				t.Errorf("body = %+v, stored = %+v, want %+v", body, store.products["p1"], tt.want)
+			}
+			if etag := fmt.Sprintf(`"%d"`, tt.want.Version); response.Headers["ETag"] != etag {
+				t.Errorf("ETag = %q, want %q", response.Headers["ETag"], etag)
+			}
+
+			var fields []string
+			for _, patch := range store.patches {
+				if patch.Name.Set {
+					fields = append(fields, "name")
+				}
+				if patch.Description.Set {
+					fields = append(fields, "description")
+				}
+				if patch.Price.Set {
+					fields = append(fields, "price")
+				}
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("patched fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestDeleteProduct(t *testing.T) {
+	tests := []struct {
+		name           string
+		context        auth.UserContext
+		ifMatch        string
+		requireIfMatch bool
+		storeErrs      map[string]error
+		wantStatus     int
+	}{
+		{name: "success", context: adminContext, wantStatus: http.StatusOK},
+		{name: "get fails", context: adminContext, storeErrs: map[string]error{"GetProduct": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "unknown id", context: adminContext, storeErrs: map[string]error{"GetProduct": database.ErrProductNotFound}, wantStatus: http.StatusNotFound},
+		{name: "delete fails", context: adminContext, storeErrs: map[string]error{"DeleteProduct": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "current version", context: adminContext, ifMatch: `"3"`, wantStatus: http.StatusOK},
+		{name: "stale version", context: adminContext, ifMatch: `"2"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "If-Match required", context: adminContext, requireIfMatch: true, wantStatus: http.StatusPreconditionRequired},
+		{name: "If-Match required and sent", context: adminContext, requireIfMatch: true, ifMatch: `"3"`, wantStatus: http.StatusOK},
+		{name: "changed while deleting", context: adminContext, storeErrs: map[string]error{"DeleteProduct": database.ErrVersionConflict}, wantStatus: http.StatusConflict},
+		{name: "changed while deleting with If-Match", context: adminContext, ifMatch: `"3"`, storeErrs: map[string]error{"DeleteProduct": database.ErrVersionConflict}, wantStatus: http.StatusPreconditionFailed},
+		{name: "removed while deleting", context: adminContext, storeErrs: map[string]error{"DeleteProduct": database.ErrProductNotFound}, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeProductStore(widget)
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+			request := events.APIGatewayProxyRequest{
+				QueryStringParameters: map[string]string{"id": "p1"},
+				Headers:               map[string]string{"If-Match": tt.ifMatch},
+			}
+
+			response, _ := NewApiHandler(store, tt.requireIfMatch).DeleteProduct(request, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			_, stillThere := store.products["p1"]
+			if tt.wantStatus == http.StatusOK && stillThere {
+				t.Error("product was not deleted")
+			}
+			if tt.wantStatus != http.StatusOK && !stillThere {
+				t.Error("product was deleted on failure")
+			}
+		})
+	}
+}
+
+func TestListProducts(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          map[string]string
+		storeErr       error
+		wantStatus     int
+		wantIds        []string
+		wantNextCursor string
+		wantQuery      types.ProductQuery
+	}{
+		{name: "success", wantStatus: http.StatusOK, wantIds: []string{"p1", "p2", "p3"}},
+		{name: "first page", query: map[string]string{"limit": "2"}, wantStatus: http.StatusOK, wantIds: []string{"p1", "p2"}, wantNextCursor: "p2"},
+		{name: "last page", query: map[string]string{"limit": "2", "cursor": "p2"}, wantStatus: http.StatusOK, wantIds: []string{"p3"}},
+		{name: "limit not a number", query: map[string]string{"limit": "ten"}, wantStatus: http.StatusBadRequest},
+		{name: "limit too large", query: map[string]string{"limit": "1000"}, wantStatus: http.StatusBadRequest},
+		{name: "invalid cursor", query: map[string]string{"cursor": "p9"}, wantStatus: http.StatusBadRequest},
+		{name: "search", query: map[string]string{"q": " GAD "}, wantStatus: http.StatusOK, wantIds: []string{"p2"}, wantQuery: types.ProductQuery{Search: "GAD"}},
+		{name: "price range", query: map[string]string{"minPrice": "15", "maxPrice": "30"}, wantStatus: http.StatusOK, wantIds: []string{"p2", "p3"}, wantQuery: types.ProductQuery{MinPrice: intPointer(15), MaxPrice: intPointer(30)}},
+		{name: "free products", query: map[string]string{"maxPrice": "0"}, wantStatus: http.StatusOK, wantQuery: types.ProductQuery{MaxPrice: intPointer(0)}},
+		{name: "manager", query: map[string]string{"manager": "root"}, wantStatus: http.StatusOK, wantIds: []string{"p1"}, wantQuery: types.ProductQuery{Manager: "root"}},
+		{name: "sort", query: map[string]string{"sort": "price"}, wantStatus: http.StatusOK, wantIds: []string{"p1", "p2", "p3"}, wantQuery: types.ProductQuery{Sort: "price"}},
+		{name: "sort descending", query: map[string]string{"sort": "createdAt", "order": "desc"}, wantStatus: http.StatusOK, wantIds: []string{"p1", "p2", "p3"}, wantQuery: types.ProductQuery{Sort: "createdAt", Descending: true}},
+		{name: "sort ascending", query: map[string]string{"sort": "name", "order": "asc"}, wantStatus: http.StatusOK, wantIds: []string{"p1", "p2", "p3"}, wantQuery: types.ProductQuery{Sort: "name"}},
+		{name: "unknown sort", query: map[string]string{"sort": "manager"}, wantStatus: http.StatusBadRequest},
+		{name: "unknown order", query: map[string]string{"sort": "name", "order": "up"}, wantStatus: http.StatusBadRequest},
+		{name: "order without sort", query: map[string]string{"order": "desc"}, wantStatus: http.StatusBadRequest},
+		{name: "price not a number", query: map[string]string{"minPrice": "cheap"}, wantStatus: http.StatusBadRequest},
+		{name: "negative price", query: map[string]string{"maxPrice": "-1"}, wantStatus: http.StatusBadRequest},
+		{name: "empty range", query: map[string]string{"minPrice": "30", "maxPrice": "15"}, wantStatus: http.StatusBadRequest},
+		{name: "long search", query: map[string]string{"q": strings.Repeat("a", 101)}, wantStatus: http.StatusBadRequest},
+		{name: "store fails", storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeProductStore(
+				widget,
+				types.Product{Id: "p2", Name: "gadget", Price: 20},
+				types.Product{Id: "p3", Name: "gizmo", Price: 30},
+			)
+			store.errs["ListProducts"] = tt.storeErr
+			request := events.APIGatewayProxyRequest{QueryStringParameters: tt.query}
+
+			response, _ := NewApiHandler(store, false).ListProducts(request)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.ProductListResponse
+				decodeBody(t, response, &body)
+
+				var ids []string
+				for _, item := range body.Items {
+					ids = append(ids, item.Id)
+					want := types.ProductResponse{Id: "p1", Name: "widget", Description: "a widget", Price: 10}
+					if item.Id == want.Id && item != want {
+						t.Errorf("item = %+v, want %+v", item, want)
+					}
+				}
+				if strings.Join(ids, ",") != strings.Join(tt.wantIds, ",") {
+					t.Errorf("ids = %v, want %v", ids, tt.wantIds)
+				}
+				if body.NextCursor != tt.wantNextCursor {
+					t.Errorf("nextCursor = %q, want %q", body.NextCursor, tt.wantNextCursor)
+				}
+				if !reflect.DeepEqual(store.query, tt.wantQuery) {
+					t.Errorf("query = %+v, want %+v", store.query, tt.wantQuery)
+				}
+			}
+		})
+	}
+}
+
+func intPointer(value int) *int {
+	return &value
+}
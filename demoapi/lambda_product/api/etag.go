@@ -0,0 +1,58 @@
+package api
+
+import (
+	"lambda-func/types"
+	"shared/apierror"
+	"shared/response"
+	"shared/router"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var errPreconditionFailed = apierror.PreconditionFailed("Product has changed, fetch it again for its current ETag")
+
+var errProductChanged = apierror.Conflict("Product was changed by another request, fetch it again and retry")
+
+// productETag is a strong entity tag made of the product version.
+func productETag(product types.Product) string {
+	return `"` + strconv.Itoa(product.Version) + `"`
+}
+
+// productJSON is the JSON response of a single product, tagged with its ETag.
+func productJSON(statusCode int, product types.Product) (events.APIGatewayProxyResponse, error) {
+	productResponse, err := response.JSON(statusCode, product)
+	if err != nil {
+		return productResponse, err
+	}
+	productResponse.Headers["ETag"] = productETag(product)
+
+	return productResponse, nil
+}
+
+// ifMatch returns the If-Match header of a write, which has to be there when the
+// handler requires conditional writes.
+func (api ApiHandler) ifMatch(request events.APIGatewayProxyRequest) (string, error) {
+	value := strings.TrimSpace(router.Header(request.Headers, "If-Match"))
+	if value == "" && api.requireIfMatch {
+		return "", apierror.PreconditionRequired("If-Match with the ETag of the product is required")
+	}
+	return value, nil
+}
+
+// matchesIfMatch compares the way RFC 9110 says If-Match does: * matches any product,
+// otherwise one of the listed tags has to be the ETag, and weak tags never match.
+func matchesIfMatch(ifMatch string, product types.Product) bool {
+	if ifMatch == "*" {
+		return true
+	}
+
+	etag := productETag(product)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
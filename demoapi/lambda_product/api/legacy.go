@@ -0,0 +1,38 @@
+package api
+
+import (
+	"lambda-func/common"
+	"net/http"
+	"shared/auth"
+	"shared/response"
+	"shared/router"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Deprecated marks the responses of the verb-style routes that /products replaces,
+// with the Deprecation (RFC 9745) and Sunset (RFC 8594) headers.
+func Deprecated(next router.Handler) router.Handler {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+
+		if response.Headers == nil {
+			response.Headers = map[string]string{}
+		}
+		response.Headers["Deprecation"] = common.LegacyRoutesDeprecation
+		response.Headers["Sunset"] = common.LegacyRoutesSunset
+
+		return response, err
+	}
+}
+
+// LegacyCreateProduct is /create as it always answered, a 200 with the id of the new
+// product as plain text, so its clients keep working until the sunset.
+func (api ApiHandler) LegacyCreateProduct(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+	product, err := api.createProduct(request, userContext)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	return response.Text(http.StatusOK, product.Id), nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"lambda-func/database"
+	"lambda-func/types"
+	"mime"
+	"net/http"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/router"
+	"shared/validate"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const mergePatchMediaType = "application/merge-patch+json"
+
+// PatchProduct applies a JSON Merge Patch (RFC 7396) to a product. Only the fields in
+// the patch are written, a name or price of null is refused as neither can be removed.
+// With If-Match the patch only applies to the version the ETag names.
+func (api ApiHandler) PatchProduct(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+
+	if !acceptsMergePatch(request.Headers) {
+		return response.Error(request, apierror.UnsupportedMediaType("Content-Type must be "+mergePatchMediaType))
+	}
+
+	ifMatch, err := api.ifMatch(request)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	var patch types.ProductPatch
+
+	err = validate.Decode(request.Body, &patch)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	id := productId(request)
+
+	err = validatePatch(id, patch)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	// the patch is written atomically, so the product is only read to check the ETag
+	if ifMatch != "" && ifMatch != "*" {
+		current, err := api.dbStore.GetProduct(id)
+		if errors.Is(err, database.ErrProductNotFound) {
+			return response.Error(request, apierror.NotFound("Product not found"))
+		}
+		if err != nil {
+			return response.Error(request, err)
+		}
+		if !matchesIfMatch(ifMatch, current) {
+			return response.Error(request, errPreconditionFailed)
+		}
+		patch.IfVersion = &current.Version
+	}
+
+	product, err := api.dbStore.PatchProduct(id, patch)
+	if errors.Is(err, database.ErrProductNotFound) {
+		return response.Error(request, apierror.NotFound("Product not found"))
+	}
+	if errors.Is(err, database.ErrVersionConflict) {
+		return response.Error(request, errPreconditionFailed)
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	return productJSON(http.StatusOK, product)
+}
+
+// acceptsMergePatch takes the merge patch media type, plain JSON, which clients often
+// send for the same document, and a request without a Content-Type.
+func acceptsMergePatch(headers map[string]string) bool {
+	contentType := router.Header(headers, "Content-Type")
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == mergePatchMediaType || mediaType == "application/json")
+}
+
+// validatePatch holds the fields of patch to the rules of types.CreateProductRequest.
+func validatePatch(id string, patch types.ProductPatch) error {
+	details := map[string]string{}
+
+	if patch.Id.Set && (patch.Id.Null || patch.Id.Value != id) {
+		details["id"] = "must match the product in the path"
+	}
+	if patch.Name.Null {
+		details["name"] = "cannot be removed"
+	}
+	if patch.Price.Null {
+		details["price"] = "cannot be removed"
+	}
+
+	fields := []struct {
+		name    string
+		patched bool
+		value   interface{}
+		rules   string
+	}{
+		{name: "name", patched: patch.Name.Set && !patch.Name.Null, value: patch.Name.Value, rules: "required,max=100"},
+		{name: "description", patched: patch.Description.Set && !patch.Description.Null, value: patch.Description.Value, rules: "max=1000"},
+		{name: "price", patched: patch.Price.Set && !patch.Price.Null, value: patch.Price.Value, rules: "min=0"},
+	}
+	for _, field := range fields {
+		if !field.patched {
+			continue
+		}
+
+		message, err := validate.Value(field.value, field.rules)
+		if err != nil {
+			return err
+		}
+		if message != "" {
+			details[field.name] = message
+		}
+	}
+
+	if len(details) > 0 {
+		return apierror.Validation("Invalid Request", details)
+	}
+	return nil
+}
@@ -1,19 +1,88 @@
 package app
 
 import (
+	"errors"
 	"lambda-func/api"
+	"lambda-func/common"
 	"lambda-func/database"
+	"lambda-func/jwks"
+	"log"
+	"os"
+	"shared/auth"
 )
 
 type App struct {
 	ApiHandler api.ApiHandler
+	Middleware auth.Middleware
+	Roles      *auth.Roles
 }
 
-func NewApp() App {
-	db := database.NewDynamoDB()
-	apiHandler := api.NewApiHandler(db)
+type Config struct {
+	StoreBackend string
+	JwksUrl      string
+	JwksFile     string
+	RolesFile    string
+
+	IfMatchRequired bool
+}
+
+func ConfigFromEnv() Config {
+	return Config{
+		StoreBackend: os.Getenv(common.StoreBackendEnv),
+		JwksUrl:      os.Getenv(common.JwksUrlEnv),
+		JwksFile:     os.Getenv(common.JwksFileEnv),
+		RolesFile:    os.Getenv(common.RolesFileEnv),
+
+		IfMatchRequired: os.Getenv(common.IfMatchRequiredEnv) == "true",
+	}
+}
+
+func NewApp(config Config) App {
+	var db database.ProductStore
+	var revocations database.RevocationStore
+	var roleSource auth.RoleSource
+	if config.StoreBackend == common.BackendMemory {
+		store := database.NewMemoryStore()
+		db, revocations = store, store
+	} else {
+		store := database.NewDynamoDB()
+		db, revocations = store, store
+		roleSource = store
+	}
+
+	// a roles file takes the place of the role table, local runs without either get the built-in roles
+	if config.RolesFile != "" {
+		roleSource = auth.NewFileRoleSource(config.RolesFile)
+	}
+
+	apiHandler := api.NewApiHandler(db, config.IfMatchRequired)
 
 	return App{
 		ApiHandler: apiHandler,
+		Middleware: auth.NewMiddleware(newKeySet(config), revocations, auth.ProductApiAudience),
+		Roles:      auth.NewRoles(roleSource, common.RoleCacheTTL),
+	}
+}
+
+func newKeySet(config Config) auth.KeyResolver {
+	switch {
+	case config.JwksUrl != "":
+		keys := jwks.NewRemoteKeySet(config.JwksUrl)
+
+		// warm the cache during cold start so the first request does not pay for it
+		if _, err := keys.PublicKey(""); err != nil && !errors.Is(err, auth.ErrUnknownKey) {
+			log.Printf("signing keys are not available yet: %v", err)
+		}
+
+		return keys
+	case config.JwksFile != "":
+		keys, err := jwks.NewFileKeySet(config.JwksFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return keys
+	default:
+		log.Fatalf("%s or %s must point at the user service signing keys", common.JwksUrlEnv, common.JwksFileEnv)
+		return nil
 	}
 }
@@ -1,13 +1,41 @@
 package common
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
 const ProductTableName = "JITestDemoProductTable"
-const TokenSecret = "very-strong-secret"
-const RoleUser = "user"
-const RoleAdmin = "admin"
+const RevokedTokenTableName = "JITestDemoRevokedTokenTable"
+const RoleTableName = "JITestDemoRoleTable"
+const LocalModeEnv = "LOCAL_MODE"
+const LocalAddrEnv = "LOCAL_ADDR"
+const DynamoDBEndpointEnv = "DYNAMODB_ENDPOINT"
+const StoreBackendEnv = "STORE_BACKEND"
+const BackendMemory = "memory"
+const JwksUrlEnv = "JWKS_URL"
+const JwksFileEnv = "JWKS_FILE"
+const RolesFileEnv = "ROLES_FILE"
+const IfMatchRequiredEnv = "IF_MATCH_REQUIRED"
+const DefaultPageSize = 50
+const MaxPageSize = 100
+const MaxSearchLength = 100
+const MaxListReads = 10
+const SortByName = "name"
+const SortByPrice = "price"
+const SortByCreatedAt = "createdAt"
+const CreatedAtLayout = "2006-01-02T15:04:05.000Z07:00"
+const ProductCatalog = "product"
+const ProductPriceIndex = "catalog-price-index"
+const ProductNameIndex = "catalog-nameLower-index"
+const ProductCreatedAtIndex = "catalog-createdAt-index"
+const ProductManagerIndex = "manager-createdAt-index"
+const JwksCacheTTL = 15 * time.Minute
+const JwksRefreshInterval = time.Minute
+const RoleCacheTTL = 5 * time.Minute
+const LegacyRoutesDeprecation = "@1792281600"
+const LegacyRoutesSunset = "Fri, 30 Apr 2027 00:00:00 GMT"
 
 func GenerateStrignID() string {
 	id := uuid.New()
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/common"
+	"lambda-func/types"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// derivedAttributes are written next to the product fields for the listing indexes and the
+// search. Products stored before the listing could filter and sort have none of them.
+var derivedAttributes = []string{"catalog", "nameLower", "descriptionLower", "createdAt"}
+
+// BackfillProducts writes the derived attributes to the products that miss any of them and
+// returns how many it changed. Until then such products are left out of sorted, filtered and
+// searched listings. Running it again is harmless.
+func (p DynamoDBClient) BackfillProducts() (int, error) {
+	filter := expression.AttributeNotExists(expression.Name(derivedAttributes[0]))
+	for _, attribute := range derivedAttributes[1:] {
+		filter = filter.Or(expression.AttributeNotExists(expression.Name(attribute)))
+	}
+
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	var products []types.Product
+	var unmarshalErr error
+
+	err = p.databaseStore.ScanPages(&dynamodb.ScanInput{
+		TableName:                 aws.String(common.ProductTableName),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var product types.Product
+			unmarshalErr = dynamodbattribute.UnmarshalMap(item, &product)
+
+			if unmarshalErr != nil {
+				return false
+			}
+
+			products = append(products, product)
+		}
+		return true
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if unmarshalErr != nil {
+		return 0, unmarshalErr
+	}
+
+	backfilled := 0
+	for _, product := range products {
+		written, err := p.backfillProduct(product)
+		if err != nil {
+			return backfilled, err
+		}
+		if written {
+			backfilled++
+		}
+	}
+
+	return backfilled, nil
+}
+
+// backfillProduct writes the derived attributes of product as it was read. It leaves the
+// version alone, so ETags handed out stay valid, and skips a product that changed or went
+// since, as that write already took care of it.
+func (p DynamoDBClient) backfillProduct(product types.Product) (bool, error) {
+	createdAt := expression.IfNotExists(expression.Name("createdAt"), expression.Value(time.Now().UTC().Format(common.CreatedAtLayout)))
+	update := expression.Set(expression.Name("catalog"), expression.Value(common.ProductCatalog))
+	update = update.Set(expression.Name("nameLower"), expression.Value(searchable(product.Name)))
+	update = update.Set(expression.Name("descriptionLower"), expression.Value(searchable(product.Description)))
+	update = update.Set(expression.Name("createdAt"), createdAt)
+
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(versionCondition(product.Version)).Build()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = p.databaseStore.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(common.ProductTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(product.Id),
+			},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	})
+
+	var failed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// missesDerivedAttributes tells whether item was stored without some of the derived attributes.
+func missesDerivedAttributes(item map[string]*dynamodb.AttributeValue) bool {
+	for _, attribute := range derivedAttributes {
+		if item[attribute] == nil {
+			return true
+		}
+	}
+	return false
+}
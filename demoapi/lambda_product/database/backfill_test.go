@@ -0,0 +1,130 @@
+package database
+
+import (
+	"lambda-func/types"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// legacyTable answers like a product table that holds items written before the listing indexes.
+type legacyTable struct {
+	dynamodbiface.DynamoDBAPI
+	items   []map[string]*dynamodb.AttributeValue
+	updates []*dynamodb.UpdateItemInput
+	errs    map[string]error
+}
+
+func (l *legacyTable) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	fn(&dynamodb.ScanOutput{Items: l.items}, true)
+	return nil
+}
+
+func (l *legacyTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	l.updates = append(l.updates, input)
+	if err := l.errs[*input.Key["id"].S]; err != nil {
+		return nil, err
+	}
+
+	// a patch of the price alone leaves the lower case copies out
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+		"id":        {S: input.Key["id"].S},
+		"name":      {S: aws.String("Widget Gadget")},
+		"price":     {N: aws.String("20")},
+		"version":   {N: aws.String("1")},
+		"catalog":   {S: aws.String("product")},
+		"createdAt": {S: aws.String("2024-01-01T00:00:00.000Z")},
+	}}, nil
+}
+
+func legacyItem(id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id":          {S: aws.String(id)},
+		"name":        {S: aws.String("Widget Gadget")},
+		"description": {S: aws.String("A Small Widget")},
+		"price":       {N: aws.String("10")},
+		"manager":     {S: aws.String("alice")},
+	}
+}
+
+// assertBackfilled checks that update writes every derived attribute of the legacy item.
+func assertBackfilled(t *testing.T, update *dynamodb.UpdateItemInput) {
+	t.Helper()
+
+	names := map[string]bool{}
+	for _, name := range update.ExpressionAttributeNames {
+		names[*name] = true
+	}
+	for _, attribute := range append(derivedAttributes, "version") {
+		if !names[attribute] {
+			t.Errorf("update does not name %s: %v", attribute, update.ExpressionAttributeNames)
+		}
+	}
+
+	values := map[string]bool{}
+	for _, value := range update.ExpressionAttributeValues {
+		if value.S != nil {
+			values[*value.S] = true
+		}
+	}
+	for _, want := range []string{"product", "widget gadget", "a small widget"} {
+		if !values[want] {
+			t.Errorf("update does not write %q", want)
+		}
+	}
+}
+
+func TestBackfillProducts(t *testing.T) {
+	table := &legacyTable{
+		items: []map[string]*dynamodb.AttributeValue{legacyItem("1"), legacyItem("2")},
+		errs: map[string]error{
+			"2": &dynamodb.ConditionalCheckFailedException{},
+		},
+	}
+	store := DynamoDBClient{databaseStore: table}
+
+	backfilled, err := store.BackfillProducts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the second product changed since the scan, so its own write has filled it in
+	if backfilled != 1 {
+		t.Errorf("expected 1 product backfilled, got %d", backfilled)
+	}
+	if len(table.updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(table.updates))
+	}
+	if id := *table.updates[0].Key["id"].S; id != "1" {
+		t.Errorf("expected product 1 updated, got %s", id)
+	}
+	assertBackfilled(t, table.updates[0])
+}
+
+func TestPatchProductBackfillsLegacyItem(t *testing.T) {
+	table := &legacyTable{}
+	store := DynamoDBClient{databaseStore: table}
+
+	product, err := store.PatchProduct("1", types.ProductPatch{Price: types.Patched[int]{Value: 20, Set: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if product.Price != 20 {
+		t.Errorf("expected price 20, got %d", product.Price)
+	}
+
+	if len(table.updates) != 2 {
+		t.Fatalf("expected the patch and a backfill, got %d updates", len(table.updates))
+	}
+
+	backfill := table.updates[1]
+	names := map[string]bool{}
+	for _, name := range backfill.ExpressionAttributeNames {
+		names[*name] = true
+	}
+	if !names["nameLower"] || !names["descriptionLower"] {
+		t.Errorf("backfill does not write the lower case copies: %v", backfill.ExpressionAttributeNames)
+	}
+}
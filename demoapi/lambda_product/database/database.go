@@ -1,35 +1,66 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"lambda-func/common"
 	"lambda-func/types"
+	"log"
+	"os"
+	"shared/auth"
+	"shared/pagination"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
+var ErrProductNotFound = errors.New("product not found")
+var ErrVersionConflict = errors.New("product was changed since it was read")
+
+// ErrInvalidCursor is returned for a cursor this store did not hand out.
+var ErrInvalidCursor = pagination.ErrInvalidCursor
+
 type ProductStore interface {
-	ListProducts() ([]types.Product, error)
+	// ListProductsPage returns the products that match query in its order. A page can
+	// come back short, even empty, while the cursor still points at more products.
+	ListProductsPage(query types.ProductQuery, limit int, cursor string) ([]types.Product, string, error)
 	GetProduct(id string) (types.Product, error)
 	CreateProduct(product types.Product) error
-	UpdateProduct(product types.Product) error
+	// UpdateProduct writes product as the version after product.Version, provided the
+	// stored product is still at product.Version, and returns what it wrote.
+	UpdateProduct(product types.Product) (types.Product, error)
+	// PatchProduct changes only the fields the patch names and returns the product as it is now.
+	PatchProduct(id string, patch types.ProductPatch) (types.Product, error)
+	// DeleteProduct removes product provided the stored product is still at product.Version.
 	DeleteProduct(product types.Product) error
 }
 
 type DynamoDBClient struct {
-	databaseStore *dynamodb.DynamoDB
+	// both lambdas read the revocation list and the role table the same way
+	auth.DynamoRevocations
+	auth.DynamoRoleSource
+
+	databaseStore dynamodbiface.DynamoDBAPI
 }
 
 func NewDynamoDB() DynamoDBClient {
-	dbSession := session.Must(session.NewSession())
+	config := aws.NewConfig()
+	if endpoint := os.Getenv(common.DynamoDBEndpointEnv); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+
+	dbSession := session.Must(session.NewSession(config))
 	db := dynamodb.New(dbSession)
 
 	return DynamoDBClient{
-		databaseStore: db,
+		DynamoRevocations: auth.NewDynamoRevocations(db, common.RevokedTokenTableName),
+		DynamoRoleSource:  auth.NewDynamoRoleSource(db, common.RoleTableName),
+		databaseStore:     db,
 	}
 }
 
@@ -52,6 +83,21 @@ func (p DynamoDBClient) CreateProduct(product types.Product) error {
 			"manager": {
 				S: aws.String(product.Manager),
 			},
+			"version": {
+				N: aws.String(fmt.Sprintf("%d", product.Version)),
+			},
+			"createdAt": {
+				S: aws.String(product.CreatedAt),
+			},
+			"catalog": {
+				S: aws.String(common.ProductCatalog),
+			},
+			"nameLower": {
+				S: aws.String(searchable(product.Name)),
+			},
+			"descriptionLower": {
+				S: aws.String(searchable(product.Description)),
+			},
 		},
 	}
 
@@ -63,16 +109,27 @@ func (p DynamoDBClient) CreateProduct(product types.Product) error {
 	return nil
 }
 
-func (p DynamoDBClient) UpdateProduct(product types.Product) error {
+func (p DynamoDBClient) UpdateProduct(product types.Product) (types.Product, error) {
+
+	updated := product
+	updated.Version++
+	if updated.CreatedAt == "" {
+		updated.CreatedAt = time.Now().UTC().Format(common.CreatedAtLayout)
+	}
 
-	update := expression.Set(expression.Name("name"), expression.Value(product.Name))
-	update = update.Set(expression.Name("description"), expression.Value(product.Description))
-	update = update.Set(expression.Name("price"), expression.Value(product.Price))
-	update = update.Set(expression.Name("manager"), expression.Value(product.Manager))
-	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	update := expression.Set(expression.Name("name"), expression.Value(updated.Name))
+	update = update.Set(expression.Name("description"), expression.Value(updated.Description))
+	update = update.Set(expression.Name("price"), expression.Value(updated.Price))
+	update = update.Set(expression.Name("manager"), expression.Value(updated.Manager))
+	update = update.Set(expression.Name("version"), expression.Value(updated.Version))
+	update = update.Set(expression.Name("createdAt"), expression.Value(updated.CreatedAt))
+	update = update.Set(expression.Name("catalog"), expression.Value(common.ProductCatalog))
+	update = update.Set(expression.Name("nameLower"), expression.Value(searchable(updated.Name)))
+	update = update.Set(expression.Name("descriptionLower"), expression.Value(searchable(updated.Description)))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(versionCondition(product.Version)).Build()
 
 	if err != nil {
-		return err
+		return types.Product{}, err
 	}
 
 	item := &dynamodb.UpdateItemInput{
@@ -85,17 +142,97 @@ func (p DynamoDBClient) UpdateProduct(product types.Product) error {
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		// without a condition UpdateItem would create the product again after a delete
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
 	}
 
 	_, err = p.databaseStore.UpdateItem(item)
 	if err != nil {
-		return err
+		return types.Product{}, conditionFailure(err)
 	}
 
-	return nil
+	return updated, nil
+}
+
+// PatchProduct builds its update expression from the attributes in the patch alone,
+// so fields the patch leaves out are never written back with a stale value.
+func (p DynamoDBClient) PatchProduct(id string, patch types.ProductPatch) (types.Product, error) {
+	if patch.IsEmpty() {
+		product, err := p.GetProduct(id)
+		if err == nil && patch.IfVersion != nil && product.Version != *patch.IfVersion {
+			return types.Product{}, ErrVersionConflict
+		}
+		return product, err
+	}
+
+	// products stored before versions, creation times and the indexes existed get them now
+	version := expression.Plus(expression.IfNotExists(expression.Name("version"), expression.Value(0)), expression.Value(1))
+	createdAt := expression.IfNotExists(expression.Name("createdAt"), expression.Value(time.Now().UTC().Format(common.CreatedAtLayout)))
+	update := expression.Set(expression.Name("version"), version)
+	update = update.Set(expression.Name("createdAt"), createdAt)
+	update = update.Set(expression.Name("catalog"), expression.Value(common.ProductCatalog))
+	if patch.Name.Set {
+		update = update.Set(expression.Name("name"), expression.Value(patch.Name.Value))
+		update = update.Set(expression.Name("nameLower"), expression.Value(searchable(patch.Name.Value)))
+	}
+	if patch.Description.Set {
+		update = update.Set(expression.Name("description"), expression.Value(patch.Description.Value))
+		update = update.Set(expression.Name("descriptionLower"), expression.Value(searchable(patch.Description.Value)))
+	}
+	if patch.Price.Set {
+		update = update.Set(expression.Name("price"), expression.Value(patch.Price.Value))
+	}
+	condition := expression.AttributeExists(expression.Name("id"))
+	if patch.IfVersion != nil {
+		condition = versionCondition(*patch.IfVersion)
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return types.Product{}, err
+	}
+
+	result, err := p.databaseStore.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(common.ProductTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	})
+	if err != nil {
+		return types.Product{}, conditionFailure(err)
+	}
+
+	var product types.Product
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &product)
+	if err != nil {
+		return types.Product{}, err
+	}
+
+	// a patch without name or description cannot lower case them, so an older product gets them here
+	if missesDerivedAttributes(result.Attributes) {
+		if _, err := p.backfillProduct(product); err != nil {
+			log.Printf("backfilling product %s failed: %v", product.Id, err)
+		}
+	}
+
+	return product, nil
 }
 
 func (p DynamoDBClient) DeleteProduct(product types.Product) error {
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(product.Version)).Build()
+	if err != nil {
+		return err
+	}
 
 	item := &dynamodb.DeleteItemInput{
 		TableName: aws.String(common.ProductTableName),
@@ -104,11 +241,16 @@ func (p DynamoDBClient) DeleteProduct(product types.Product) error {
 				S: aws.String(product.Id),
 			},
 		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		// the old item tells a product removed meanwhile from one changed meanwhile
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
 	}
 
-	_, err := p.databaseStore.DeleteItem(item)
+	_, err = p.databaseStore.DeleteItem(item)
 	if err != nil {
-		return err
+		return conditionFailure(err)
 	}
 
 	return nil
@@ -131,7 +273,7 @@ func (p DynamoDBClient) GetProduct(id string) (types.Product, error) {
 	}
 
 	if result.Item == nil {
-		return product, fmt.Errorf("product not found")
+		return product, ErrProductNotFound
 	}
 
 	err = dynamodbattribute.UnmarshalMap(result.Item, &product)
@@ -142,27 +284,119 @@ func (p DynamoDBClient) GetProduct(id string) (types.Product, error) {
 	return product, nil
 }
 
-func (p DynamoDBClient) ListProducts() ([]types.Product, error) {
+func (p DynamoDBClient) ListProductsPage(query types.ProductQuery, limit int, cursor string) ([]types.Product, string, error) {
+	exclusiveStartKey, err := pagination.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	index := chooseIndex(query)
+	if exclusiveStartKey != nil && !fitsIndex(exclusiveStartKey, index) {
+		return nil, "", ErrInvalidCursor
+	}
+
+	builder := expression.NewBuilder()
+	filter, filtered := productFilter(query, index)
+	if filtered {
+		builder = builder.WithFilter(filter)
+	}
+	if index != nil {
+		builder = builder.WithKeyCondition(index.keyCondition(query))
+	}
+
+	var expr expression.Expression
+	if filtered || index != nil {
+		expr, err = builder.Build()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// the limit counts the products read before the filter, so a page is filled over a few reads
 	var products []types.Product
+	for reads := 0; reads < common.MaxListReads; reads++ {
+		items, lastEvaluatedKey, err := p.readProducts(index, query, expr, int64(limit-len(products)), exclusiveStartKey)
+		if err != nil {
+			return nil, "", err
+		}
 
-	result, err := p.databaseStore.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(common.ProductTableName),
-	})
+		for _, i := range items {
+			item := types.Product{}
+			err = dynamodbattribute.UnmarshalMap(i, &item)
+
+			if err != nil {
+				return nil, "", err
+			}
+
+			products = append(products, item)
+		}
+
+		exclusiveStartKey = lastEvaluatedKey
+		if len(exclusiveStartKey) == 0 || len(products) >= limit {
+			break
+		}
+	}
 
+	nextCursor, err := pagination.EncodeCursor(exclusiveStartKey)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	for _, i := range result.Items {
-		item := types.Product{}
-		err = dynamodbattribute.UnmarshalMap(i, &item)
+	return products, nextCursor, nil
+}
 
+// readProducts is one Query of index, or one Scan of the table when index is nil.
+func (p DynamoDBClient) readProducts(index *productIndex, query types.ProductQuery, expr expression.Expression, limit int64, startKey map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, error) {
+	if index == nil {
+		result, err := p.databaseStore.Scan(&dynamodb.ScanInput{
+			TableName:                 aws.String(common.ProductTableName),
+			Limit:                     aws.Int64(limit),
+			ExclusiveStartKey:         startKey,
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			FilterExpression:          expr.Filter(),
+		})
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		return result.Items, result.LastEvaluatedKey, nil
+	}
 
-		products = append(products, item)
+	result, err := p.databaseStore.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String(common.ProductTableName),
+		IndexName:                 aws.String(index.name),
+		Limit:                     aws.Int64(limit),
+		ExclusiveStartKey:         startKey,
+		ScanIndexForward:          aws.Bool(!query.Descending),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return result.Items, result.LastEvaluatedKey, nil
+}
 
-	return products, nil
+// versionCondition holds a write to a product that is stored at version.
+func versionCondition(version int) expression.ConditionBuilder {
+	exists := expression.AttributeExists(expression.Name("id"))
+	if version == 0 {
+		return exists.And(expression.AttributeNotExists(expression.Name("version")))
+	}
+	return exists.And(expression.Name("version").Equal(expression.Value(version)))
+}
+
+// conditionFailure turns a failed write condition into ErrProductNotFound or, when
+// DynamoDB sends back the product it found, ErrVersionConflict.
+func conditionFailure(err error) error {
+	var failed *dynamodb.ConditionalCheckFailedException
+	if !errors.As(err, &failed) {
+		return err
+	}
+	if len(failed.Item) == 0 {
+		return ErrProductNotFound
+	}
+	return ErrVersionConflict
 }
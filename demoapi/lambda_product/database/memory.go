@@ -0,0 +1,151 @@
+package database
+
+import (
+	"lambda-func/types"
+	"shared/pagination"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// MemoryStore is a ProductStore kept in process memory, used for local runs and tests.
+// Its revocation list is always empty because revocations are written by the user service.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	products map[string]types.Product
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		products: map[string]types.Product{},
+	}
+}
+
+func (m *MemoryStore) CreateProduct(product types.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.products[product.Id] = product
+	return nil
+}
+
+func (m *MemoryStore) UpdateProduct(product types.Product) (types.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.products[product.Id]
+	if !ok {
+		return types.Product{}, ErrProductNotFound
+	}
+	if stored.Version != product.Version {
+		return types.Product{}, ErrVersionConflict
+	}
+
+	product.Version++
+	m.products[product.Id] = product
+	return product, nil
+}
+
+func (m *MemoryStore) PatchProduct(id string, patch types.ProductPatch) (types.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	product, ok := m.products[id]
+	if !ok {
+		return types.Product{}, ErrProductNotFound
+	}
+	if patch.IfVersion != nil && product.Version != *patch.IfVersion {
+		return types.Product{}, ErrVersionConflict
+	}
+
+	product = patch.ApplyTo(product)
+	m.products[id] = product
+	return product, nil
+}
+
+func (m *MemoryStore) DeleteProduct(product types.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.products[product.Id]
+	if !ok {
+		return ErrProductNotFound
+	}
+	if stored.Version != product.Version {
+		return ErrVersionConflict
+	}
+
+	delete(m.products, product.Id)
+	return nil
+}
+
+func (m *MemoryStore) GetProduct(id string) (types.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	product, ok := m.products[id]
+	if !ok {
+		return types.Product{}, ErrProductNotFound
+	}
+
+	return product, nil
+}
+
+// ListProductsPage orders the matching products with query.Less, so its cursor holds
+// every field that order can look at.
+func (m *MemoryStore) ListProductsPage(query types.ProductQuery, limit int, cursor string) ([]types.Product, string, error) {
+	startKey, err := pagination.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.RLock()
+	var products []types.Product
+	for _, product := range m.products {
+		if query.Matches(product) {
+			products = append(products, product)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(products, func(i, j int) bool {
+		return query.Less(products[i], products[j])
+	})
+
+	start := 0
+	if startKey != nil {
+		var after types.Product
+		if err := dynamodbattribute.UnmarshalMap(startKey, &after); err != nil || after.Id == "" {
+			return nil, "", ErrInvalidCursor
+		}
+		start = sort.Search(len(products), func(i int) bool {
+			return query.Less(after, products[i])
+		})
+	}
+
+	end := start + limit
+	if end >= len(products) {
+		return products[start:], "", nil
+	}
+
+	last := products[end-1]
+	nextCursor, err := pagination.EncodeCursor(map[string]*dynamodb.AttributeValue{
+		"id":        {S: aws.String(last.Id)},
+		"name":      {S: aws.String(last.Name)},
+		"price":     {N: aws.String(strconv.Itoa(last.Price))},
+		"createdAt": {S: aws.String(last.CreatedAt)},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return products[start:end], nextCursor, nil
+}
+
+func (m *MemoryStore) IsRevoked(sessionId string, username string, issuedAt int64) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"lambda-func/common"
+	"lambda-func/types"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStoreListProductsPage(t *testing.T) {
+	store := NewMemoryStore()
+	for _, id := range []string{"dave", "alice", "carol", "bob", "erin"} {
+		store.CreateProduct(types.Product{Id: id})
+	}
+
+	var got []string
+	cursor := ""
+	pages := 0
+	for {
+		products, nextCursor, err := store.ListProductsPage(types.ProductQuery{}, 2, cursor)
+		if err != nil {
+			t.Fatalf("ListProductsPage: %v", err)
+		}
+		for _, product := range products {
+			got = append(got, product.Id)
+		}
+		pages++
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	want := []string{"alice", "bob", "carol", "dave", "erin"}
+	if len(got) != len(want) || pages != 3 {
+		t.Fatalf("got %v in %d pages, want %v in 3 pages", got, pages, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	if _, _, err := store.ListProductsPage(types.ProductQuery{}, 2, "not-a-cursor!"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestMemoryStoreListProductsQuery(t *testing.T) {
+	store := NewMemoryStore()
+	store.CreateProduct(types.Product{Id: "a", Name: "Blue Widget", Price: 30, Manager: "alice", CreatedAt: "2026-01-03T00:00:00.000Z"})
+	store.CreateProduct(types.Product{Id: "b", Name: "gadget", Description: "goes with a widget", Price: 10, Manager: "bob", CreatedAt: "2026-01-01T00:00:00.000Z"})
+	store.CreateProduct(types.Product{Id: "c", Name: "Gizmo", Price: 20, Manager: "alice", CreatedAt: "2026-01-02T00:00:00.000Z"})
+	store.CreateProduct(types.Product{Id: "d", Name: "doohickey", Price: 20, Manager: "bob", CreatedAt: "2026-01-04T00:00:00.000Z"})
+
+	minPrice, maxPrice := 15, 25
+
+	tests := []struct {
+		name    string
+		query   types.ProductQuery
+		wantIds []string
+	}{
+		{name: "everything", wantIds: []string{"a", "b", "c", "d"}},
+		{name: "search any case", query: types.ProductQuery{Search: "WIDGET"}, wantIds: []string{"a", "b"}},
+		{name: "price range", query: types.ProductQuery{MinPrice: &minPrice, MaxPrice: &maxPrice}, wantIds: []string{"c", "d"}},
+		{name: "manager", query: types.ProductQuery{Manager: "alice"}, wantIds: []string{"a", "c"}},
+		{name: "by name", query: types.ProductQuery{Sort: common.SortByName}, wantIds: []string{"a", "d", "b", "c"}},
+		{name: "by price then id", query: types.ProductQuery{Sort: common.SortByPrice}, wantIds: []string{"b", "c", "d", "a"}},
+		{name: "by price descending", query: types.ProductQuery{Sort: common.SortByPrice, Descending: true}, wantIds: []string{"a", "d", "c", "b"}},
+		{name: "newest of a manager", query: types.ProductQuery{Manager: "bob", Sort: common.SortByCreatedAt, Descending: true}, wantIds: []string{"d", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// pages of one walk the cursor through every position of the order
+			var got []string
+			cursor := ""
+			for {
+				products, nextCursor, err := store.ListProductsPage(tt.query, 1, cursor)
+				if err != nil {
+					t.Fatalf("ListProductsPage: %v", err)
+				}
+				for _, product := range products {
+					got = append(got, product.Id)
+				}
+				if nextCursor == "" {
+					break
+				}
+				cursor = nextCursor
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.wantIds, ",") {
+				t.Errorf("got %v, want %v", got, tt.wantIds)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreGetProductNotFound(t *testing.T) {
+	store := NewMemoryStore()
+
+	if _, err := store.GetProduct("nothing"); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("err = %v, want ErrProductNotFound", err)
+	}
+}
+
+func TestMemoryStorePatchProduct(t *testing.T) {
+	store := NewMemoryStore()
+	store.CreateProduct(types.Product{Id: "p1", Name: "widget", Description: "a widget", Price: 10, Manager: "root", Version: 1})
+
+	var patch types.ProductPatch
+	if err := json.Unmarshal([]byte(`{"description":null,"price":0}`), &patch); err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := store.PatchProduct("p1", patch)
+	if err != nil {
+		t.Fatalf("PatchProduct: %v", err)
+	}
+	want := types.Product{Id: "p1", Name: "widget", Price: 0, Manager: "root", Version: 2}
+	if stored, _ := store.GetProduct("p1"); patched != want || stored != want {
+		t.Errorf("patched = %+v, stored = %+v, want %+v", patched, stored, want)
+	}
+
+	if _, err := store.PatchProduct("nothing", patch); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("err = %v, want ErrProductNotFound", err)
+	}
+
+	stale := 1
+	patch.IfVersion = &stale
+	if _, err := store.PatchProduct("p1", patch); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("err = %v, want ErrVersionConflict", err)
+	}
+}
+
+func TestMemoryStoreUpdateProductVersion(t *testing.T) {
+	store := NewMemoryStore()
+	store.CreateProduct(types.Product{Id: "p1", Name: "widget", Version: 1})
+
+	first, _ := store.GetProduct("p1")
+	second, _ := store.GetProduct("p1")
+
+	first.Name = "first"
+	updated, err := store.UpdateProduct(first)
+	if err != nil || updated.Version != 2 {
+		t.Fatalf("UpdateProduct = %+v, %v, want version 2", updated, err)
+	}
+
+	// second was read at the same version and would overwrite the first write
+	second.Name = "second"
+	if _, err := store.UpdateProduct(second); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("err = %v, want ErrVersionConflict", err)
+	}
+	if stored, _ := store.GetProduct("p1"); stored.Name != "first" {
+		t.Errorf("stored name = %q, want %q", stored.Name, "first")
+	}
+
+	if _, err := store.UpdateProduct(types.Product{Id: "gone", Name: "ghost"}); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("err = %v, want ErrProductNotFound", err)
+	}
+	if _, err := store.GetProduct("gone"); !errors.Is(err, ErrProductNotFound) {
+		t.Error("UpdateProduct created a product")
+	}
+}
+
+func TestMemoryStoreDeleteProductVersion(t *testing.T) {
+	store := NewMemoryStore()
+	store.CreateProduct(types.Product{Id: "p1", Name: "widget", Version: 1})
+
+	stale, _ := store.GetProduct("p1")
+	current, _ := store.UpdateProduct(stale)
+
+	// a delete of the product as it was before the update would lose that update
+	if err := store.DeleteProduct(stale); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("err = %v, want ErrVersionConflict", err)
+	}
+	if _, err := store.GetProduct("p1"); err != nil {
+		t.Fatalf("product was deleted on a conflict: %v", err)
+	}
+
+	if err := store.DeleteProduct(current); err != nil {
+		t.Fatalf("DeleteProduct = %v", err)
+	}
+	if err := store.DeleteProduct(current); !errors.Is(err, ErrProductNotFound) {
+		t.Errorf("err = %v, want ErrProductNotFound", err)
+	}
+}
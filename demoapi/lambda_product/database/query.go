@@ -0,0 +1,125 @@
+package database
+
+import (
+	"lambda-func/common"
+	"lambda-func/types"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// productIndex is a global secondary index that reads products in the order of its sort key.
+// Every write sets the catalog attribute, so the catalog indexes hold the whole catalog.
+type productIndex struct {
+	name         string
+	partitionKey string
+	sortKey      string
+}
+
+var (
+	priceIndex     = &productIndex{name: common.ProductPriceIndex, partitionKey: "catalog", sortKey: "price"}
+	nameIndex      = &productIndex{name: common.ProductNameIndex, partitionKey: "catalog", sortKey: "nameLower"}
+	createdAtIndex = &productIndex{name: common.ProductCreatedAtIndex, partitionKey: "catalog", sortKey: "createdAt"}
+	managerIndex   = &productIndex{name: common.ProductManagerIndex, partitionKey: "manager", sortKey: "createdAt"}
+)
+
+// chooseIndex picks the index that gives the order the query asks for or, without one,
+// the index that reads the fewest products. A nil index scans the table.
+func chooseIndex(query types.ProductQuery) *productIndex {
+	switch {
+	case query.Sort == common.SortByPrice:
+		return priceIndex
+	case query.Sort == common.SortByName:
+		return nameIndex
+	case query.Sort == common.SortByCreatedAt && query.Manager != "":
+		return managerIndex
+	case query.Sort == common.SortByCreatedAt:
+		return createdAtIndex
+	case query.Manager != "":
+		return managerIndex
+	case query.MinPrice != nil || query.MaxPrice != nil:
+		return priceIndex
+	default:
+		return nil
+	}
+}
+
+// keyCondition reads the partition the query needs, and on the price index only the price range.
+func (i *productIndex) keyCondition(query types.ProductQuery) expression.KeyConditionBuilder {
+	if i.partitionKey == "manager" {
+		return expression.Key("manager").Equal(expression.Value(query.Manager))
+	}
+
+	condition := expression.Key("catalog").Equal(expression.Value(common.ProductCatalog))
+	if i != priceIndex {
+		return condition
+	}
+
+	price := expression.Key("price")
+	switch {
+	case query.MinPrice != nil && query.MaxPrice != nil:
+		return condition.And(price.Between(expression.Value(*query.MinPrice), expression.Value(*query.MaxPrice)))
+	case query.MinPrice != nil:
+		return condition.And(price.GreaterThanEqual(expression.Value(*query.MinPrice)))
+	case query.MaxPrice != nil:
+		return condition.And(price.LessThanEqual(expression.Value(*query.MaxPrice)))
+	default:
+		return condition
+	}
+}
+
+// productFilter is the part of the query the key condition of index does not cover.
+func productFilter(query types.ProductQuery, index *productIndex) (expression.ConditionBuilder, bool) {
+	var conditions []expression.ConditionBuilder
+
+	// DynamoDB compares case-sensitively, so the writes keep lower case copies to search
+	if query.Search != "" {
+		search := strings.ToLower(query.Search)
+		conditions = append(conditions, expression.Contains(expression.Name("nameLower"), search).
+			Or(expression.Contains(expression.Name("descriptionLower"), search)))
+	}
+	if index != priceIndex && query.MinPrice != nil {
+		conditions = append(conditions, expression.Name("price").GreaterThanEqual(expression.Value(*query.MinPrice)))
+	}
+	if index != priceIndex && query.MaxPrice != nil {
+		conditions = append(conditions, expression.Name("price").LessThanEqual(expression.Value(*query.MaxPrice)))
+	}
+	if index != managerIndex && query.Manager != "" {
+		conditions = append(conditions, expression.Name("manager").Equal(expression.Value(query.Manager)))
+	}
+
+	if len(conditions) == 0 {
+		return expression.ConditionBuilder{}, false
+	}
+
+	filter := conditions[0]
+	for _, condition := range conditions[1:] {
+		filter = filter.And(condition)
+	}
+	return filter, true
+}
+
+// fitsIndex tells whether a cursor was handed out for a listing read through index. A cursor
+// of another sort would be refused by DynamoDB, so it is caught as an invalid cursor first.
+func fitsIndex(startKey map[string]*dynamodb.AttributeValue, index *productIndex) bool {
+	keys := []string{"id"}
+	if index != nil {
+		keys = append(keys, index.partitionKey, index.sortKey)
+	}
+
+	if len(startKey) != len(keys) {
+		return false
+	}
+	for _, key := range keys {
+		if startKey[key] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// searchable is the lower case copy of an attribute that productFilter searches.
+func searchable(value string) string {
+	return strings.ToLower(value)
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"lambda-func/common"
+	"lambda-func/types"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestChooseIndex(t *testing.T) {
+	price := 10
+
+	tests := []struct {
+		name       string
+		query      types.ProductQuery
+		wantIndex  *productIndex
+		wantFilter bool
+	}{
+		{name: "nothing asked", wantIndex: nil},
+		{name: "search only", query: types.ProductQuery{Search: "widget"}, wantIndex: nil, wantFilter: true},
+		{name: "price range", query: types.ProductQuery{MinPrice: &price}, wantIndex: priceIndex},
+		{name: "price range and search", query: types.ProductQuery{MaxPrice: &price, Search: "widget"}, wantIndex: priceIndex, wantFilter: true},
+		{name: "manager", query: types.ProductQuery{Manager: "alice"}, wantIndex: managerIndex},
+		{name: "manager and price range", query: types.ProductQuery{Manager: "alice", MinPrice: &price}, wantIndex: managerIndex, wantFilter: true},
+		{name: "by price", query: types.ProductQuery{Sort: common.SortByPrice}, wantIndex: priceIndex},
+		{name: "by price of a manager", query: types.ProductQuery{Sort: common.SortByPrice, Manager: "alice"}, wantIndex: priceIndex, wantFilter: true},
+		{name: "by name", query: types.ProductQuery{Sort: common.SortByName, MinPrice: &price}, wantIndex: nameIndex, wantFilter: true},
+		{name: "by creation", query: types.ProductQuery{Sort: common.SortByCreatedAt}, wantIndex: createdAtIndex},
+		{name: "by creation of a manager", query: types.ProductQuery{Sort: common.SortByCreatedAt, Manager: "alice"}, wantIndex: managerIndex},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := chooseIndex(tt.query)
+			if index != tt.wantIndex {
+				t.Fatalf("index = %+v, want %+v", index, tt.wantIndex)
+			}
+
+			_, filtered := productFilter(tt.query, index)
+			if filtered != tt.wantFilter {
+				t.Errorf("filtered = %v, want %v", filtered, tt.wantFilter)
+			}
+
+			if index != nil {
+				if _, err := expression.NewBuilder().WithKeyCondition(index.keyCondition(tt.query)).Build(); err != nil {
+					t.Errorf("key condition: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFitsIndex(t *testing.T) {
+	tableKey := map[string]*dynamodb.AttributeValue{"id": {S: aws.String("p1")}}
+	priceKey := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String("p1")},
+		"catalog": {S: aws.String(common.ProductCatalog)},
+		"price":   {N: aws.String("10")},
+	}
+
+	if !fitsIndex(tableKey, nil) || !fitsIndex(priceKey, priceIndex) {
+		t.Error("a cursor does not fit the listing it came from")
+	}
+	if fitsIndex(tableKey, priceIndex) || fitsIndex(priceKey, nil) || fitsIndex(priceKey, nameIndex) {
+		t.Error("a cursor fits a listing of another sort")
+	}
+}
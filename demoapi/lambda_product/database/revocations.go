@@ -0,0 +1,7 @@
+package database
+
+// RevocationStore reads the revocation list written by the user service.
+type RevocationStore interface {
+	// IsRevoked reports whether a token is revoked; issuedAt is in unix milliseconds.
+	IsRevoked(sessionId string, username string, issuedAt int64) (bool, error)
+}
@@ -6,10 +6,10 @@ require (
 	github.com/aws/aws-lambda-go v1.47.0
 	github.com/aws/aws-sdk-go v1.55.5
 	github.com/golang-jwt/jwt/v5 v5.2.1
-	golang.org/x/crypto v0.19.0
+	github.com/google/uuid v1.6.0
+	shared v0.0.0
 )
 
-require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/jmespath/go-jmespath v0.4.0 // indirect
-)
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+replace shared => ../shared
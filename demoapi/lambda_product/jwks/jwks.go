@@ -0,0 +1,168 @@
+package jwks
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lambda-func/common"
+	"math/big"
+	"net/http"
+	"os"
+	"shared/auth"
+	"sync"
+	"time"
+)
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// ParseKeySet reads the RSA signing keys of a JWKS document, other keys are skipped.
+func ParseKeySet(document []byte) (map[string]*rsa.PublicKey, error) {
+	var keySet JSONWebKeySet
+	if err := json.Unmarshal(document, &keySet); err != nil {
+		return nil, fmt.Errorf("parsing JWKS %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range keySet.Keys {
+		if key.Kty != "RSA" || key.Kid == "" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("parsing modulus of key %s %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("parsing exponent of key %s %w", key.Kid, err)
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %s has an invalid exponent", key.Kid)
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+type StaticKeySet struct {
+	keys map[string]*rsa.PublicKey
+}
+
+// NewStaticKeySet serves the keys of a fixed JWKS document, used for local runs and tests.
+func NewStaticKeySet(document []byte) (StaticKeySet, error) {
+	keys, err := ParseKeySet(document)
+	if err != nil {
+		return StaticKeySet{}, err
+	}
+	return StaticKeySet{keys: keys}, nil
+}
+
+// NewFileKeySet reads a JWKS document once from a local file.
+func NewFileKeySet(path string) (StaticKeySet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return StaticKeySet{}, fmt.Errorf("reading JWKS file %w", err)
+	}
+	return NewStaticKeySet(content)
+}
+
+func (s StaticKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, auth.ErrUnknownKey
+	}
+	return key, nil
+}
+
+// RemoteKeySet fetches the JWKS published by the user service and keeps it for
+// the lifetime of the lambda container. The document is fetched again once it
+// is older than JwksCacheTTL, or earlier when a token names a key it does not
+// hold yet, which is how a rotated key is picked up. Only public keys are ever
+// held here, so this service can verify tokens but not mint them.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	checkedAt time.Time
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		now:    time.Now,
+	}
+}
+
+func (r *RemoteKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, known := r.keys[kid]
+	age := r.now().Sub(r.checkedAt)
+
+	// unknown kids are rate limited so forged tokens cannot make us hammer the user service
+	if r.keys == nil || age > common.JwksCacheTTL || (!known && age > common.JwksRefreshInterval) {
+		err := r.refresh()
+		if err != nil && r.keys == nil {
+			return nil, err
+		}
+		// otherwise keep verifying with the keys we already have
+	}
+
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, auth.ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (r *RemoteKeySet) refresh() error {
+	r.checkedAt = r.now()
+
+	response, err := r.client.Get(r.url)
+	if err != nil {
+		return fmt.Errorf("%w: %v", auth.ErrKeySetUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", auth.ErrKeySetUnavailable, r.url, response.StatusCode)
+	}
+
+	document, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", auth.ErrKeySetUnavailable, err)
+	}
+
+	keys, err := ParseKeySet(document)
+	if err != nil {
+		return fmt.Errorf("%w: %v", auth.ErrKeySetUnavailable, err)
+	}
+
+	r.keys = keys
+	return nil
+}
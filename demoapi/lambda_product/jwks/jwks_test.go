@@ -0,0 +1,182 @@
+package jwks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"lambda-func/common"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"shared/auth"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newPublicKey(t *testing.T) *rsa.PublicKey {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &privateKey.PublicKey
+}
+
+func newJSONWebKey(kid string, publicKey *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// fakeUserService serves a JWKS document that the test can swap and take down.
+type fakeUserService struct {
+	mu       sync.Mutex
+	keys     []JSONWebKey
+	down     bool
+	requests int
+}
+
+func (f *fakeUserService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+	if f.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(JSONWebKeySet{Keys: f.keys})
+}
+
+func (f *fakeUserService) publish(keys ...JSONWebKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = keys
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	first := newPublicKey(t)
+	second := newPublicKey(t)
+
+	service := &fakeUserService{}
+	service.publish(newJSONWebKey("first", first))
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	now := time.Now()
+	keys := NewRemoteKeySet(server.URL)
+	keys.now = func() time.Time { return now }
+
+	key, err := keys.PublicKey("first")
+	if err != nil || key.N.Cmp(first.N) != 0 {
+		t.Fatalf("PublicKey(first) = %v, %v", key, err)
+	}
+
+	if _, err := keys.PublicKey("first"); err != nil || service.requests != 1 {
+		t.Fatalf("cached lookup made %d requests, err %v", service.requests, err)
+	}
+
+	// a rotated key shows up in the document, but is not fetched again right away
+	service.publish(newJSONWebKey("first", first), newJSONWebKey("second", second))
+	if _, err := keys.PublicKey("second"); !errors.Is(err, auth.ErrUnknownKey) || service.requests != 1 {
+		t.Fatalf("PublicKey(second) = %v after %d requests, want auth.ErrUnknownKey without a fetch", err, service.requests)
+	}
+
+	now = now.Add(common.JwksRefreshInterval + time.Second)
+	key, err = keys.PublicKey("second")
+	if err != nil || key.N.Cmp(second.N) != 0 || service.requests != 2 {
+		t.Fatalf("PublicKey(second) = %v, %v after %d requests", key, err, service.requests)
+	}
+
+	if _, err := keys.PublicKey("forged"); !errors.Is(err, auth.ErrUnknownKey) || service.requests != 2 {
+		t.Fatalf("PublicKey(forged) = %v after %d requests, want a rate limited auth.ErrUnknownKey", err, service.requests)
+	}
+
+	// the retired key drops out once the cache expires
+	service.publish(newJSONWebKey("second", second))
+	now = now.Add(common.JwksCacheTTL + time.Second)
+	if _, err := keys.PublicKey("first"); !errors.Is(err, auth.ErrUnknownKey) || service.requests != 3 {
+		t.Fatalf("PublicKey(first) = %v after %d requests, want auth.ErrUnknownKey after a refresh", err, service.requests)
+	}
+
+	// an unreachable user service keeps the last known keys in use
+	service.down = true
+	now = now.Add(common.JwksCacheTTL + time.Second)
+	if _, err := keys.PublicKey("second"); err != nil || service.requests != 4 {
+		t.Fatalf("PublicKey(second) = %v after %d requests, want the cached key", err, service.requests)
+	}
+}
+
+func TestRemoteKeySetUnavailable(t *testing.T) {
+	service := &fakeUserService{down: true}
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	keys := NewRemoteKeySet(server.URL)
+
+	if _, err := keys.PublicKey("first"); !errors.Is(err, auth.ErrKeySetUnavailable) {
+		t.Fatalf("err = %v, want auth.ErrKeySetUnavailable", err)
+	}
+
+	service.down = false
+	service.publish(newJSONWebKey("first", newPublicKey(t)))
+	if _, err := keys.PublicKey("first"); err != nil {
+		t.Fatalf("err = %v once the user service is back", err)
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	publicKey := newPublicKey(t)
+	valid := newJSONWebKey("valid", publicKey)
+
+	encryption := newJSONWebKey("encryption", publicKey)
+	encryption.Use = "enc"
+	elliptic := JSONWebKey{Kty: "EC", Kid: "elliptic"}
+	anonymous := newJSONWebKey("", publicKey)
+	badModulus := newJSONWebKey("bad", publicKey)
+	badModulus.N = "not base64!"
+
+	tests := []struct {
+		name     string
+		keys     []JSONWebKey
+		wantKids []string
+		wantErr  bool
+	}{
+		{name: "rsa signing key", keys: []JSONWebKey{valid}, wantKids: []string{"valid"}},
+		{name: "skips other keys", keys: []JSONWebKey{valid, encryption, elliptic, anonymous}, wantKids: []string{"valid"}},
+		{name: "empty", keys: nil},
+		{name: "bad modulus", keys: []JSONWebKey{badModulus}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, _ := json.Marshal(JSONWebKeySet{Keys: tt.keys})
+
+			keys, err := ParseKeySet(document)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.wantKids) {
+				t.Errorf("got %d keys, want %v", len(keys), tt.wantKids)
+			}
+			for _, kid := range tt.wantKids {
+				if keys[kid] == nil || keys[kid].N.Cmp(publicKey.N) != 0 || keys[kid].E != publicKey.E {
+					t.Errorf("key %s = %v", kid, keys[kid])
+				}
+			}
+		})
+	}
+
+	if _, err := ParseKeySet([]byte("not json")); err == nil {
+		t.Error("malformed document was accepted")
+	}
+}
@@ -1,34 +1,73 @@
 package main
 
 import (
+	"flag"
+	"lambda-func/api"
 	"lambda-func/app"
-	"lambda-func/middleware"
+	"lambda-func/common"
+	"lambda-func/database"
+	"log"
 	"net/http"
+	"os"
+	"shared/auth"
+	"shared/router"
+	"shared/server"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
 func main() {
-	lambdaApp := app.NewApp()
-	lambda.Start(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-		switch request.Path {
-		case "/list":
-			return lambdaApp.ApiHandler.ListProducts(request)
-		case "/one":
-			return lambdaApp.ApiHandler.GetProduct(request)
-		case "/create":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.CreateProduct)(request)
-		case "/update":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.UpdateProduct)(request)
-		case "/delete":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.DeleteProduct)(request)
-		default:
-			return events.APIGatewayProxyResponse{
-				Body:       "Not found",
-				StatusCode: http.StatusNotFound,
-			}, nil
+	localMode := flag.Bool("local", os.Getenv(common.LocalModeEnv) == "true", "serve the API over plain HTTP instead of running as a lambda")
+	localAddr := flag.String("addr", envOrDefault(common.LocalAddrEnv, ":8081"), "listen address in local mode")
+	backfill := flag.Bool("backfill", false, "write the listing attributes of products stored before the indexes, then exit")
+	flag.Parse()
+
+	if *backfill {
+		backfilled, err := database.NewDynamoDB().BackfillProducts()
+		if err != nil {
+			log.Fatalf("backfilling products failed after %d: %v", backfilled, err)
 		}
+		log.Printf("backfilled %d products", backfilled)
+		return
+	}
+
+	lambdaApp := app.NewApp(app.ConfigFromEnv())
+	handler := newHandler(lambdaApp)
+
+	if *localMode {
+		log.Fatal(server.ListenAndServe(*localAddr, handler))
+	}
+
+	lambda.Start(handler)
+}
+
+func newHandler(lambdaApp app.App) server.Handler {
+	h := lambdaApp.ApiHandler
+	authenticate := lambdaApp.Middleware.Authenticate
+	writeProducts := lambdaApp.Roles.RequirePermission(auth.PermissionProductsWrite)
+	deleteProducts := lambdaApp.Roles.RequirePermission(auth.PermissionProductsDelete)
+
+	r := router.New()
+	r.Handle(http.MethodGet, "/products", h.ListProducts)
+	r.Handle(http.MethodPost, "/products", auth.WithUser(h.CreateProduct), authenticate, writeProducts)
+	r.Handle(http.MethodGet, "/products/{id}", h.GetProduct)
+	r.Handle(http.MethodPut, "/products/{id}", auth.WithUser(h.UpdateProduct), authenticate, writeProducts)
+	r.Handle(http.MethodPatch, "/products/{id}", auth.WithUser(h.PatchProduct), authenticate, writeProducts)
+	r.Handle(http.MethodDelete, "/products/{id}", auth.WithUser(h.DeleteProduct), authenticate, deleteProducts)
+
+	// the verb-style routes stay until the sunset, the product id in the query or the body
+	r.Handle(http.MethodGet, "/list", h.ListProducts, api.Deprecated)
+	r.Handle(http.MethodGet, "/one", h.GetProduct, api.Deprecated)
+	r.Handle(http.MethodPost, "/create", auth.WithUser(h.LegacyCreateProduct), api.Deprecated, authenticate, writeProducts)
+	r.Handle(http.MethodPut, "/update", auth.WithUser(h.UpdateProduct), api.Deprecated, authenticate, writeProducts)
+	r.Handle(http.MethodDelete, "/delete", auth.WithUser(h.DeleteProduct), api.Deprecated, authenticate, deleteProducts)
+
+	return r.Serve
+}
 
-	})
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"lambda-func/app"
+	"lambda-func/common"
+	"lambda-func/jwks"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shared/auth"
+	"shared/server"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testKeyId = "test-key"
+
+type testApp struct {
+	handler    server.Handler
+	privateKey *rsa.PrivateKey
+}
+
+// newTestApp runs the real routes on the memory store, trusting a key the test can mint tokens with.
+func newTestApp(t *testing.T, rolesFile string) testApp {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, err := json.Marshal(jwks.JSONWebKeySet{Keys: []jwks.JSONWebKey{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: testKeyId,
+		N:   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, document, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return testApp{
+		handler: newHandler(app.NewApp(app.Config{
+			StoreBackend: common.BackendMemory,
+			JwksFile:     jwksFile,
+			RolesFile:    rolesFile,
+		})),
+		privateKey: privateKey,
+	}
+}
+
+// call sends a request as role, or anonymously when role is empty, with headers given as name, value pairs.
+func (a testApp) call(t *testing.T, method string, path string, role string, body string, headers ...string) events.APIGatewayProxyResponse {
+	t.Helper()
+
+	request := events.APIGatewayProxyRequest{HTTPMethod: method, Path: path, Body: body, Headers: map[string]string{}}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Headers[headers[i]] = headers[i+1]
+	}
+	if role != "" {
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.TokenClaims{
+			Role:      role,
+			SessionId: "session-1",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    auth.TokenIssuer,
+				Subject:   "caller",
+				Audience:  jwt.ClaimStrings{auth.ProductApiAudience},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		})
+		token.Header["kid"] = testKeyId
+
+		signed, err := token.SignedString(a.privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Headers["Authorization"] = "Bearer " + signed
+	}
+
+	response, err := a.handler(request)
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	return response
+}
+
+func TestRoutes(t *testing.T) {
+	testApp := newTestApp(t, "")
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		role           string
+		body           string
+		wantStatus     int
+		wantAllow      string
+		wantDeprecated bool
+	}{
+		{name: "public", method: http.MethodGet, path: "/products", wantStatus: http.StatusOK},
+		{name: "anonymous create", method: http.MethodPost, path: "/products", body: `{"name":"gadget"}`, wantStatus: http.StatusUnauthorized},
+		{name: "create as user", method: http.MethodPost, path: "/products", role: auth.RoleUser, body: `{"name":"gadget"}`, wantStatus: http.StatusForbidden},
+		{name: "create as support", method: http.MethodPost, path: "/products", role: auth.RoleSupport, body: `{"name":"gadget"}`, wantStatus: http.StatusForbidden},
+		{name: "create as product manager", method: http.MethodPost, path: "/products", role: auth.RoleProductManager, body: `{"name":"gadget"}`, wantStatus: http.StatusCreated},
+		{name: "create as admin", method: http.MethodPost, path: "/products", role: auth.RoleAdmin, body: `{"name":"gadget"}`, wantStatus: http.StatusCreated},
+		{name: "get unknown product", method: http.MethodGet, path: "/products/p1", wantStatus: http.StatusNotFound},
+		{name: "update as user", method: http.MethodPut, path: "/products/p1", role: auth.RoleUser, body: `{"name":"gadget"}`, wantStatus: http.StatusForbidden},
+		{name: "patch as user", method: http.MethodPatch, path: "/products/p1", role: auth.RoleUser, body: `{"price":1}`, wantStatus: http.StatusForbidden},
+		{name: "patch as product manager", method: http.MethodPatch, path: "/products/p1", role: auth.RoleProductManager, body: `{"price":1}`, wantStatus: http.StatusNotFound},
+		{name: "delete as user", method: http.MethodDelete, path: "/products/p1", role: auth.RoleUser, wantStatus: http.StatusForbidden},
+		{name: "delete as product manager", method: http.MethodDelete, path: "/products/p1", role: auth.RoleProductManager, wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodPut, path: "/products", role: auth.RoleAdmin, wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, POST"},
+		{name: "wrong method on a product", method: http.MethodPost, path: "/products/p1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "DELETE, GET, PATCH, PUT"},
+		{name: "unknown path", method: http.MethodGet, path: "/nope", wantStatus: http.StatusNotFound},
+		{name: "legacy list", method: http.MethodGet, path: "/list", wantStatus: http.StatusOK, wantDeprecated: true},
+		{name: "legacy create", method: http.MethodPost, path: "/create", role: auth.RoleProductManager, body: `{"name":"gadget"}`, wantStatus: http.StatusOK, wantDeprecated: true},
+		{name: "legacy create refused", method: http.MethodPost, path: "/create", role: auth.RoleUser, body: `{"name":"gadget"}`, wantStatus: http.StatusForbidden, wantDeprecated: true},
+		{name: "legacy delete as user", method: http.MethodDelete, path: "/delete", role: auth.RoleUser, wantStatus: http.StatusForbidden, wantDeprecated: true},
+		{name: "legacy wrong method", method: http.MethodPost, path: "/list", wantStatus: http.StatusMethodNotAllowed, wantAllow: http.MethodGet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := testApp.call(t, tt.method, tt.path, tt.role, tt.body)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if response.Headers["Allow"] != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", response.Headers["Allow"], tt.wantAllow)
+			}
+			deprecated := response.Headers["Deprecation"] != "" && response.Headers["Sunset"] != ""
+			if deprecated != tt.wantDeprecated {
+				t.Errorf("headers = %v, want deprecated %v", response.Headers, tt.wantDeprecated)
+			}
+		})
+	}
+}
+
+func TestProductLifecycle(t *testing.T) {
+	testApp := newTestApp(t, "")
+
+	created := testApp.call(t, http.MethodPost, "/products", auth.RoleProductManager, `{"name":"gadget","price":5}`)
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("create status = %d (body %q)", created.StatusCode, created.Body)
+	}
+	location := created.Headers["Location"]
+	etag := created.Headers["ETag"]
+
+	patch := testApp.call(t, http.MethodPatch, location, auth.RoleProductManager, `{"price":7}`, "If-Match", etag)
+	if patch.StatusCode != http.StatusOK || patch.Headers["ETag"] == etag {
+		t.Errorf("patch = %d with ETag %q (body %q)", patch.StatusCode, patch.Headers["ETag"], patch.Body)
+	}
+
+	// a write based on what was read before the patch must not undo it
+	stale := testApp.call(t, http.MethodPut, location, auth.RoleProductManager, `{"name":"gadget","description":"","price":5}`, "If-Match", etag)
+	if stale.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale ETag = %d, want %d", stale.StatusCode, http.StatusPreconditionFailed)
+	}
+
+	var product struct {
+		Name  string `json:"name"`
+		Price int    `json:"price"`
+	}
+	response := testApp.call(t, http.MethodGet, location, "", "")
+	if err := json.Unmarshal([]byte(response.Body), &product); err != nil || product.Name != "gadget" || product.Price != 7 {
+		t.Errorf("GET %s = %d %q", location, response.StatusCode, response.Body)
+	}
+	if response.Headers["ETag"] != patch.Headers["ETag"] {
+		t.Errorf("GET ETag = %q, want the one of the patch %q", response.Headers["ETag"], patch.Headers["ETag"])
+	}
+
+	if response := testApp.call(t, http.MethodDelete, location, auth.RoleProductManager, ""); response.StatusCode != http.StatusOK {
+		t.Errorf("delete status = %d (body %q)", response.StatusCode, response.Body)
+	}
+	if response := testApp.call(t, http.MethodGet, location, "", ""); response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after delete = %d, want %d", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestRolesFile(t *testing.T) {
+	rolesFile := filepath.Join(t.TempDir(), "roles.json")
+	err := os.WriteFile(rolesFile, []byte(`{"catalog_editor": ["products:write"], "product_manager": ["products:write"]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testApp := newTestApp(t, rolesFile)
+
+	if response := testApp.call(t, http.MethodPost, "/products", "catalog_editor", `{"name":"gadget"}`); response.StatusCode != http.StatusCreated {
+		t.Errorf("create as a defined role = %d, want %d", response.StatusCode, http.StatusCreated)
+	}
+	if response := testApp.call(t, http.MethodDelete, "/products/p1", auth.RoleProductManager, ""); response.StatusCode != http.StatusForbidden {
+		t.Errorf("delete as a redefined role = %d, want %d", response.StatusCode, http.StatusForbidden)
+	}
+}
+
+type testKey struct {
+	key *rsa.PublicKey
+}
+
+func (k testKey) PublicKey(kid string) (*rsa.PublicKey, error) {
+	return k.key, nil
+}
+
+// revokedBefore revokes the tokens of every user issued before a cut-off in unix milliseconds.
+type revokedBefore int64
+
+func (r revokedBefore) IsRevoked(sessionId string, username string, issuedAt int64) (bool, error) {
+	return issuedAt < int64(r), nil
+}
+
+func TestTokenIssuedInTheSecondOfARevocation(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the user service revokes the old tokens and issues a new one within the same second
+	second := time.Now().Truncate(time.Second).Add(-time.Second)
+	revokedAt := second.Add(200 * time.Millisecond)
+	issuedAt := second.Add(700 * time.Millisecond)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.TokenClaims{
+		Role:      auth.RoleUser,
+		SessionId: "session-2",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    auth.TokenIssuer,
+			Subject:   "caller",
+			Audience:  jwt.ClaimStrings{auth.ProductApiAudience},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+		},
+	})
+	signed, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	middleware := auth.NewMiddleware(testKey{&privateKey.PublicKey}, revokedBefore(revokedAt.UnixMilli()), auth.ProductApiAudience)
+	handler := middleware.Authenticate(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	})
+
+	response, err := handler(events.APIGatewayProxyRequest{Headers: map[string]string{"Authorization": "Bearer " + signed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("token issued after the revocation = %d (body %q), want %d", response.StatusCode, response.Body, http.StatusOK)
+	}
+}
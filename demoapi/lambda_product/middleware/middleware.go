@@ -1,89 +0,0 @@
-package middleware
-
-import (
-	"fmt"
-	"net/http"
-	"strings"
-	"time"
-
-	"lambda-func/common"
-	"lambda-func/types"
-
-	"github.com/aws/aws-lambda-go/events"
-	"github.com/golang-jwt/jwt/v5"
-)
-
-func ValidateJWTMiddleware(next func(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error)) func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-
-		tokenString := extractTokenFromHeaders(request.Headers)
-		if tokenString == "" {
-			return events.APIGatewayProxyResponse{
-				Body:       "Missing Auth token",
-				StatusCode: http.StatusUnauthorized,
-			}, nil
-		}
-
-		claims, err := parseToken(tokenString)
-		if err != nil {
-			return events.APIGatewayProxyResponse{
-				Body:       "User Unauthorized",
-				StatusCode: http.StatusUnauthorized,
-			}, err
-		}
-
-		expires := int64(claims["expires"].(float64))
-		if time.Now().Unix() > expires {
-			return events.APIGatewayProxyResponse{
-				Body:       "token expired",
-				StatusCode: http.StatusUnauthorized,
-			}, nil
-		}
-
-		userContext := types.UserContext{
-			Username: claims["user"].(string),
-			Role:     claims["role"].(string),
-		}
-
-		return next(request, userContext)
-	}
-
-}
-
-func extractTokenFromHeaders(headers map[string]string) string {
-	authHeader, ok := headers["Authorization"]
-
-	if !ok {
-		return ""
-	}
-
-	splitToken := strings.Split(authHeader, "Bearer ")
-	if len(splitToken) != 2 {
-		return ""
-	}
-
-	return splitToken[1]
-}
-
-func parseToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		secret := common.TokenSecret
-		return []byte(secret), nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("unauthorized")
-	}
-
-	if !token.Valid {
-		return nil, fmt.Errorf("token is not valid - unauthorized")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("token of unrecognized type - unauthorized")
-	}
-
-	return claims, nil
-
-}
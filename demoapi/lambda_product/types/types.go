@@ -1,7 +1,10 @@
 package types
 
 import (
+	"encoding/json"
 	"lambda-func/common"
+	"strings"
+	"time"
 )
 
 type Product struct {
@@ -10,24 +13,77 @@ type Product struct {
 	Description string `json:"description"`
 	Price       int    `json:"price"`
 	Manager     string `json:"manager"`
+	// Version goes up by one on every write, products stored before it existed read as 0.
+	Version int `json:"version"`
+	// CreatedAt is formatted with common.CreatedAtLayout, which sorts as a string.
+	CreatedAt string `json:"createdAt,omitempty"`
 }
 
 type CreateProductRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int    `json:"price"`
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1000"`
+	Price       int    `json:"price" validate:"min=0"`
 }
 
+// UpdateProductRequest replaces a product, so every field has to be sent; the pointers
+// tell a field that was left out from an empty description or a price of 0.
 type UpdateProductRequest struct {
-	Id          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int    `json:"price"`
+	Id          string  `json:"id" validate:"required"`
+	Name        string  `json:"name" validate:"required,max=100"`
+	Description *string `json:"description" validate:"required,max=1000"`
+	Price       *int    `json:"price" validate:"required,min=0"`
+}
+
+// ProductPatch is a JSON Merge Patch (RFC 7396) of a product: the fields it names
+// change, the others keep their values. A description of null removes it.
+type ProductPatch struct {
+	Id          Patched[string] `json:"id"`
+	Name        Patched[string] `json:"name"`
+	Description Patched[string] `json:"description"`
+	Price       Patched[int]    `json:"price"`
+	// IfVersion, when set, applies the patch only to that version of the product.
+	// It comes from If-Match and never from the body.
+	IfVersion *int `json:"-"`
+}
+
+// Patched is a field of a merge patch. Set tells a field that was sent from one that
+// was left out, Null a field sent as null.
+type Patched[T any] struct {
+	Value T
+	Set   bool
+	Null  bool
+}
+
+func (p *Patched[T]) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	if string(data) == "null" {
+		p.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &p.Value)
+}
+
+func (p ProductPatch) IsEmpty() bool {
+	return !p.Name.Set && !p.Description.Set && !p.Price.Set
 }
 
-type UserContext struct {
-	Username string
-	Role     string
+// ApplyTo returns product with the patch merged in as its next version.
+// An empty patch leaves product as it is.
+func (p ProductPatch) ApplyTo(product Product) Product {
+	if p.IsEmpty() {
+		return product
+	}
+	if p.Name.Set {
+		product.Name = p.Name.Value
+	}
+	if p.Description.Set {
+		product.Description = p.Description.Value
+	}
+	if p.Price.Set {
+		product.Price = p.Price.Value
+	}
+	product.Version++
+	return product
 }
 
 type ProductResponse struct {
@@ -35,6 +91,64 @@ type ProductResponse struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Price       int    `json:"price"`
+	CreatedAt   string `json:"createdAt,omitempty"`
+}
+
+// ProductQuery narrows and orders a product listing; a zero field leaves its filter out.
+// Search is matched case-insensitively against the name and the description.
+type ProductQuery struct {
+	Search     string
+	MinPrice   *int
+	MaxPrice   *int
+	Manager    string
+	Sort       string
+	Descending bool
+}
+
+// Matches tells whether product passes every filter of the query.
+func (q ProductQuery) Matches(product Product) bool {
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(product.Name), search) && !strings.Contains(strings.ToLower(product.Description), search) {
+			return false
+		}
+	}
+	if q.MinPrice != nil && product.Price < *q.MinPrice {
+		return false
+	}
+	if q.MaxPrice != nil && product.Price > *q.MaxPrice {
+		return false
+	}
+	return q.Manager == "" || product.Manager == q.Manager
+}
+
+// Less orders products by the sort field of the query and then by id, which keeps
+// products with the same name, price or creation time in a stable order.
+func (q ProductQuery) Less(a Product, b Product) bool {
+	if q.Descending {
+		a, b = b, a
+	}
+
+	switch q.Sort {
+	case common.SortByName:
+		if name, other := strings.ToLower(a.Name), strings.ToLower(b.Name); name != other {
+			return name < other
+		}
+	case common.SortByPrice:
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+	case common.SortByCreatedAt:
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+	}
+	return a.Id < b.Id
+}
+
+type ProductListResponse struct {
+	Items      []ProductResponse `json:"items"`
+	NextCursor string            `json:"nextCursor,omitempty"`
 }
 
 func NewProduct(productRequest CreateProductRequest, manager string) (Product, error) {
@@ -44,5 +158,7 @@ func NewProduct(productRequest CreateProductRequest, manager string) (Product, e
 		Description: productRequest.Description,
 		Price:       productRequest.Price,
 		Manager:     manager,
+		Version:     1,
+		CreatedAt:   time.Now().UTC().Format(common.CreatedAtLayout),
 	}, nil
 }
@@ -1,303 +1,513 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"lambda-func/common"
 	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/mfa"
+	"lambda-func/notify"
+	"lambda-func/password"
 	"lambda-func/queue"
+	"lambda-func/signing"
 	"lambda-func/types"
+	"log"
 	"net/http"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/validate"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
 type ApiHandler struct {
-	dbStore  database.UserStore
-	msgQueue queue.MessageQueue
+	dbStore     database.UserStore
+	tokenStore  database.RefreshTokenStore
+	resetStore  database.PasswordResetStore
+	attempts    database.LoginAttemptStore
+	revocations database.RevocationStore
+	msgQueue    queue.MessageQueue
+	keys        signing.KeySet
+	passwords   *password.Policy
+	lockouts    *lockout.Policy
+	mfa         *mfa.Policy
+	roles       *auth.Roles
+	notifier    notify.Notifier
+	// requireVerification keeps accounts unverified until their email address is confirmed
+	requireVerification bool
 }
 
-func NewApiHandler(dbStore database.UserStore, msgQueue queue.MessageQueue) ApiHandler {
+func NewApiHandler(dbStore database.UserStore, tokenStore database.RefreshTokenStore, resetStore database.PasswordResetStore, attempts database.LoginAttemptStore, revocations database.RevocationStore, msgQueue queue.MessageQueue, keys signing.KeySet, passwords *password.Policy, lockouts *lockout.Policy, mfaPolicy *mfa.Policy, roles *auth.Roles, notifier notify.Notifier, requireVerification bool) ApiHandler {
 	return ApiHandler{
-		dbStore:  dbStore,
-		msgQueue: msgQueue,
+		dbStore:     dbStore,
+		tokenStore:  tokenStore,
+		resetStore:  resetStore,
+		attempts:    attempts,
+		revocations: revocations,
+		msgQueue:    msgQueue,
+		keys:        keys,
+		passwords:   passwords,
+		lockouts:    lockouts,
+		mfa:         mfaPolicy,
+		roles:       roles,
+		notifier:    notifier,
+
+		requireVerification: requireVerification,
 	}
 }
 
 func (api ApiHandler) RegisterUser(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var registerUser types.RegisterUser
 
-	err := json.Unmarshal([]byte(request.Body), &registerUser)
+	err := validate.Decode(request.Body, &registerUser)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Invalid Request",
-			StatusCode: http.StatusBadRequest,
-		}, err
+		return response.Error(request, err)
 	}
 
-	if registerUser.Username == "" || registerUser.Password == "" {
-		return events.APIGatewayProxyResponse{
-			Body:       "Invalid Request",
-			StatusCode: http.StatusBadRequest,
-		}, fmt.Errorf("register user request fields cannot be empty")
+	err = api.passwords.Check(registerUser.Username, registerUser.Password)
+	if err != nil {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"password": err.Error(),
+		}))
+	}
+
+	if api.requireVerification && registerUser.Email == "" {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"email": "is required",
+		}))
 	}
 
 	doesUserExist, err := api.dbStore.DoesUserExist(registerUser.Username)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, fmt.Errorf("checking if user exists error %w", err)
+		return response.Error(request, fmt.Errorf("checking if user exists error %w", err))
 	}
 
 	if doesUserExist {
-		return events.APIGatewayProxyResponse{
-			Body:       "User already exists",
-			StatusCode: http.StatusConflict,
-		}, nil
+		return response.Error(request, apierror.Conflict("User already exists"))
 	}
 
-	user, err := types.NewUser(registerUser)
+	passwordHash, err := api.passwords.Hash(registerUser.Password)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal Server error",
-			StatusCode: http.StatusConflict,
-		}, fmt.Errorf("error hashing user password %w", err)
+		return response.Error(request, fmt.Errorf("error hashing user password %w", err))
+	}
+
+	user := types.NewUser(registerUser, passwordHash)
+	if api.requireVerification {
+		user.Status = types.UserStatusUnverified
 	}
 
 	err = api.dbStore.InsertUser(user)
+	if errors.Is(err, database.ErrUserExists) {
+		return response.Error(request, apierror.Conflict("User already exists"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, fmt.Errorf("error inserting user into the database %w", err)
+		return response.Error(request, fmt.Errorf("error inserting user into the database %w", err))
+	}
+
+	if user.IsUnverified() {
+		err = api.sendVerification(user)
+		if err != nil {
+			// an account whose mail never went out could not be activated, so it goes and the name can register again
+			if deleteErr := api.dbStore.DeleteUser(user); deleteErr != nil {
+				log.Printf("removing user %s after the verification email failed: %v", user.Username, deleteErr)
+			}
+			return response.Error(request, fmt.Errorf("error sending verification email %w", err))
+		}
 	}
 
 	queueMessageBody := "New user created " + user.Username
 	err = api.msgQueue.SendMessage(queueMessageBody)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, fmt.Errorf("error inserting user into the database %w", err)
+		return response.Error(request, fmt.Errorf("error inserting user into the database %w", err))
 	}
 
-	return events.APIGatewayProxyResponse{
-		Body:       "Success",
-		StatusCode: http.StatusOK,
-	}, nil
+	return response.Text(http.StatusOK, "Success"), nil
 }
 
 func (api ApiHandler) LoginUser(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	type LoginRequest struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username string `json:"username" validate:"required,max=32"`
+		Password string `json:"password" validate:"required,maxbytes=72"`
 	}
 
 	var loginRequest LoginRequest
 
-	err := json.Unmarshal([]byte(request.Body), &loginRequest)
+	err := validate.Decode(request.Body, &loginRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	// locked logins are refused before any bcrypt work, whether the password is right or not
+	attemptKeys := lockout.Keys(loginRequest.Username, request.RequestContext.Identity.SourceIP)
+	attempts, err := api.attempts.GetLoginAttempts(attemptKeys)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "invalid request",
-			StatusCode: http.StatusBadRequest,
-		}, err
+		return response.Error(request, fmt.Errorf("error checking login attempts %w", err))
+	}
+
+	if retryAfter := lockout.RetryAfter(attempts, time.Now()); retryAfter > 0 {
+		return response.Error(request, apierror.TooManyRequests("Too many failed login attempts", retryAfter))
 	}
 
 	user, err := api.dbStore.GetUser(loginRequest.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		// same bcrypt work and the same answer as a wrong password, so usernames cannot be probed
+		api.passwords.CompareUnknownUser(loginRequest.Password)
+		return api.loginFailed(request, attemptKeys)
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
 	if !types.ValidatePassword(user.PasswordHash, loginRequest.Password) {
-		return events.APIGatewayProxyResponse{
-			Body:       "Invalid login credentials",
-			StatusCode: http.StatusUnauthorized,
-		}, nil
+		return api.loginFailed(request, attemptKeys)
+	}
+
+	// the plain password is only at hand here, so hashes from an older cost are upgraded on login
+	if api.passwords.NeedsRehash(user.PasswordHash) {
+		passwordHash, err := api.passwords.Hash(loginRequest.Password)
+		if err == nil {
+			err = api.dbStore.UpdatePasswordHash(user.Username, passwordHash)
+		}
+		if err != nil {
+			log.Printf("upgrading password hash of %s failed: %v", user.Username, err)
+		}
+	}
+
+	// checked after the password, so the answer does not tell whether an account exists
+	if api.requireVerification && user.IsUnverified() {
+		return response.Error(request, apierror.Forbidden("Email address not verified"))
+	}
+
+	// failures stay counted until the second factor is through too, or codes could be guessed between passwords
+	if user.MfaEnabled {
+		return api.mfaChallenge(request, user)
+	}
+
+	api.clearLoginFailures(attempts, user.Username)
+
+	return api.issueTokens(request, user, "", false)
+}
+
+func (api ApiHandler) RefreshToken(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	type RefreshRequest struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+
+	var refreshRequest RefreshRequest
+
+	err := validate.Decode(request.Body, &refreshRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	tokenHash := types.HashRefreshToken(refreshRequest.RefreshToken)
+
+	storedToken, err := api.tokenStore.GetRefreshToken(tokenHash)
+	if errors.Is(err, database.ErrRefreshTokenNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid refresh token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if storedToken.Revoked || storedToken.IsExpired() {
+		return response.Error(request, apierror.Unauthorized("Invalid refresh token"))
+	}
+
+	revoked, err := api.revocations.IsRevoked(storedToken.FamilyId, storedToken.Username, storedToken.IssuedAt)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if revoked {
+		return response.Error(request, apierror.Unauthorized("Invalid refresh token"))
+	}
+
+	err = api.tokenStore.RotateRefreshToken(tokenHash)
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		// a rotated token showing up again means it leaked, so the whole family goes
+		err = api.tokenStore.RevokeRefreshTokenFamily(storedToken.FamilyId)
+		if err != nil {
+			return response.Error(request, fmt.Errorf("error revoking refresh token family %w", err))
+		}
+
+		return response.Error(request, apierror.Unauthorized("Invalid refresh token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	user, err := api.dbStore.GetUser(storedToken.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid refresh token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	return api.issueTokens(request, user, storedToken.FamilyId, storedToken.Mfa)
+}
+
+// issueTokens signs new access tokens for the user and product APIs and stores a new
+// refresh token in the given family. The family id doubles as the session id of the
+// access tokens, so revoking the session covers every token refreshed from it.
+// mfa marks a session that was started with a second factor.
+func (api ApiHandler) issueTokens(request events.APIGatewayProxyRequest, user types.User, familyId string, mfa bool) (events.APIGatewayProxyResponse, error) {
+	signingKey, err := api.keys.SigningKey()
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	refreshToken, storedToken, err := types.NewRefreshToken(user.Username, familyId, mfa)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error generating refresh token %w", err))
+	}
+
+	authMethods := []string{auth.AuthMethodPassword}
+	if mfa {
+		authMethods = append(authMethods, auth.AuthMethodOTP)
+	}
+
+	accessToken, err := types.CreateToken(user, storedToken.FamilyId, auth.UserApiAudience, authMethods, signingKey)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error signing access token %w", err))
+	}
+
+	productToken, err := types.CreateToken(user, storedToken.FamilyId, auth.ProductApiAudience, authMethods, signingKey)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error signing product token %w", err))
+	}
+
+	err = api.tokenStore.InsertRefreshToken(storedToken)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error storing refresh token %w", err))
+	}
+
+	return response.JSON(http.StatusOK, types.TokenResponse{
+		AccessToken:  accessToken,
+		ProductToken: productToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+func (api ApiHandler) Logout(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+	type LogoutRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	var logoutRequest LogoutRequest
+
+	if request.Body != "" {
+		err := validate.Decode(request.Body, &logoutRequest)
+		if err != nil {
+			return response.Error(request, err)
+		}
+	}
+
+	// the session can be refreshed until its refresh token expires, so the entry has to outlive that
+	err := api.revocations.RevokeSession(userContext.SessionId, time.Now().Add(common.RefreshTokenTTL).Unix())
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error revoking session %w", err))
+	}
+
+	if logoutRequest.RefreshToken != "" {
+		storedToken, err := api.tokenStore.GetRefreshToken(types.HashRefreshToken(logoutRequest.RefreshToken))
+		if err != nil && !errors.Is(err, database.ErrRefreshTokenNotFound) {
+			return response.Error(request, err)
+		}
+
+		if err == nil && storedToken.Username == userContext.Username {
+			err = api.tokenStore.RevokeRefreshTokenFamily(storedToken.FamilyId)
+			if err != nil {
+				return response.Error(request, fmt.Errorf("error revoking refresh token family %w", err))
+			}
+		}
+	}
+
+	return response.Text(http.StatusOK, "Logged out"), nil
+}
+
+// JWKS publishes the public keys that verify access tokens, so other services never hold a signing key.
+func (api ApiHandler) JWKS(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	document, err := signing.MarshalKeySet(api.keys)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error loading public keys %w", err))
 	}
-	accessToken := types.CreateToken(user)
-	successMsg := fmt.Sprintf(`{"access_token": "%s"}`, accessToken)
 
 	return events.APIGatewayProxyResponse{
-		Body:       successMsg,
+		Body:       string(document),
 		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type":  "application/json",
+			"Cache-Control": "public, max-age=300",
+		},
 	}, nil
 }
 
-func (api ApiHandler) GetUser(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
+func (api ApiHandler) GetUser(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
 
 	if userContext.Username == "" {
-		return events.APIGatewayProxyResponse{
-			Body:       "Anauthorized error",
-			StatusCode: http.StatusUnauthorized,
-		}, fmt.Errorf("user context is nil")
+		return response.Error(request, apierror.Unauthorized("User Unauthorized").Wrap(auth.ErrMissingUserContext))
 	}
 
 	username := userContext.Username
 
 	user, err := api.dbStore.GetUser(username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
 	successMsg := fmt.Sprintf(`{"username": "%s", "role": "%s"}`, username, user.Role)
 
-	return events.APIGatewayProxyResponse{
-		Body:       successMsg,
-		StatusCode: http.StatusOK,
-	}, nil
+	return response.Text(http.StatusOK, successMsg), nil
 }
 
-func (api ApiHandler) UpdateRole(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
-
-	result, err := checkAdmin(userContext)
-	if err != nil {
-		return result, err
-	}
+func (api ApiHandler) UpdateRole(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
 
 	type RoleRequest struct {
-		Username string `json:"username"`
-		NewRole  string `json:"newrole"`
+		Username string `json:"username" validate:"required"`
+		NewRole  string `json:"newrole" validate:"required"`
 	}
 
 	var roleRequest RoleRequest
 
-	err = json.Unmarshal([]byte(request.Body), &roleRequest)
+	err := validate.Decode(request.Body, &roleRequest)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "invalid request",
-			StatusCode: http.StatusBadRequest,
-		}, err
+		return response.Error(request, err)
 	}
 
 	user, err := api.dbStore.GetUser(roleRequest.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	isRole, err := api.roles.IsRole(roleRequest.NewRole)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error loading roles %w", err))
+	}
+	if !isRole {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"newrole": fmt.Sprintf("unknown role %s", roleRequest.NewRole),
+		}))
+	}
+
+	// users:write alone must not raise anyone's permissions, the caller's own included
+	canGrant, err := api.roles.CanGrant(userContext, roleRequest.NewRole)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
+	}
+	if !canGrant {
+		return response.Error(request, apierror.Forbidden("Role "+roleRequest.NewRole+" grants permissions you do not hold").Wrap(auth.ErrMissingPermission))
 	}
 
-	if !(roleRequest.NewRole == common.RoleAdmin || roleRequest.NewRole == common.RoleUser) {
-		return events.APIGatewayProxyResponse{
-			Body:       fmt.Sprintf("Invalid role %s", roleRequest.NewRole),
-			StatusCode: http.StatusBadRequest,
-		}, err
+	// nor lower someone who holds more than the caller, such as an admin
+	canRevoke, err := api.roles.CanGrant(userContext, user.Role)
+	if err != nil {
+		return response.Error(request, err)
+	}
+	if !canRevoke {
+		return response.Error(request, apierror.Forbidden("Role "+user.Role+" grants permissions you do not hold").Wrap(auth.ErrMissingPermission))
 	}
 
 	user.Role = roleRequest.NewRole
 
 	err = api.dbStore.UpdateUser(user)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	// tokens carry the role, so the old ones must not outlive the change
+	err = api.revocations.RevokeUserTokens(user.Username)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, fmt.Errorf("error revoking user tokens %w", err))
 	}
 
 	successMsg := fmt.Sprintf(`{"username": "%s", "role": "%s"}`, user.Username, user.Role)
 
-	return events.APIGatewayProxyResponse{
-		Body:       successMsg,
-		StatusCode: http.StatusOK,
-	}, nil
+	return response.Text(http.StatusOK, successMsg), nil
 }
 
-func (api ApiHandler) RemoveUser(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
-
-	result, err := checkAdmin(userContext)
-	if err != nil {
-		return result, err
-	}
+func (api ApiHandler) RemoveUser(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
 
 	username := request.QueryStringParameters["username"]
 
 	user, err := api.dbStore.GetUser(username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
 	err = api.dbStore.DeleteUser(user)
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
+	}
+
+	err = api.revocations.RevokeUserTokens(user.Username)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error revoking user tokens %w", err))
 	}
 
-	successMsg := fmt.Sprintf(`user removed`, username)
+	successMsg := fmt.Sprintf(`user %s removed`, username)
 
-	return events.APIGatewayProxyResponse{
-		Body:       successMsg,
-		StatusCode: http.StatusOK,
-	}, nil
+	return response.Text(http.StatusOK, successMsg), nil
 }
 
-func (api ApiHandler) ListUsers(request events.APIGatewayProxyRequest, userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
+func (api ApiHandler) ListUsers(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
 
-	result, err := checkAdmin(userContext)
+	limit, cursor, err := parsePage(request)
 	if err != nil {
-		return result, err
+		return response.Error(request, err)
 	}
 
-	users, err := api.dbStore.ListUsers()
+	users, nextCursor, err := api.dbStore.ListUsersPage(limit, cursor)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return response.Error(request, apierror.BadRequest("Invalid cursor"))
+	}
 	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
+		return response.Error(request, err)
 	}
 
-	var userResponse []types.UserResponse
+	userResponse := types.UserListResponse{
+		Items:      []types.UserResponse{},
+		NextCursor: nextCursor,
+	}
 	for _, user := range users {
-		userResponse = append(userResponse, types.UserResponse{
+		userResponse.Items = append(userResponse.Items, types.UserResponse{
 			Username: user.Username,
 			Role:     user.Role,
+			Email:    user.Email,
+			Status:   user.Status,
 		})
 	}
 
-	jsonResponse, err := json.Marshal(userResponse)
-	if err != nil {
-		return events.APIGatewayProxyResponse{
-			Body:       "Internal server error",
-			StatusCode: http.StatusInternalServerError,
-		}, err
-	}
-
-	return events.APIGatewayProxyResponse{
-		Body:       string(jsonResponse),
-		StatusCode: http.StatusOK,
-	}, nil
+	return response.JSON(http.StatusOK, userResponse)
 }
 
-func checkAdmin(userContext types.UserContext) (events.APIGatewayProxyResponse, error) {
-
-	fmt.Println("in checkAdmin. userContext.Role is ", userContext.Role)
-
-	if userContext.Username == "" {
-		fmt.Println("in checkAdmin. userContext Username is nil")
-		return events.APIGatewayProxyResponse{
-			Body:       "Anauthorized error",
-			StatusCode: http.StatusUnauthorized,
-		}, fmt.Errorf("user context is nil")
-	}
+func parsePage(request events.APIGatewayProxyRequest) (int, string, error) {
+	limit := common.DefaultPageSize
 
-	if userContext.Role != common.RoleAdmin {
-		fmt.Println("in checkAdmin. userContext Role is not admin, it is ", userContext.Role)
-		return events.APIGatewayProxyResponse{
-			Body:       "Anauthorized error",
-			StatusCode: http.StatusUnauthorized,
-		}, fmt.Errorf("user does not have enogth privileges")
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > common.MaxPageSize {
+			return 0, "", apierror.BadRequest(fmt.Sprintf("limit must be between 1 and %d", common.MaxPageSize))
+		}
+		limit = parsed
 	}
 
-	return events.APIGatewayProxyResponse{}, nil
+	return limit, request.QueryStringParameters["cursor"], nil
 }
@@ -0,0 +1,1084 @@
+package api
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/mfa"
+	"lambda-func/notify"
+	"lambda-func/password"
+	"lambda-func/signing"
+	"lambda-func/types"
+	"net/http"
+	"shared/auth"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errStore = errors.New("store unavailable")
+
+type fakeUserStore struct {
+	users map[string]types.User
+	errs  map[string]error
+}
+
+func newFakeUserStore(users ...types.User) *fakeUserStore {
+	store := &fakeUserStore{
+		users: map[string]types.User{},
+		errs:  map[string]error{},
+	}
+	for _, user := range users {
+		store.users[user.Username] = user
+	}
+	return store
+}
+
+func (f *fakeUserStore) DoesUserExist(username string) (bool, error) {
+	if err := f.errs["DoesUserExist"]; err != nil {
+		return false, err
+	}
+	_, ok := f.users[username]
+	return ok, nil
+}
+
+func (f *fakeUserStore) InsertUser(user types.User) error {
+	if err := f.errs["InsertUser"]; err != nil {
+		return err
+	}
+	if _, ok := f.users[user.Username]; ok {
+		return database.ErrUserExists
+	}
+	f.users[user.Username] = user
+	return nil
+}
+
+func (f *fakeUserStore) GetUser(username string) (types.User, error) {
+	if err := f.errs["GetUser"]; err != nil {
+		return types.User{}, err
+	}
+	user, ok := f.users[username]
+	if !ok {
+		return types.User{}, database.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUserStore) UpdateUser(user types.User) error {
+	if err := f.errs["UpdateUser"]; err != nil {
+		return err
+	}
+	if _, ok := f.users[user.Username]; !ok {
+		return database.ErrUserNotFound
+	}
+	f.users[user.Username] = user
+	return nil
+}
+
+func (f *fakeUserStore) UpdatePasswordHash(username string, passwordHash string) error {
+	if err := f.errs["UpdatePasswordHash"]; err != nil {
+		return err
+	}
+	user, ok := f.users[username]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	f.users[username] = user
+	return nil
+}
+
+func (f *fakeUserStore) SetMfaSecret(username string, secret string) error {
+	if err := f.errs["SetMfaSecret"]; err != nil {
+		return err
+	}
+	user, ok := f.users[username]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	user.MfaSecret = secret
+	f.users[username] = user
+	return nil
+}
+
+func (f *fakeUserStore) EnableMfa(username string, secret string, recoveryCodeHashes []string, step int64) error {
+	if err := f.errs["EnableMfa"]; err != nil {
+		return err
+	}
+	user, ok := f.users[username]
+	if !ok || user.MfaSecret != secret {
+		return database.ErrMfaSetupChanged
+	}
+	user.MfaEnabled = true
+	user.MfaLastStep = step
+	user.RecoveryCodes = recoveryCodeHashes
+	f.users[username] = user
+	return nil
+}
+
+func (f *fakeUserStore) UseMfaStep(username string, step int64) error {
+	if err := f.errs["UseMfaStep"]; err != nil {
+		return err
+	}
+	user := f.users[username]
+	if user.MfaLastStep >= step {
+		return database.ErrMfaCodeUsed
+	}
+	user.MfaLastStep = step
+	f.users[username] = user
+	return nil
+}
+
+func (f *fakeUserStore) UseRecoveryCode(username string, codeHash string) error {
+	if err := f.errs["UseRecoveryCode"]; err != nil {
+		return err
+	}
+	user := f.users[username]
+	for i, stored := range user.RecoveryCodes {
+		if stored == codeHash {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			f.users[username] = user
+			return nil
+		}
+	}
+	return database.ErrRecoveryCodeNotFound
+}
+
+func (f *fakeUserStore) VerifyEmail(username string, email string) error {
+	if err := f.errs["VerifyEmail"]; err != nil {
+		return err
+	}
+	user, ok := f.users[username]
+	if !ok || !user.IsUnverified() || user.Email != email {
+		return database.ErrEmailNotPending
+	}
+	user.Status = types.UserStatusActive
+	f.users[username] = user
+	return nil
+}
+
+func (f *fakeUserStore) DeleteUser(user types.User) error {
+	if err := f.errs["DeleteUser"]; err != nil {
+		return err
+	}
+	delete(f.users, user.Username)
+	return nil
+}
+
+// ListUsersPage pages over usernames in order; the cursor is the last username returned.
+func (f *fakeUserStore) ListUsersPage(limit int, cursor string) ([]types.User, string, error) {
+	if err := f.errs["ListUsers"]; err != nil {
+		return nil, "", err
+	}
+	if _, ok := f.users[cursor]; cursor != "" && !ok {
+		return nil, "", database.ErrInvalidCursor
+	}
+
+	var usernames []string
+	for username := range f.users {
+		if username > cursor {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+
+	nextCursor := ""
+	if len(usernames) > limit {
+		usernames = usernames[:limit]
+		nextCursor = usernames[limit-1]
+	}
+
+	var users []types.User
+	for _, username := range usernames {
+		users = append(users, f.users[username])
+	}
+	return users, nextCursor, nil
+}
+
+type fakeRefreshTokenStore struct {
+	tokens map[string]types.RefreshToken
+	errs   map[string]error
+}
+
+func newFakeRefreshTokenStore(tokens ...types.RefreshToken) *fakeRefreshTokenStore {
+	store := &fakeRefreshTokenStore{
+		tokens: map[string]types.RefreshToken{},
+		errs:   map[string]error{},
+	}
+	for _, token := range tokens {
+		store.tokens[token.TokenHash] = token
+	}
+	return store
+}
+
+func (f *fakeRefreshTokenStore) InsertRefreshToken(token types.RefreshToken) error {
+	if err := f.errs["InsertRefreshToken"]; err != nil {
+		return err
+	}
+	f.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (f *fakeRefreshTokenStore) GetRefreshToken(tokenHash string) (types.RefreshToken, error) {
+	if err := f.errs["GetRefreshToken"]; err != nil {
+		return types.RefreshToken{}, err
+	}
+	token, ok := f.tokens[tokenHash]
+	if !ok {
+		return types.RefreshToken{}, database.ErrRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (f *fakeRefreshTokenStore) RotateRefreshToken(tokenHash string) error {
+	if err := f.errs["RotateRefreshToken"]; err != nil {
+		return err
+	}
+	token := f.tokens[tokenHash]
+	if token.Rotated || token.Revoked {
+		return database.ErrRefreshTokenReused
+	}
+	token.Rotated = true
+	f.tokens[tokenHash] = token
+	return nil
+}
+
+func (f *fakeRefreshTokenStore) RevokeRefreshTokenFamily(familyId string) error {
+	if err := f.errs["RevokeRefreshTokenFamily"]; err != nil {
+		return err
+	}
+	for tokenHash, token := range f.tokens {
+		if token.FamilyId == familyId {
+			token.Revoked = true
+			f.tokens[tokenHash] = token
+		}
+	}
+	return nil
+}
+
+type fakePasswordResetStore struct {
+	resets map[string]types.PasswordReset
+	errs   map[string]error
+}
+
+func newFakePasswordResetStore(resets ...types.PasswordReset) *fakePasswordResetStore {
+	store := &fakePasswordResetStore{
+		resets: map[string]types.PasswordReset{},
+		errs:   map[string]error{},
+	}
+	for _, reset := range resets {
+		store.resets[reset.TokenHash] = reset
+	}
+	return store
+}
+
+func (f *fakePasswordResetStore) InsertPasswordReset(reset types.PasswordReset) error {
+	if err := f.errs["InsertPasswordReset"]; err != nil {
+		return err
+	}
+	f.resets[reset.TokenHash] = reset
+	return nil
+}
+
+func (f *fakePasswordResetStore) GetPasswordReset(tokenHash string) (types.PasswordReset, error) {
+	if err := f.errs["GetPasswordReset"]; err != nil {
+		return types.PasswordReset{}, err
+	}
+	reset, ok := f.resets[tokenHash]
+	if !ok {
+		return types.PasswordReset{}, database.ErrPasswordResetNotFound
+	}
+	return reset, nil
+}
+
+func (f *fakePasswordResetStore) ConsumePasswordReset(tokenHash string) error {
+	if err := f.errs["ConsumePasswordReset"]; err != nil {
+		return err
+	}
+	if _, ok := f.resets[tokenHash]; !ok {
+		return database.ErrPasswordResetNotFound
+	}
+	delete(f.resets, tokenHash)
+	return nil
+}
+
+type fakeLoginAttemptStore struct {
+	attempts map[string]types.LoginAttempts
+	errs     map[string]error
+}
+
+func newFakeLoginAttemptStore(attempts ...types.LoginAttempts) *fakeLoginAttemptStore {
+	store := &fakeLoginAttemptStore{
+		attempts: map[string]types.LoginAttempts{},
+		errs:     map[string]error{},
+	}
+	for _, attempt := range attempts {
+		store.attempts[attempt.Key] = attempt
+	}
+	return store
+}
+
+func (f *fakeLoginAttemptStore) GetLoginAttempts(keys []string) ([]types.LoginAttempts, error) {
+	if err := f.errs["GetLoginAttempts"]; err != nil {
+		return nil, err
+	}
+	var attempts []types.LoginAttempts
+	for _, key := range keys {
+		if attempt, ok := f.attempts[key]; ok {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (f *fakeLoginAttemptStore) RecordLoginFailure(key string, window time.Duration) (types.LoginAttempts, error) {
+	if err := f.errs["RecordLoginFailure"]; err != nil {
+		return types.LoginAttempts{}, err
+	}
+	attempts := f.attempts[key]
+	attempts.Key = key
+	attempts.Failures++
+	f.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (f *fakeLoginAttemptStore) LockLogin(attempts types.LoginAttempts) error {
+	if err := f.errs["LockLogin"]; err != nil {
+		return err
+	}
+	f.attempts[attempts.Key] = attempts
+	return nil
+}
+
+func (f *fakeLoginAttemptStore) ClearLoginAttempts(key string) error {
+	if err := f.errs["ClearLoginAttempts"]; err != nil {
+		return err
+	}
+	delete(f.attempts, key)
+	return nil
+}
+
+type fakeRevocationStore struct {
+	sessions map[string]bool
+	users    map[string]bool
+	err      error
+}
+
+func newFakeRevocationStore() *fakeRevocationStore {
+	return &fakeRevocationStore{
+		sessions: map[string]bool{},
+		users:    map[string]bool{},
+	}
+}
+
+func (f *fakeRevocationStore) RevokeSession(sessionId string, expiresAt int64) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sessions[sessionId] = true
+	return nil
+}
+
+func (f *fakeRevocationStore) RevokeUserTokens(username string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.users[username] = true
+	return nil
+}
+
+func (f *fakeRevocationStore) IsRevoked(sessionId string, username string, issuedAt int64) (bool, error) {
+	if f.err != nil {
+		return true, f.err
+	}
+	return f.sessions[sessionId] || f.users[username], nil
+}
+
+type fakeQueue struct {
+	messages []string
+	err      error
+}
+
+func (f *fakeQueue) SendMessage(message string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+type failingKeySet struct{}
+
+func (failingKeySet) SigningKey() (signing.Key, error) {
+	return signing.Key{}, errStore
+}
+
+func (failingKeySet) PublicKeys() (map[string]*rsa.PublicKey, error) {
+	return nil, errStore
+}
+
+func (failingKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	return nil, errStore
+}
+
+var (
+	testKeys     = newTestKeySet()
+	testPolicy   = newTestPolicy(bcrypt.MinCost)
+	testLockouts = newTestLockouts()
+	testMfa      = mfa.NewPolicy("JITestDemo", false)
+	testNotifier = notify.NewMailbox()
+	testRoles    = auth.NewRoles(nil, time.Minute)
+	adminContext = auth.UserContext{Username: "root", Role: auth.RoleAdmin}
+	userContext  = auth.UserContext{Username: "alice", Role: auth.RoleUser}
+
+	supportContext = auth.UserContext{Username: "sam", Role: auth.RoleSupport}
+)
+
+type staticRoleSource map[string][]string
+
+func (s staticRoleSource) LoadRoles() (map[string][]string, error) {
+	return s, nil
+}
+
+func newTestKeySet() signing.StaticKeySet {
+	keys, err := signing.NewEphemeralKeySet()
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+func newTestPolicy(cost int) *password.Policy {
+	policy, err := password.NewPolicy(8, 2, cost)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// newTestLockouts locks a user after 3 failures and an address after 5, for 1, 2, 4 and at most 8 minutes.
+func newTestLockouts() *lockout.Policy {
+	policy, err := lockout.NewPolicy(3, 5, time.Minute, time.Minute, 8*time.Minute)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func newTestUser(t *testing.T, username, plainPassword, role string) types.User {
+	t.Helper()
+	passwordHash, err := testPolicy.Hash(plainPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	user := types.NewUser(types.RegisterUser{Username: username, Password: plainPassword}, passwordHash)
+	user.Role = role
+	return user
+}
+
+func parseClaims(t *testing.T, tokenString string) *auth.TokenClaims {
+	t.Helper()
+	claims := &auth.TokenClaims{}
+	publicKeys, _ := testKeys.PublicKeys()
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return publicKeys[token.Header["kid"].(string)], nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil {
+		t.Fatalf("parse token %q: %v", tokenString, err)
+	}
+	return claims
+}
+
+type errorBody struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	RequestId string            `json:"requestId"`
+	Details   map[string]string `json:"details"`
+}
+
+func decodeBody(t *testing.T, response events.APIGatewayProxyResponse, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(response.Body), v); err != nil {
+		t.Fatalf("body %q is not valid JSON: %v", response.Body, err)
+	}
+}
+
+func TestRegisterUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		existing   []types.User
+		storeErrs  map[string]error
+		queueErr   error
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "success", body: `{"username":"bob","password":"correct horse"}`, wantStatus: http.StatusOK, wantBody: "Success"},
+		{name: "with email", body: `{"username":"bob","password":"correct horse","email":"bob@example.com"}`, wantStatus: http.StatusOK, wantBody: "Success"},
+		{name: "invalid email", body: `{"username":"bob","password":"correct horse","email":"bob"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "malformed json", body: `{"username":`, wantStatus: http.StatusBadRequest},
+		{name: "empty password", body: `{"username":"bob","password":""}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "empty username", body: `{"username":"","password":"correct horse"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "short password", body: `{"username":"bob","password":"secret"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "username charset", body: `{"username":"bob smith","password":"correct horse"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "common password", body: `{"username":"bob","password":"Password123"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "password contains username", body: `{"username":"bobby","password":"I am Bobby!"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "single character class", body: `{"username":"bob","password":"correcthorse"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "multibyte password within 72 bytes", body: `{"username":"bob","password":"` + strings.Repeat("Ää", 17) + `1!"}`, wantStatus: http.StatusOK, wantBody: "Success"},
+		{name: "multibyte password over 72 bytes", body: `{"username":"bob","password":"` + strings.Repeat("Ä", 60) + `1"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown field", body: `{"username":"bob","password":"correct horse","role":"admin"}`, wantStatus: http.StatusBadRequest},
+		{name: "already exists", body: `{"username":"bob","password":"correct horse"}`, existing: []types.User{{Username: "bob"}}, wantStatus: http.StatusConflict},
+		{name: "exists check fails", body: `{"username":"bob","password":"correct horse"}`, storeErrs: map[string]error{"DoesUserExist": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "registered meanwhile", body: `{"username":"bob","password":"correct horse"}`, storeErrs: map[string]error{"InsertUser": database.ErrUserExists}, wantStatus: http.StatusConflict},
+		{name: "insert fails", body: `{"username":"bob","password":"correct horse"}`, storeErrs: map[string]error{"InsertUser": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "queue fails", body: `{"username":"bob","password":"correct horse"}`, queueErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(tt.existing...)
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+			queue := &fakeQueue{err: tt.queueErr}
+
+			request := events.APIGatewayProxyRequest{
+				Body:           tt.body,
+				RequestContext: events.APIGatewayProxyRequestContext{RequestID: "request-1"},
+			}
+
+			response, err := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).RegisterUser(request)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if err != nil {
+				t.Errorf("err = %v, errors belong in the response body", err)
+			}
+			if tt.wantBody != "" && response.Body != tt.wantBody {
+				t.Errorf("body = %q, want %q", response.Body, tt.wantBody)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body errorBody
+				decodeBody(t, response, &body)
+				if body.Code == "" || body.Message == "" || body.RequestId != "request-1" {
+					t.Errorf("error body = %+v", body)
+				}
+			}
+			if tt.wantStatus == http.StatusOK {
+				user, ok := store.users["bob"]
+				if !ok {
+					t.Fatal("user was not stored")
+				}
+				if user.Role != auth.RoleUser || user.PasswordHash == "secret" || user.IsUnverified() {
+					t.Errorf("stored user = %+v, want an active user with hashed password and role %q", user, auth.RoleUser)
+				}
+				if len(queue.messages) != 1 || queue.messages[0] != "New user created bob" {
+					t.Errorf("queue messages = %v", queue.messages)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginUser(t *testing.T) {
+	alice := newTestUser(t, "alice", "secret", auth.RoleUser)
+
+	tests := []struct {
+		name       string
+		body       string
+		storeErr   error
+		tokenErr   error
+		keys       signing.KeySet
+		wantStatus int
+	}{
+		{name: "success", body: `{"username":"alice","password":"secret"}`, wantStatus: http.StatusOK},
+		{name: "malformed json", body: `not json`, wantStatus: http.StatusBadRequest},
+		{name: "wrong password", body: `{"username":"alice","password":"nope"}`, wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", body: `{"username":"mallory","password":"secret"}`, wantStatus: http.StatusUnauthorized},
+		{name: "store fails", body: `{"username":"alice","password":"secret"}`, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+		{name: "refresh token store fails", body: `{"username":"alice","password":"secret"}`, tokenErr: errStore, wantStatus: http.StatusInternalServerError},
+		{name: "signing key unavailable", body: `{"username":"alice","password":"secret"}`, keys: signing.StaticKeySet{}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(alice)
+			store.errs["GetUser"] = tt.storeErr
+			tokens := newFakeRefreshTokenStore()
+			tokens.errs["InsertRefreshToken"] = tt.tokenErr
+			keys := tt.keys
+			if keys == nil {
+				keys = testKeys
+			}
+
+			response, _ := NewApiHandler(store, tokens, newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, keys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).LoginUser(events.APIGatewayProxyRequest{Body: tt.body})
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.TokenResponse
+				decodeBody(t, response, &body)
+				if body.AccessToken == "" || body.RefreshToken == "" {
+					t.Errorf("body = %+v, want both tokens", body)
+				}
+				stored, ok := tokens.tokens[types.HashRefreshToken(body.RefreshToken)]
+				if !ok {
+					t.Fatal("refresh token was not stored hashed")
+				}
+				if stored.Username != "alice" || stored.FamilyId == "" || stored.IsExpired() {
+					t.Errorf("stored refresh token = %+v", stored)
+				}
+				for token, audience := range map[string]string{body.AccessToken: auth.UserApiAudience, body.ProductToken: auth.ProductApiAudience} {
+					claims := parseClaims(t, token)
+					if claims.Subject != "alice" || claims.Role != auth.RoleUser || claims.Issuer != auth.TokenIssuer {
+						t.Errorf("claims = %+v", claims)
+					}
+					if len(claims.Audience) != 1 || claims.Audience[0] != audience {
+						t.Errorf("audience = %v, want %s", claims.Audience, audience)
+					}
+					if claims.SessionId != stored.FamilyId {
+						t.Errorf("sid = %q, want refresh token family %q", claims.SessionId, stored.FamilyId)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	store := newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser))
+	stronger := newTestPolicy(bcrypt.MinCost + 1)
+
+	response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, stronger, testLockouts, testMfa, testRoles, testNotifier, false).LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"alice","password":"secret"}`})
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, http.StatusOK, response.Body)
+	}
+	cost, err := bcrypt.Cost([]byte(store.users["alice"].PasswordHash))
+	if err != nil || cost != bcrypt.MinCost+1 {
+		t.Errorf("stored hash cost = %d, %v, want %d", cost, err, bcrypt.MinCost+1)
+	}
+	if !types.ValidatePassword(store.users["alice"].PasswordHash, "secret") {
+		t.Error("upgraded hash does not match the password")
+	}
+
+	// a failed upgrade must not cost the user their login
+	store = newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser))
+	store.errs["UpdatePasswordHash"] = errStore
+
+	response, _ = NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, stronger, testLockouts, testMfa, testRoles, testNotifier, false).LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"alice","password":"secret"}`})
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d after a failed upgrade, want %d", response.StatusCode, http.StatusOK)
+	}
+}
+
+func TestLoginUnknownUser(t *testing.T) {
+	handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	wrongPassword, _ := handler.LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"alice","password":"nope"}`})
+	unknownUser, _ := handler.LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"mallory","password":"nope"}`})
+
+	if unknownUser.StatusCode != wrongPassword.StatusCode || unknownUser.Body != wrongPassword.Body {
+		t.Errorf("unknown user = %d %q, wrong password = %d %q", unknownUser.StatusCode, unknownUser.Body, wrongPassword.StatusCode, wrongPassword.Body)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	alice := types.User{Username: "alice", Role: auth.RoleUser}
+	active := types.RefreshToken{TokenHash: types.HashRefreshToken("active"), Username: "alice", FamilyId: "f1", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	sibling := types.RefreshToken{TokenHash: types.HashRefreshToken("sibling"), Username: "alice", FamilyId: "f1", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	rotated := types.RefreshToken{TokenHash: types.HashRefreshToken("rotated"), Username: "alice", FamilyId: "f1", ExpiresAt: time.Now().Add(time.Hour).Unix(), Rotated: true}
+	expired := types.RefreshToken{TokenHash: types.HashRefreshToken("expired"), Username: "alice", FamilyId: "f2", ExpiresAt: time.Now().Add(-time.Hour).Unix()}
+	revoked := types.RefreshToken{TokenHash: types.HashRefreshToken("revoked"), Username: "alice", FamilyId: "f3", ExpiresAt: time.Now().Add(time.Hour).Unix(), Revoked: true}
+
+	tests := []struct {
+		name           string
+		body           string
+		tokenErrs      map[string]error
+		storeErr       error
+		revokedUser    bool
+		revokedSession bool
+		revocationErr  error
+		wantStatus     int
+		wantFamilyGone bool
+	}{
+		{name: "success", body: `{"refresh_token":"active"}`, wantStatus: http.StatusOK},
+		{name: "malformed json", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "missing token", body: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown token", body: `{"refresh_token":"nope"}`, wantStatus: http.StatusUnauthorized},
+		{name: "expired token", body: `{"refresh_token":"expired"}`, wantStatus: http.StatusUnauthorized},
+		{name: "revoked token", body: `{"refresh_token":"revoked"}`, wantStatus: http.StatusUnauthorized},
+		{name: "reused token revokes family", body: `{"refresh_token":"rotated"}`, wantStatus: http.StatusUnauthorized, wantFamilyGone: true},
+		{name: "lookup fails", body: `{"refresh_token":"active"}`, tokenErrs: map[string]error{"GetRefreshToken": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "revoke fails", body: `{"refresh_token":"rotated"}`, tokenErrs: map[string]error{"RevokeRefreshTokenFamily": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "user lookup fails", body: `{"refresh_token":"active"}`, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+		{name: "user removed", body: `{"refresh_token":"active"}`, storeErr: database.ErrUserNotFound, wantStatus: http.StatusUnauthorized},
+		{name: "user tokens revoked", body: `{"refresh_token":"active"}`, revokedUser: true, wantStatus: http.StatusUnauthorized},
+		{name: "session revoked", body: `{"refresh_token":"active"}`, revokedSession: true, wantStatus: http.StatusUnauthorized},
+		{name: "revocation check fails", body: `{"refresh_token":"active"}`, revocationErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(alice)
+			store.errs["GetUser"] = tt.storeErr
+			tokens := newFakeRefreshTokenStore(active, sibling, rotated, expired, revoked)
+			for method, err := range tt.tokenErrs {
+				tokens.errs[method] = err
+			}
+			revocations := newFakeRevocationStore()
+			revocations.users["alice"] = tt.revokedUser
+			revocations.sessions[active.FamilyId] = tt.revokedSession
+			revocations.err = tt.revocationErr
+
+			response, _ := NewApiHandler(store, tokens, newFakePasswordResetStore(), newFakeLoginAttemptStore(), revocations, &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).RefreshToken(events.APIGatewayProxyRequest{Body: tt.body})
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.TokenResponse
+				decodeBody(t, response, &body)
+				if body.AccessToken == "" || body.RefreshToken == "" {
+					t.Fatalf("body = %+v, want both tokens", body)
+				}
+				if !tokens.tokens[active.TokenHash].Rotated {
+					t.Error("presented refresh token was not rotated")
+				}
+				next := tokens.tokens[types.HashRefreshToken(body.RefreshToken)]
+				if next.FamilyId != active.FamilyId || next.Rotated || next.Revoked {
+					t.Errorf("new refresh token = %+v, want fresh token in family %s", next, active.FamilyId)
+				}
+			}
+			if tt.wantFamilyGone && !tokens.tokens[sibling.TokenHash].Revoked {
+				t.Error("token family was not revoked after reuse")
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	session := auth.UserContext{Username: "alice", Role: auth.RoleUser, SessionId: "f1"}
+	own := types.RefreshToken{TokenHash: types.HashRefreshToken("own"), Username: "alice", FamilyId: "f1", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	foreign := types.RefreshToken{TokenHash: types.HashRefreshToken("foreign"), Username: "bob", FamilyId: "f2", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name            string
+		context         auth.UserContext
+		body            string
+		tokenErrs       map[string]error
+		revocationErr   error
+		wantStatus      int
+		wantSessionGone bool
+		wantRevokedHash string
+	}{
+		{name: "success", context: session, wantStatus: http.StatusOK, wantSessionGone: true},
+		{name: "revokes refresh family", context: session, body: `{"refresh_token":"own"}`, wantStatus: http.StatusOK, wantSessionGone: true, wantRevokedHash: own.TokenHash},
+		{name: "ignores foreign refresh token", context: session, body: `{"refresh_token":"foreign"}`, wantStatus: http.StatusOK, wantSessionGone: true},
+		{name: "unknown refresh token", context: session, body: `{"refresh_token":"nope"}`, wantStatus: http.StatusOK, wantSessionGone: true},
+		{name: "malformed json", context: session, body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "revocation fails", context: session, revocationErr: errStore, wantStatus: http.StatusInternalServerError},
+		{name: "refresh lookup fails", context: session, body: `{"refresh_token":"own"}`, tokenErrs: map[string]error{"GetRefreshToken": errStore}, wantStatus: http.StatusInternalServerError, wantSessionGone: true},
+		{name: "family revoke fails", context: session, body: `{"refresh_token":"own"}`, tokenErrs: map[string]error{"RevokeRefreshTokenFamily": errStore}, wantStatus: http.StatusInternalServerError, wantSessionGone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := newFakeRefreshTokenStore(own, foreign)
+			for method, err := range tt.tokenErrs {
+				tokens.errs[method] = err
+			}
+			revocations := newFakeRevocationStore()
+			revocations.err = tt.revocationErr
+
+			response, _ := NewApiHandler(newFakeUserStore(), tokens, newFakePasswordResetStore(), newFakeLoginAttemptStore(), revocations, &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).Logout(events.APIGatewayProxyRequest{Body: tt.body}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantSessionGone != revocations.sessions[session.SessionId] {
+				t.Errorf("session revoked = %v, want %v", revocations.sessions[session.SessionId], tt.wantSessionGone)
+			}
+			for hash, token := range tokens.tokens {
+				if token.Revoked != (hash == tt.wantRevokedHash) {
+					t.Errorf("refresh token %s revoked = %v", token.Username, token.Revoked)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	retired := newTestKeySet()
+	retiredKey, _ := retired.SigningKey()
+	activeKey, _ := testKeys.SigningKey()
+	rotating, err := signing.NewStaticKeySet(activeKey.Signer, retiredKey.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		keys       signing.KeySet
+		wantStatus int
+		wantKids   []string
+	}{
+		{name: "single key", keys: testKeys, wantStatus: http.StatusOK, wantKids: []string{activeKey.Id}},
+		{name: "rotation publishes every key", keys: rotating, wantStatus: http.StatusOK, wantKids: []string{activeKey.Id, retiredKey.Id}},
+		{name: "no keys", keys: signing.StaticKeySet{}, wantStatus: http.StatusOK},
+		{name: "keys unavailable", keys: failingKeySet{}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _ := NewApiHandler(newFakeUserStore(), newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, tt.keys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).JWKS(events.APIGatewayProxyRequest{})
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if response.Headers["Content-Type"] != "application/json" {
+				t.Errorf("Content-Type = %q", response.Headers["Content-Type"])
+			}
+
+			var body signing.JSONWebKeySet
+			decodeBody(t, response, &body)
+			got := map[string]bool{}
+			for _, key := range body.Keys {
+				if key.Kty != "RSA" || key.Alg != "RS256" || key.Use != "sig" || key.N == "" || key.E == "" {
+					t.Errorf("key = %+v", key)
+				}
+				got[key.Kid] = true
+			}
+			if len(got) != len(tt.wantKids) {
+				t.Errorf("kids = %v, want %v", got, tt.wantKids)
+			}
+			for _, kid := range tt.wantKids {
+				if !got[kid] {
+					t.Errorf("kid %s missing from %v", kid, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", context: userContext, wantStatus: http.StatusOK},
+		{name: "missing user context", context: auth.UserContext{}, wantStatus: http.StatusUnauthorized},
+		{name: "store fails", context: userContext, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+		{name: "user removed", context: userContext, storeErr: database.ErrUserNotFound, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(types.User{Username: "alice", Role: auth.RoleUser})
+			store.errs["GetUser"] = tt.storeErr
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).GetUser(events.APIGatewayProxyRequest{}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.UserResponse
+				decodeBody(t, response, &body)
+				if body.Username != "alice" || body.Role != auth.RoleUser {
+					t.Errorf("body = %+v", body)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		body       string
+		storeErrs  map[string]error
+		wantStatus int
+		wantRole   string
+	}{
+		{name: "success", context: adminContext, body: `{"username":"alice","newrole":"admin"}`, wantStatus: http.StatusOK, wantRole: auth.RoleAdmin},
+		{name: "malformed json", context: adminContext, body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "built-in role", context: adminContext, body: `{"username":"alice","newrole":"product_manager"}`, wantStatus: http.StatusOK, wantRole: auth.RoleProductManager},
+		{name: "invalid role", context: adminContext, body: `{"username":"alice","newrole":"owner"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown user", context: adminContext, body: `{"username":"mallory","newrole":"admin"}`, wantStatus: http.StatusNotFound},
+		{name: "get fails", context: adminContext, body: `{"username":"alice","newrole":"admin"}`, storeErrs: map[string]error{"GetUser": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "removed meanwhile", context: adminContext, body: `{"username":"alice","newrole":"admin"}`, storeErrs: map[string]error{"UpdateUser": database.ErrUserNotFound}, wantStatus: http.StatusNotFound},
+		{name: "update fails", context: adminContext, body: `{"username":"alice","newrole":"admin"}`, storeErrs: map[string]error{"UpdateUser": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "writer grants admin", context: supportContext, body: `{"username":"alice","newrole":"admin"}`, wantStatus: http.StatusForbidden},
+		{name: "writer makes itself admin", context: supportContext, body: `{"username":"sam","newrole":"admin"}`, wantStatus: http.StatusForbidden},
+		{name: "writer grants permissions it lacks", context: supportContext, body: `{"username":"alice","newrole":"product_manager"}`, wantStatus: http.StatusForbidden},
+		{name: "writer demotes an admin", context: supportContext, body: `{"username":"root","newrole":"user"}`, wantStatus: http.StatusForbidden},
+		{name: "writer grants its own role", context: supportContext, body: `{"username":"alice","newrole":"support"}`, wantStatus: http.StatusOK, wantRole: auth.RoleSupport},
+	}
+
+	// support configured with users:write, as in the example of auth.FileRoleSource
+	roles := auth.NewRoles(staticRoleSource{auth.RoleSupport: {auth.PermissionUsersRead, auth.PermissionUsersWrite}}, time.Minute)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(types.User{Username: "alice", Role: auth.RoleUser}, types.User{Username: "sam", Role: auth.RoleSupport}, types.User{Username: "root", Role: auth.RoleAdmin})
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+			revocations := newFakeRevocationStore()
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), revocations, &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, roles, testNotifier, false).UpdateRole(events.APIGatewayProxyRequest{Body: tt.body}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.UserResponse
+				decodeBody(t, response, &body)
+				if body.Username != "alice" || body.Role != tt.wantRole {
+					t.Errorf("body = %+v", body)
+				}
+				if store.users["alice"].Role != tt.wantRole {
+					t.Errorf("stored role = %q, want %q", store.users["alice"].Role, tt.wantRole)
+				}
+				if !revocations.users["alice"] {
+					t.Error("existing tokens were not revoked after the role change")
+				}
+			}
+			if tt.wantStatus == http.StatusUnprocessableEntity {
+				var body errorBody
+				decodeBody(t, response, &body)
+				if body.Code != "validation_failed" || body.Details["newrole"] == "" {
+					t.Errorf("error body = %+v, want details for newrole", body)
+				}
+			}
+			if tt.wantStatus == http.StatusForbidden {
+				if store.users["alice"].Role != auth.RoleUser || store.users["sam"].Role != auth.RoleSupport || store.users["root"].Role != auth.RoleAdmin {
+					t.Errorf("roles changed on a refused request: %+v", store.users)
+				}
+				if len(revocations.users) != 0 {
+					t.Error("tokens revoked on a refused request")
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		storeErrs  map[string]error
+		wantStatus int
+	}{
+		{name: "success", context: adminContext, wantStatus: http.StatusOK},
+		{name: "get fails", context: adminContext, storeErrs: map[string]error{"GetUser": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "unknown user", context: adminContext, storeErrs: map[string]error{"GetUser": database.ErrUserNotFound}, wantStatus: http.StatusNotFound},
+		{name: "delete fails", context: adminContext, storeErrs: map[string]error{"DeleteUser": errStore}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(types.User{Username: "alice", Role: auth.RoleUser})
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+			revocations := newFakeRevocationStore()
+			request := events.APIGatewayProxyRequest{
+				QueryStringParameters: map[string]string{"username": "alice"},
+			}
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), revocations, &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).RemoveUser(request, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			_, stillThere := store.users["alice"]
+			if tt.wantStatus == http.StatusOK && stillThere {
+				t.Error("user was not deleted")
+			}
+			if tt.wantStatus == http.StatusOK && !revocations.users["alice"] {
+				t.Error("existing tokens were not revoked after removal")
+			}
+			if tt.wantStatus != http.StatusOK && !stillThere {
+				t.Error("user was deleted without admin rights")
+			}
+		})
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	tests := []struct {
+		name           string
+		context        auth.UserContext
+		query          map[string]string
+		storeErr       error
+		wantStatus     int
+		wantUsernames  []string
+		wantNextCursor string
+	}{
+		{name: "success", context: adminContext, wantStatus: http.StatusOK, wantUsernames: []string{"alice", "bob", "carol"}},
+		{name: "first page", context: adminContext, query: map[string]string{"limit": "2"}, wantStatus: http.StatusOK, wantUsernames: []string{"alice", "bob"}, wantNextCursor: "bob"},
+		{name: "last page", context: adminContext, query: map[string]string{"limit": "2", "cursor": "bob"}, wantStatus: http.StatusOK, wantUsernames: []string{"carol"}},
+		{name: "limit not a number", context: adminContext, query: map[string]string{"limit": "ten"}, wantStatus: http.StatusBadRequest},
+		{name: "limit too large", context: adminContext, query: map[string]string{"limit": "1000"}, wantStatus: http.StatusBadRequest},
+		{name: "limit zero", context: adminContext, query: map[string]string{"limit": "0"}, wantStatus: http.StatusBadRequest},
+		{name: "invalid cursor", context: adminContext, query: map[string]string{"cursor": "zed"}, wantStatus: http.StatusBadRequest},
+		{name: "store fails", context: adminContext, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(
+				types.User{Username: "alice", Role: auth.RoleUser, PasswordHash: "hash"},
+				types.User{Username: "bob", Role: auth.RoleUser, PasswordHash: "hash"},
+				types.User{Username: "carol", Role: auth.RoleAdmin, PasswordHash: "hash"},
+			)
+			store.errs["ListUsers"] = tt.storeErr
+			request := events.APIGatewayProxyRequest{QueryStringParameters: tt.query}
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).ListUsers(request, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body struct {
+					Items      []map[string]interface{} `json:"items"`
+					NextCursor string                   `json:"nextCursor"`
+				}
+				decodeBody(t, response, &body)
+
+				var usernames []string
+				for _, item := range body.Items {
+					usernames = append(usernames, item["username"].(string))
+					if _, leaked := item["password"]; leaked {
+						t.Error("password hash leaked into list response")
+					}
+				}
+				if strings.Join(usernames, ",") != strings.Join(tt.wantUsernames, ",") {
+					t.Errorf("usernames = %v, want %v", usernames, tt.wantUsernames)
+				}
+				if body.NextCursor != tt.wantNextCursor {
+					t.Errorf("nextCursor = %q, want %q", body.NextCursor, tt.wantNextCursor)
+				}
+			}
+		})
+	}
+}
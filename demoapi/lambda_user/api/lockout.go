@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/types"
+	"log"
+	"net/http"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/validate"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// loginFailed counts a failed login against every key and locks the keys that reached
+// their limit. The attempt that causes a lock is already answered with a 429.
+func (api ApiHandler) loginFailed(request events.APIGatewayProxyRequest, attemptKeys []string) (events.APIGatewayProxyResponse, error) {
+	retryAfter, err := api.recordFailures(attemptKeys)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if retryAfter > 0 {
+		return response.Error(request, apierror.TooManyRequests("Too many failed login attempts", retryAfter))
+	}
+
+	return response.Error(request, apierror.Unauthorized("Invalid login credentials"))
+}
+
+// recordFailures counts a wrong password against every key, locks the keys that reached
+// their limit and returns how long the longest of those new locks lasts.
+func (api ApiHandler) recordFailures(attemptKeys []string) (time.Duration, error) {
+	now := time.Now()
+	var retryAfter time.Duration
+
+	for _, key := range attemptKeys {
+		attempts, err := api.attempts.RecordLoginFailure(key, api.lockouts.Window)
+		if err != nil {
+			return 0, fmt.Errorf("error recording login failure %w", err)
+		}
+
+		locked, ok := api.lockouts.Lock(attempts, now)
+		if !ok {
+			continue
+		}
+
+		err = api.attempts.LockLogin(locked)
+		if err != nil {
+			return 0, fmt.Errorf("error locking login %w", err)
+		}
+
+		lockedUntil := time.Unix(locked.LockedUntil, 0).UTC().Format(time.RFC3339)
+		err = api.msgQueue.SendMessage(fmt.Sprintf("Login locked for %s until %s", lockout.Describe(key), lockedUntil))
+		if err != nil {
+			return 0, fmt.Errorf("error sending login lock message %w", err)
+		}
+
+		retryAfter = max(retryAfter, locked.LockedFor(now))
+	}
+
+	return retryAfter, nil
+}
+
+// clearLoginFailures drops the failure count of username after a complete login or a password
+// change. Only the username count goes, one good account must not unlock an address that is guessing.
+func (api ApiHandler) clearLoginFailures(attempts []types.LoginAttempts, username string) {
+	for _, attempt := range attempts {
+		if attempt.Key != lockout.UserKey(username) {
+			continue
+		}
+		err := api.attempts.ClearLoginAttempts(attempt.Key)
+		if err != nil {
+			log.Printf("clearing login attempts of %s failed: %v", username, err)
+		}
+	}
+}
+
+// UnlockUser lets a user manager lift the lock and the failure count of a username.
+// Locks on source addresses are left to run out.
+func (api ApiHandler) UnlockUser(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+
+	type UnlockRequest struct {
+		Username string `json:"username" validate:"required"`
+	}
+
+	var unlockRequest UnlockRequest
+
+	err := validate.Decode(request.Body, &unlockRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	user, err := api.dbStore.GetUser(unlockRequest.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	err = api.attempts.ClearLoginAttempts(lockout.UserKey(user.Username))
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error clearing login attempts %w", err))
+	}
+
+	err = api.msgQueue.SendMessage(fmt.Sprintf("Login unlocked for %s by %s", lockout.Describe(lockout.UserKey(user.Username)), userContext.Username))
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error sending login unlock message %w", err))
+	}
+
+	return response.Text(http.StatusOK, "Account unlocked"), nil
+}
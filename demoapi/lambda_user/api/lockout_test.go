@@ -0,0 +1,188 @@
+package api
+
+import (
+	"lambda-func/lockout"
+	"lambda-func/types"
+	"net/http"
+	"shared/auth"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func loginRequest(body string, sourceIP string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		Body: body,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Identity: events.APIGatewayRequestIdentity{SourceIP: sourceIP},
+		},
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	attempts := newFakeLoginAttemptStore()
+	queue := &fakeQueue{}
+	handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+	wrongPassword := loginRequest(`{"username":"alice","password":"nope"}`, "192.0.2.1")
+
+	for i, wantStatus := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		response, _ := handler.LoginUser(wrongPassword)
+		if response.StatusCode != wantStatus {
+			t.Fatalf("attempt %d status = %d, want %d (body %q)", i+1, response.StatusCode, wantStatus, response.Body)
+		}
+		if wantStatus == http.StatusTooManyRequests && response.Headers["Retry-After"] != "60" {
+			t.Errorf("Retry-After = %q, want %q", response.Headers["Retry-After"], "60")
+		}
+	}
+
+	if len(queue.messages) != 1 || !strings.HasPrefix(queue.messages[0], "Login locked for user alice until ") {
+		t.Errorf("queue messages = %v", queue.messages)
+	}
+	if locked := attempts.attempts[lockout.UserKey("alice")]; locked.Lockouts != 1 || locked.Failures != 0 {
+		t.Errorf("stored attempts = %+v, want one lockout", locked)
+	}
+
+	// the right password does not get through a lock either
+	response, _ := handler.LoginUser(loginRequest(`{"username":"alice","password":"secret"}`, "198.51.100.7"))
+	if response.StatusCode != http.StatusTooManyRequests || response.Headers["Retry-After"] == "" {
+		t.Errorf("login while locked = %d %v, want %d with Retry-After", response.StatusCode, response.Headers, http.StatusTooManyRequests)
+	}
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	attempts := newFakeLoginAttemptStore()
+	queue := &fakeQueue{}
+	handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+	wrongPassword := events.APIGatewayProxyRequest{Body: `{"old_password":"nope","new_password":"correct horse"}`}
+
+	for i, wantStatus := range []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, http.StatusTooManyRequests} {
+		response, _ := handler.ChangePassword(wrongPassword, userContext)
+		if response.StatusCode != wantStatus {
+			t.Fatalf("attempt %d status = %d, want %d (body %q)", i+1, response.StatusCode, wantStatus, response.Body)
+		}
+		if wantStatus == http.StatusTooManyRequests && response.Headers["Retry-After"] != "60" {
+			t.Errorf("Retry-After = %q, want %q", response.Headers["Retry-After"], "60")
+		}
+	}
+
+	if len(queue.messages) != 1 || !strings.HasPrefix(queue.messages[0], "Login locked for user alice until ") {
+		t.Errorf("queue messages = %v", queue.messages)
+	}
+
+	// the lock covers the right old password and logins alike
+	response, _ := handler.ChangePassword(events.APIGatewayProxyRequest{Body: `{"old_password":"secret","new_password":"correct horse"}`}, userContext)
+	if response.StatusCode != http.StatusTooManyRequests || response.Headers["Retry-After"] == "" {
+		t.Errorf("change while locked = %d %v, want %d with Retry-After", response.StatusCode, response.Headers, http.StatusTooManyRequests)
+	}
+	response, _ = handler.LoginUser(loginRequest(`{"username":"alice","password":"secret"}`, "198.51.100.7"))
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("login while locked = %d, want %d", response.StatusCode, http.StatusTooManyRequests)
+	}
+}
+
+func TestChangePasswordClearsFailures(t *testing.T) {
+	attempts := newFakeLoginAttemptStore(types.LoginAttempts{Key: lockout.UserKey("alice"), Failures: 2})
+	handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	response, _ := handler.ChangePassword(events.APIGatewayProxyRequest{Body: `{"old_password":"secret","new_password":"correct horse"}`}, userContext)
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, http.StatusOK, response.Body)
+	}
+	if _, ok := attempts.attempts[lockout.UserKey("alice")]; ok {
+		t.Error("failures of the user were kept after a password change")
+	}
+}
+
+func TestLoginLockedAddress(t *testing.T) {
+	locked := types.LoginAttempts{Key: lockout.IPKey("192.0.2.1"), Lockouts: 1, LockedUntil: time.Now().Add(time.Minute).Unix()}
+	handler := NewApiHandler(newFakeUserStore(newTestUser(t, "bob", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(locked), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	response, _ := handler.LoginUser(loginRequest(`{"username":"bob","password":"secret"}`, "192.0.2.1"))
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d from a locked address, want %d", response.StatusCode, http.StatusTooManyRequests)
+	}
+
+	response, _ = handler.LoginUser(loginRequest(`{"username":"bob","password":"secret"}`, "198.51.100.7"))
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d from another address, want %d", response.StatusCode, http.StatusOK)
+	}
+}
+
+func TestLoginClearsUserFailures(t *testing.T) {
+	attempts := newFakeLoginAttemptStore(
+		types.LoginAttempts{Key: lockout.UserKey("alice"), Failures: 2},
+		types.LoginAttempts{Key: lockout.IPKey("192.0.2.1"), Failures: 2},
+	)
+	handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	response, _ := handler.LoginUser(loginRequest(`{"username":"alice","password":"secret"}`, "192.0.2.1"))
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, http.StatusOK, response.Body)
+	}
+	if _, ok := attempts.attempts[lockout.UserKey("alice")]; ok {
+		t.Error("failures of the user were kept after a successful login")
+	}
+	if _, ok := attempts.attempts[lockout.IPKey("192.0.2.1")]; !ok {
+		t.Error("failures of the address were cleared by a successful login")
+	}
+}
+
+func TestLoginAttemptStoreFails(t *testing.T) {
+	for _, method := range []string{"GetLoginAttempts", "RecordLoginFailure", "LockLogin"} {
+		t.Run(method, func(t *testing.T) {
+			attempts := newFakeLoginAttemptStore(types.LoginAttempts{Key: lockout.UserKey("alice"), Failures: 2})
+			attempts.errs[method] = errStore
+			handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+			response, _ := handler.LoginUser(loginRequest(`{"username":"alice","password":"nope"}`, "192.0.2.1"))
+
+			if response.StatusCode != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", response.StatusCode, http.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		body       string
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", context: adminContext, body: `{"username":"alice"}`, wantStatus: http.StatusOK},
+		{name: "unknown user", context: adminContext, body: `{"username":"mallory"}`, wantStatus: http.StatusNotFound},
+		{name: "missing username", context: adminContext, body: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "store fails", context: adminContext, body: `{"username":"alice"}`, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := newFakeLoginAttemptStore(types.LoginAttempts{Key: lockout.UserKey("alice"), Lockouts: 1, LockedUntil: time.Now().Add(time.Minute).Unix()})
+			attempts.errs["ClearLoginAttempts"] = tt.storeErr
+			queue := &fakeQueue{}
+
+			response, _ := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).UnlockUser(events.APIGatewayProxyRequest{Body: tt.body}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			_, stillLocked := attempts.attempts[lockout.UserKey("alice")]
+			if tt.wantStatus == http.StatusOK {
+				if stillLocked {
+					t.Error("user is still locked")
+				}
+				if len(queue.messages) != 1 || queue.messages[0] != "Login unlocked for user alice by root" {
+					t.Errorf("queue messages = %v", queue.messages)
+				}
+			} else if !stillLocked {
+				t.Error("user was unlocked on a rejected request")
+			}
+		})
+	}
+}
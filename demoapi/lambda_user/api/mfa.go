@@ -0,0 +1,260 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/mfa"
+	"lambda-func/types"
+	"net/http"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/router"
+	"shared/validate"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RequireMfa returns a route middleware that, when the policy asks for it, refuses sessions
+// without a second factor on a route that needs permission. It looks at the permissions of
+// the role rather than its name, so a defined role that can manage users needs MFA as well
+// as admin. It goes after Authenticate.
+func (api ApiHandler) RequireMfa(permission string) router.Middleware {
+	return func(next router.Handler) router.Handler {
+		return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			userContext := auth.UserContextFrom(request)
+			if !api.mfa.RequireForAdmins || userContext.Mfa {
+				return next(request)
+			}
+
+			granted, _, err := api.roles.Permissions(userContext.Role)
+			if err != nil {
+				return response.Error(request, fmt.Errorf("error loading roles %w", err))
+			}
+			if slices.Contains(granted, permission) {
+				return response.Error(request, apierror.Forbidden("MFA required for user management"))
+			}
+
+			return next(request)
+		}
+	}
+}
+
+// mfaChallenge answers a correct password of an account with MFA. The challenge token
+// has its own audience, so no API accepts it in place of an access token.
+func (api ApiHandler) mfaChallenge(request events.APIGatewayProxyRequest, user types.User) (events.APIGatewayProxyResponse, error) {
+	signingKey, err := api.keys.SigningKey()
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	challenge, expiresAt, err := types.CreateMfaChallenge(user, signingKey)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error signing mfa challenge %w", err))
+	}
+
+	return response.JSON(http.StatusOK, types.MfaChallengeResponse{
+		MfaRequired: true,
+		MfaToken:    challenge,
+		ExpiresAt:   expiresAt.Unix(),
+	})
+}
+
+// SetupMfa starts an enrollment with a new secret. It only protects logins once VerifyMfa confirms it.
+func (api ApiHandler) SetupMfa(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+
+	if userContext.Username == "" {
+		return response.Error(request, apierror.Unauthorized("User Unauthorized").Wrap(auth.ErrMissingUserContext))
+	}
+
+	user, err := api.dbStore.GetUser(userContext.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if user.MfaEnabled {
+		return response.Error(request, apierror.Conflict("MFA is already enabled"))
+	}
+
+	secret, err := api.mfa.NewSecret()
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error generating mfa secret %w", err))
+	}
+
+	err = api.dbStore.SetMfaSecret(user.Username, secret)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error storing mfa secret %w", err))
+	}
+
+	return response.JSON(http.StatusOK, types.MfaSetupResponse{
+		Secret:     secret,
+		OtpauthUri: api.mfa.URI(user.Username, secret),
+	})
+}
+
+// VerifyMfa confirms an enrollment with a first code and hands out the recovery codes, once.
+func (api ApiHandler) VerifyMfa(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+	type VerifyMfaRequest struct {
+		Code string `json:"code" validate:"required,max=6"`
+	}
+
+	var verifyRequest VerifyMfaRequest
+
+	err := validate.Decode(request.Body, &verifyRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if userContext.Username == "" {
+		return response.Error(request, apierror.Unauthorized("User Unauthorized").Wrap(auth.ErrMissingUserContext))
+	}
+
+	user, err := api.dbStore.GetUser(userContext.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if user.MfaEnabled {
+		return response.Error(request, apierror.Conflict("MFA is already enabled"))
+	}
+	if user.MfaSecret == "" {
+		return response.Error(request, apierror.Conflict("MFA setup has not been started"))
+	}
+
+	step, ok := api.mfa.Verify(user.MfaSecret, verifyRequest.Code, time.Now())
+	if !ok {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"code": "is not valid",
+		}))
+	}
+
+	recoveryCodes, recoveryCodeHashes, err := api.mfa.NewRecoveryCodes()
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error generating recovery codes %w", err))
+	}
+
+	err = api.dbStore.EnableMfa(user.Username, user.MfaSecret, recoveryCodeHashes, step)
+	if errors.Is(err, database.ErrMfaSetupChanged) {
+		return response.Error(request, apierror.Conflict("MFA setup changed, start it again"))
+	}
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error enabling mfa %w", err))
+	}
+
+	err = api.msgQueue.SendMessage("MFA enabled for " + user.Username)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error sending mfa message %w", err))
+	}
+
+	return response.JSON(http.StatusOK, types.MfaRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// LoginMfa exchanges a challenge token from /login and a code, or a recovery code, for tokens.
+// Wrong codes count as failed logins, so the lockout also limits guessing codes.
+func (api ApiHandler) LoginMfa(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	type MfaLoginRequest struct {
+		MfaToken     string `json:"mfa_token" validate:"required"`
+		Code         string `json:"code" validate:"max=6"`
+		RecoveryCode string `json:"recovery_code" validate:"max=32"`
+	}
+
+	var mfaRequest MfaLoginRequest
+
+	err := validate.Decode(request.Body, &mfaRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if (mfaRequest.Code == "") == (mfaRequest.RecoveryCode == "") {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"code": "either code or recovery_code is required",
+		}))
+	}
+
+	claims, err := auth.ParseToken(mfaRequest.MfaToken, api.keys, auth.MfaChallengeAudience)
+	if errors.Is(err, auth.ErrKeySetUnavailable) {
+		return response.Error(request, err)
+	}
+	if err != nil {
+		return response.Error(request, apierror.Unauthorized("Invalid MFA token"))
+	}
+
+	// a password change since the challenge was issued ends it like any other session
+	revoked, err := api.revocations.IsRevoked(claims.SessionId, claims.Subject, claims.IssuedAt.UnixMilli())
+	if err != nil {
+		return response.Error(request, err)
+	}
+	if revoked {
+		return response.Error(request, apierror.Unauthorized("Invalid MFA token"))
+	}
+
+	attemptKeys := lockout.Keys(claims.Subject, request.RequestContext.Identity.SourceIP)
+	attempts, err := api.attempts.GetLoginAttempts(attemptKeys)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error checking login attempts %w", err))
+	}
+
+	if retryAfter := lockout.RetryAfter(attempts, time.Now()); retryAfter > 0 {
+		return response.Error(request, apierror.TooManyRequests("Too many failed login attempts", retryAfter))
+	}
+
+	user, err := api.dbStore.GetUser(claims.Subject)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid MFA token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if !user.MfaEnabled {
+		return response.Error(request, apierror.Unauthorized("Invalid MFA token"))
+	}
+
+	if mfaRequest.Code != "" {
+		step, ok := api.mfa.Verify(user.MfaSecret, mfaRequest.Code, time.Now())
+		if !ok {
+			return api.loginFailed(request, attemptKeys)
+		}
+
+		// a code seen once, on this or any other challenge, is not accepted again
+		err = api.dbStore.UseMfaStep(user.Username, step)
+		if errors.Is(err, database.ErrMfaCodeUsed) {
+			return api.loginFailed(request, attemptKeys)
+		}
+		if err != nil {
+			return response.Error(request, fmt.Errorf("error recording mfa code %w", err))
+		}
+	} else {
+		err = api.dbStore.UseRecoveryCode(user.Username, mfa.HashRecoveryCode(mfaRequest.RecoveryCode))
+		if errors.Is(err, database.ErrRecoveryCodeNotFound) {
+			return api.loginFailed(request, attemptKeys)
+		}
+		if err != nil {
+			return response.Error(request, fmt.Errorf("error using recovery code %w", err))
+		}
+
+		err = api.msgQueue.SendMessage("Recovery code used for " + user.Username)
+		if err != nil {
+			return response.Error(request, fmt.Errorf("error sending recovery code message %w", err))
+		}
+	}
+
+	api.clearLoginFailures(attempts, user.Username)
+
+	return api.issueTokens(request, user, "", true)
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"lambda-func/database"
+	"lambda-func/mfa"
+	"lambda-func/types"
+	"net/http"
+	"net/url"
+	"shared/auth"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// newMfaUser returns alice with MFA enabled and a single recovery code, "abcde-fghij".
+func newMfaUser(t *testing.T) (types.User, string) {
+	t.Helper()
+	secret, err := testMfa.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := newTestUser(t, "alice", "secret", auth.RoleUser)
+	user.MfaSecret = secret
+	user.MfaEnabled = true
+	user.RecoveryCodes = []string{mfa.HashRecoveryCode("abcde-fghij")}
+	return user, secret
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := mfa.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestSetupMfa(t *testing.T) {
+	enrolled, _ := newMfaUser(t)
+
+	tests := []struct {
+		name       string
+		user       types.User
+		context    auth.UserContext
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", user: newTestUser(t, "alice", "secret", auth.RoleUser), context: userContext, wantStatus: http.StatusOK},
+		{name: "already enabled", user: enrolled, context: userContext, wantStatus: http.StatusConflict},
+		{name: "missing user context", user: enrolled, context: auth.UserContext{}, wantStatus: http.StatusUnauthorized},
+		{name: "user removed", user: newTestUser(t, "bob", "secret", auth.RoleUser), context: userContext, wantStatus: http.StatusNotFound},
+		{name: "store fails", user: newTestUser(t, "alice", "secret", auth.RoleUser), context: userContext, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(tt.user)
+			store.errs["SetMfaSecret"] = tt.storeErr
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).SetupMfa(events.APIGatewayProxyRequest{}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body types.MfaSetupResponse
+			decodeBody(t, response, &body)
+			stored := store.users["alice"]
+			if body.Secret == "" || stored.MfaSecret != body.Secret || stored.MfaEnabled {
+				t.Errorf("body = %+v, stored %+v, want a pending secret", body, stored)
+			}
+			uri, err := url.Parse(body.OtpauthUri)
+			if err != nil || uri.Query().Get("secret") != body.Secret {
+				t.Errorf("otpauth_uri = %q", body.OtpauthUri)
+			}
+		})
+	}
+}
+
+func TestVerifyMfa(t *testing.T) {
+	enrolled, enrolledSecret := newMfaUser(t)
+	pending := newTestUser(t, "alice", "secret", auth.RoleUser)
+	pending.MfaSecret = enrolledSecret
+
+	tests := []struct {
+		name       string
+		user       types.User
+		body       string
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", user: pending, body: `{"code":"` + currentCode(t, enrolledSecret) + `"}`, wantStatus: http.StatusOK},
+		{name: "wrong code", user: pending, body: `{"code":"000000"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "missing code", user: pending, body: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "setup not started", user: newTestUser(t, "alice", "secret", auth.RoleUser), body: `{"code":"123456"}`, wantStatus: http.StatusConflict},
+		{name: "already enabled", user: enrolled, body: `{"code":"` + currentCode(t, enrolledSecret) + `"}`, wantStatus: http.StatusConflict},
+		{name: "setup replaced meanwhile", user: pending, body: `{"code":"` + currentCode(t, enrolledSecret) + `"}`, storeErr: database.ErrMfaSetupChanged, wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(tt.user)
+			store.errs["EnableMfa"] = tt.storeErr
+			queue := &fakeQueue{}
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).VerifyMfa(events.APIGatewayProxyRequest{Body: tt.body}, userContext)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !tt.user.MfaEnabled && store.users["alice"].MfaEnabled {
+					t.Error("MFA was enabled on a rejected request")
+				}
+				return
+			}
+
+			var body types.MfaRecoveryCodesResponse
+			decodeBody(t, response, &body)
+			stored := store.users["alice"]
+			if !stored.MfaEnabled || stored.MfaLastStep == 0 || len(body.RecoveryCodes) != len(stored.RecoveryCodes) || len(body.RecoveryCodes) == 0 {
+				t.Fatalf("body = %+v, stored %+v", body, stored)
+			}
+			if stored.RecoveryCodes[0] != mfa.HashRecoveryCode(body.RecoveryCodes[0]) {
+				t.Error("recovery codes are not stored hashed")
+			}
+			if len(queue.messages) != 1 || queue.messages[0] != "MFA enabled for alice" {
+				t.Errorf("queue messages = %v", queue.messages)
+			}
+		})
+	}
+}
+
+func TestLoginWithMfa(t *testing.T) {
+	user, secret := newMfaUser(t)
+	store := newFakeUserStore(user)
+	tokens := newFakeRefreshTokenStore()
+	queue := &fakeQueue{}
+	handler := NewApiHandler(store, tokens, newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	login := func() string {
+		issuedBefore := len(tokens.tokens)
+		response, _ := handler.LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"alice","password":"secret"}`})
+		var challenge types.MfaChallengeResponse
+		decodeBody(t, response, &challenge)
+		if response.StatusCode != http.StatusOK || !challenge.MfaRequired || challenge.MfaToken == "" {
+			t.Fatalf("login = %d %q, want an MFA challenge", response.StatusCode, response.Body)
+		}
+		if len(tokens.tokens) != issuedBefore {
+			t.Fatal("a refresh token was issued before the second factor")
+		}
+		return challenge.MfaToken
+	}
+
+	challenge := login()
+	code := currentCode(t, secret)
+
+	response, _ := handler.LoginMfa(events.APIGatewayProxyRequest{Body: `{"mfa_token":"` + challenge + `","code":"` + code + `"}`})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("code = %d %q, want %d", response.StatusCode, response.Body, http.StatusOK)
+	}
+	var issued types.TokenResponse
+	decodeBody(t, response, &issued)
+	if claims := parseClaims(t, issued.AccessToken); !slices.Contains(claims.AuthMethods, auth.AuthMethodOTP) {
+		t.Errorf("amr = %v, want %s", claims.AuthMethods, auth.AuthMethodOTP)
+	}
+
+	// refreshed sessions keep their second factor
+	response, _ = handler.RefreshToken(events.APIGatewayProxyRequest{Body: `{"refresh_token":"` + issued.RefreshToken + `"}`})
+	var refreshed types.TokenResponse
+	decodeBody(t, response, &refreshed)
+	if claims := parseClaims(t, refreshed.AccessToken); !slices.Contains(claims.AuthMethods, auth.AuthMethodOTP) {
+		t.Errorf("amr after refresh = %v, want %s", claims.AuthMethods, auth.AuthMethodOTP)
+	}
+
+	// the same code does not work twice, not even on a new challenge
+	response, _ = handler.LoginMfa(events.APIGatewayProxyRequest{Body: `{"mfa_token":"` + login() + `","code":"` + code + `"}`})
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("reused code = %d, want %d", response.StatusCode, http.StatusUnauthorized)
+	}
+
+	for _, attempt := range []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "recovery code", body: `{"mfa_token":"` + login() + `","recovery_code":"ABCDE FGHIJ"}`, wantStatus: http.StatusOK},
+		{name: "recovery code reused", body: `{"mfa_token":"` + login() + `","recovery_code":"abcde-fghij"}`, wantStatus: http.StatusUnauthorized},
+		{name: "code and recovery code", body: `{"mfa_token":"` + challenge + `","code":"123456","recovery_code":"abcde-fghij"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "neither", body: `{"mfa_token":"` + challenge + `"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "access token as challenge", body: `{"mfa_token":"` + issued.AccessToken + `","code":"123456"}`, wantStatus: http.StatusUnauthorized},
+		{name: "garbage challenge", body: `{"mfa_token":"not.a.token","code":"123456"}`, wantStatus: http.StatusUnauthorized},
+	} {
+		response, _ := handler.LoginMfa(events.APIGatewayProxyRequest{Body: attempt.body})
+		if response.StatusCode != attempt.wantStatus {
+			t.Errorf("%s = %d %q, want %d", attempt.name, response.StatusCode, response.Body, attempt.wantStatus)
+		}
+	}
+
+	if len(store.users["alice"].RecoveryCodes) != 0 {
+		t.Error("the used recovery code was kept")
+	}
+	if !slices.Contains(queue.messages, "Recovery code used for alice") {
+		t.Errorf("queue messages = %v", queue.messages)
+	}
+}
+
+func TestLoginMfaLockout(t *testing.T) {
+	user, _ := newMfaUser(t)
+	handler := NewApiHandler(newFakeUserStore(user), newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	response, _ := handler.LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"alice","password":"secret"}`})
+	var challenge types.MfaChallengeResponse
+	decodeBody(t, response, &challenge)
+
+	for i, wantStatus := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		response, _ := handler.LoginMfa(events.APIGatewayProxyRequest{Body: `{"mfa_token":"` + challenge.MfaToken + `","code":"000000"}`})
+		if response.StatusCode != wantStatus {
+			t.Fatalf("guess %d = %d, want %d", i+1, response.StatusCode, wantStatus)
+		}
+	}
+}
+
+func TestRequireMfa(t *testing.T) {
+	strict := mfa.NewPolicy("JITestDemo", true)
+
+	tests := []struct {
+		name       string
+		policy     *mfa.Policy
+		context    auth.UserContext
+		wantStatus int
+	}{
+		{name: "password only", policy: strict, context: adminContext, wantStatus: http.StatusForbidden},
+		{name: "with second factor", policy: strict, context: auth.UserContext{Username: "root", Role: auth.RoleAdmin, Mfa: true}, wantStatus: http.StatusOK},
+		{name: "other role with the permission", policy: strict, context: supportContext, wantStatus: http.StatusForbidden},
+		{name: "other role with second factor", policy: strict, context: auth.UserContext{Username: "sam", Role: auth.RoleSupport, Mfa: true}, wantStatus: http.StatusOK},
+		{name: "without the permission", policy: strict, context: userContext, wantStatus: http.StatusOK},
+		{name: "not required", policy: testMfa, context: adminContext, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, tt.policy, testRoles, testNotifier, false)
+
+			response, _ := handler.RequireMfa(auth.PermissionUsersRead)(auth.WithUser(handler.ListUsers))(auth.WithUserContext(events.APIGatewayProxyRequest{}, tt.context))
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/types"
+	"log"
+	"net/http"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/validate"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func (api ApiHandler) ChangePassword(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+	type ChangePasswordRequest struct {
+		OldPassword string `json:"old_password" validate:"required,maxbytes=72"`
+		NewPassword string `json:"new_password" validate:"required,maxbytes=72"`
+	}
+
+	var changeRequest ChangePasswordRequest
+
+	err := validate.Decode(request.Body, &changeRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	// a stolen access token must not buy unlimited guesses, so wrong old passwords
+	// count against the same per user lock as failed logins
+	attemptKeys := []string{lockout.UserKey(userContext.Username)}
+	attempts, err := api.attempts.GetLoginAttempts(attemptKeys)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error checking login attempts %w", err))
+	}
+
+	if retryAfter := lockout.RetryAfter(attempts, time.Now()); retryAfter > 0 {
+		return response.Error(request, apierror.TooManyRequests("Too many failed password attempts", retryAfter))
+	}
+
+	user, err := api.dbStore.GetUser(userContext.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if !types.ValidatePassword(user.PasswordHash, changeRequest.OldPassword) {
+		retryAfter, err := api.recordFailures(attemptKeys)
+		if err != nil {
+			return response.Error(request, err)
+		}
+		if retryAfter > 0 {
+			return response.Error(request, apierror.TooManyRequests("Too many failed password attempts", retryAfter))
+		}
+
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"old_password": "does not match the current password",
+		}))
+	}
+
+	err = api.passwords.Check(user.Username, changeRequest.NewPassword)
+	if err != nil {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"new_password": err.Error(),
+		}))
+	}
+
+	err = api.setPassword(user.Username, changeRequest.NewPassword)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	api.clearLoginFailures(attempts, user.Username)
+
+	return response.Text(http.StatusOK, "Password changed"), nil
+}
+
+// IssuePasswordReset lets a user manager hand a user a one-time token to set a new password with.
+func (api ApiHandler) IssuePasswordReset(request events.APIGatewayProxyRequest, userContext auth.UserContext) (events.APIGatewayProxyResponse, error) {
+
+	type ResetTokenRequest struct {
+		Username string `json:"username" validate:"required"`
+	}
+
+	var resetTokenRequest ResetTokenRequest
+
+	err := validate.Decode(request.Body, &resetTokenRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	user, err := api.dbStore.GetUser(resetTokenRequest.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.NotFound("User not found"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	resetToken, reset, err := types.NewPasswordReset(user.Username)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error generating reset token %w", err))
+	}
+
+	err = api.resetStore.InsertPasswordReset(reset)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error storing reset token %w", err))
+	}
+
+	return response.JSON(http.StatusOK, types.PasswordResetResponse{
+		ResetToken: resetToken,
+		ExpiresAt:  reset.ExpiresAt,
+	})
+}
+
+func (api ApiHandler) ResetPassword(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	type ResetPasswordRequest struct {
+		ResetToken  string `json:"reset_token" validate:"required"`
+		NewPassword string `json:"new_password" validate:"required,maxbytes=72"`
+	}
+
+	var resetRequest ResetPasswordRequest
+
+	err := validate.Decode(request.Body, &resetRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	tokenHash := types.HashPasswordResetToken(resetRequest.ResetToken)
+
+	reset, err := api.resetStore.GetPasswordReset(tokenHash)
+	if errors.Is(err, database.ErrPasswordResetNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid reset token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	// expired tokens linger until the table TTL removes them
+	if reset.IsExpired() {
+		return response.Error(request, apierror.Unauthorized("Invalid reset token"))
+	}
+
+	// checked before the token is used up, so a rejected password can be retried
+	err = api.passwords.Check(reset.Username, resetRequest.NewPassword)
+	if err != nil {
+		return response.Error(request, apierror.Validation("Invalid Request", map[string]string{
+			"new_password": err.Error(),
+		}))
+	}
+
+	err = api.resetStore.ConsumePasswordReset(tokenHash)
+	if errors.Is(err, database.ErrPasswordResetNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid reset token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	err = api.setPassword(reset.Username, resetRequest.NewPassword)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid reset token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	// whoever reset the password owns the account, so a lock from guessing it is lifted
+	err = api.attempts.ClearLoginAttempts(lockout.UserKey(reset.Username))
+	if err != nil {
+		log.Printf("clearing login attempts of %s failed: %v", reset.Username, err)
+	}
+
+	return response.Text(http.StatusOK, "Password changed"), nil
+}
+
+// setPassword stores a new password hash, ends every session of the user and announces the change.
+func (api ApiHandler) setPassword(username string, plainPassword string) error {
+	passwordHash, err := api.passwords.Hash(plainPassword)
+	if err != nil {
+		return fmt.Errorf("error hashing user password %w", err)
+	}
+
+	err = api.dbStore.UpdatePasswordHash(username, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	// whoever knew the old password may hold a session, so every token issued so far goes
+	err = api.revocations.RevokeUserTokens(username)
+	if err != nil {
+		return fmt.Errorf("error revoking user tokens %w", err)
+	}
+
+	err = api.msgQueue.SendMessage("Password changed for " + username)
+	if err != nil {
+		return fmt.Errorf("error sending password change message %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/types"
+	"net/http"
+	"shared/auth"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestChangePassword(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		body       string
+		storeErrs  map[string]error
+		queueErr   error
+		wantStatus int
+	}{
+		{name: "success", context: userContext, body: `{"old_password":"secret","new_password":"correct horse"}`, wantStatus: http.StatusOK},
+		{name: "malformed json", context: userContext, body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "missing new password", context: userContext, body: `{"old_password":"secret"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "wrong old password", context: userContext, body: `{"old_password":"nope","new_password":"correct horse"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "weak new password", context: userContext, body: `{"old_password":"secret","new_password":"password"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "user removed", context: auth.UserContext{Username: "bob", Role: auth.RoleUser}, body: `{"old_password":"secret","new_password":"correct horse"}`, wantStatus: http.StatusNotFound},
+		{name: "update fails", context: userContext, body: `{"old_password":"secret","new_password":"correct horse"}`, storeErrs: map[string]error{"UpdatePasswordHash": errStore}, wantStatus: http.StatusInternalServerError},
+		{name: "queue fails", context: userContext, body: `{"old_password":"secret","new_password":"correct horse"}`, queueErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser))
+			for method, err := range tt.storeErrs {
+				store.errs[method] = err
+			}
+			revocations := newFakeRevocationStore()
+			queue := &fakeQueue{err: tt.queueErr}
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), revocations, queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).ChangePassword(events.APIGatewayProxyRequest{Body: tt.body}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+
+			changed := types.ValidatePassword(store.users["alice"].PasswordHash, "correct horse")
+			if tt.wantStatus == http.StatusOK {
+				if !changed {
+					t.Error("password was not changed")
+				}
+				if !revocations.users["alice"] {
+					t.Error("existing sessions were not revoked")
+				}
+				if len(queue.messages) != 1 || queue.messages[0] != "Password changed for alice" {
+					t.Errorf("queue messages = %v", queue.messages)
+				}
+			}
+			if tt.wantStatus != http.StatusOK && tt.storeErrs == nil && tt.queueErr == nil && changed {
+				t.Error("password changed on a rejected request")
+			}
+		})
+	}
+}
+
+func TestIssuePasswordReset(t *testing.T) {
+	tests := []struct {
+		name       string
+		context    auth.UserContext
+		body       string
+		resetErr   error
+		wantStatus int
+	}{
+		{name: "success", context: adminContext, body: `{"username":"alice"}`, wantStatus: http.StatusOK},
+		{name: "unknown user", context: adminContext, body: `{"username":"mallory"}`, wantStatus: http.StatusNotFound},
+		{name: "missing username", context: adminContext, body: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "store fails", context: adminContext, body: `{"username":"alice"}`, resetErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resets := newFakePasswordResetStore()
+			resets.errs["InsertPasswordReset"] = tt.resetErr
+
+			response, _ := NewApiHandler(newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser)), newFakeRefreshTokenStore(), resets, newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).IssuePasswordReset(events.APIGatewayProxyRequest{Body: tt.body}, tt.context)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var body types.PasswordResetResponse
+				decodeBody(t, response, &body)
+				stored, ok := resets.resets[types.HashPasswordResetToken(body.ResetToken)]
+				if body.ResetToken == "" || !ok || stored.Username != "alice" {
+					t.Errorf("body = %+v, stored %+v", body, resets.resets)
+				}
+				if _, plain := resets.resets[body.ResetToken]; plain {
+					t.Error("reset token stored in plain text")
+				}
+				if stored.ExpiresAt != body.ExpiresAt || stored.ExpiresAt <= time.Now().Unix() {
+					t.Errorf("expiresAt = %d, stored %d", body.ExpiresAt, stored.ExpiresAt)
+				}
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	active := types.PasswordReset{TokenHash: types.HashPasswordResetToken("active"), Username: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	expired := types.PasswordReset{TokenHash: types.HashPasswordResetToken("expired"), Username: "alice", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+	orphaned := types.PasswordReset{TokenHash: types.HashPasswordResetToken("orphaned"), Username: "bob", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name         string
+		body         string
+		resetErrs    map[string]error
+		wantStatus   int
+		wantConsumed bool
+	}{
+		{name: "success", body: `{"reset_token":"active","new_password":"correct horse"}`, wantStatus: http.StatusOK, wantConsumed: true},
+		{name: "malformed json", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "unknown token", body: `{"reset_token":"nope","new_password":"correct horse"}`, wantStatus: http.StatusUnauthorized},
+		{name: "expired token", body: `{"reset_token":"expired","new_password":"correct horse"}`, wantStatus: http.StatusUnauthorized},
+		{name: "weak password keeps the token", body: `{"reset_token":"active","new_password":"short"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "used concurrently", body: `{"reset_token":"active","new_password":"correct horse"}`, resetErrs: map[string]error{"ConsumePasswordReset": database.ErrPasswordResetNotFound}, wantStatus: http.StatusUnauthorized},
+		{name: "user removed", body: `{"reset_token":"orphaned","new_password":"correct horse"}`, wantStatus: http.StatusUnauthorized},
+		{name: "lookup fails", body: `{"reset_token":"active","new_password":"correct horse"}`, resetErrs: map[string]error{"GetPasswordReset": errStore}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser))
+			resets := newFakePasswordResetStore(active, expired, orphaned)
+			for method, err := range tt.resetErrs {
+				resets.errs[method] = err
+			}
+			revocations := newFakeRevocationStore()
+			queue := &fakeQueue{}
+			attempts := newFakeLoginAttemptStore(types.LoginAttempts{Key: lockout.UserKey("alice"), Lockouts: 1, LockedUntil: time.Now().Add(time.Minute).Unix()})
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), resets, attempts, revocations, queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false).ResetPassword(events.APIGatewayProxyRequest{Body: tt.body})
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if _, stillThere := resets.resets[active.TokenHash]; stillThere == tt.wantConsumed && tt.resetErrs == nil {
+				t.Errorf("reset token still there = %v, want consumed %v", stillThere, tt.wantConsumed)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if !types.ValidatePassword(store.users["alice"].PasswordHash, "correct horse") {
+					t.Error("password was not changed")
+				}
+				if !revocations.users["alice"] || len(queue.messages) != 1 {
+					t.Errorf("sessions revoked = %v, queue messages = %v", revocations.users["alice"], queue.messages)
+				}
+				if _, locked := attempts.attempts[lockout.UserKey("alice")]; locked {
+					t.Error("login lock kept after the reset")
+				}
+			} else {
+				if !types.ValidatePassword(store.users["alice"].PasswordHash, "secret") {
+					t.Error("password changed on a rejected reset")
+				}
+				if _, locked := attempts.attempts[lockout.UserKey("alice")]; !locked {
+					t.Error("login lock lifted on a rejected reset")
+				}
+			}
+		})
+	}
+
+	// a token works exactly once
+	store := newFakeUserStore(newTestUser(t, "alice", "secret", auth.RoleUser))
+	handler := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(active), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+	body := `{"reset_token":"active","new_password":"correct horse"}`
+	if first, _ := handler.ResetPassword(events.APIGatewayProxyRequest{Body: body}); first.StatusCode != http.StatusOK {
+		t.Fatalf("first reset = %d", first.StatusCode)
+	}
+	if second, _ := handler.ResetPassword(events.APIGatewayProxyRequest{Body: body}); second.StatusCode != http.StatusUnauthorized {
+		t.Errorf("second reset = %d, want %d", second.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/notify"
+	"lambda-func/types"
+	"net/http"
+	"shared/apierror"
+	"shared/auth"
+	"shared/response"
+	"shared/validate"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// sendVerification signs a verification token for the email of user and sends it through the notifier.
+func (api ApiHandler) sendVerification(user types.User) error {
+	signingKey, err := api.keys.SigningKey()
+	if err != nil {
+		return err
+	}
+
+	token, err := types.CreateEmailVerification(user, signingKey)
+	if err != nil {
+		return err
+	}
+
+	return api.notifier.Notify(notify.VerificationEmail(user.Username, user.Email, token))
+}
+
+// VerifyEmail activates the account named by a verification token from registration.
+// It is not single use by itself: once the account is active, the token has nothing left to do.
+func (api ApiHandler) VerifyEmail(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	type VerifyEmailRequest struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	var verifyRequest VerifyEmailRequest
+
+	err := validate.Decode(request.Body, &verifyRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	claims, err := auth.ParseToken(verifyRequest.Token, api.keys, auth.EmailVerificationAudience)
+	if errors.Is(err, auth.ErrKeySetUnavailable) {
+		return response.Error(request, err)
+	}
+	if err != nil || claims.Email == "" {
+		return response.Error(request, apierror.Unauthorized("Invalid verification token"))
+	}
+
+	user, err := api.dbStore.GetUser(claims.Subject)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return response.Error(request, apierror.Unauthorized("Invalid verification token"))
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if !user.IsUnverified() {
+		return response.Error(request, apierror.Conflict("Email address is already verified"))
+	}
+	if user.Email != claims.Email {
+		return response.Error(request, apierror.Unauthorized("Invalid verification token"))
+	}
+
+	err = api.dbStore.VerifyEmail(user.Username, claims.Email)
+	if errors.Is(err, database.ErrEmailNotPending) {
+		return response.Error(request, apierror.Conflict("Email address is already verified"))
+	}
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error verifying email %w", err))
+	}
+
+	err = api.msgQueue.SendMessage("Email verified for " + user.Username)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error sending verification message %w", err))
+	}
+
+	return response.Text(http.StatusOK, "Email verified"), nil
+}
+
+// ResendVerification mails a new verification token to an account that is not active yet, for
+// a token that expired or never arrived. It asks for the password like /login does, and wrong
+// passwords count against the same lockout, so it cannot be used to flood someone's inbox.
+func (api ApiHandler) ResendVerification(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	type ResendRequest struct {
+		Username string `json:"username" validate:"required,max=32"`
+		Password string `json:"password" validate:"required,maxbytes=72"`
+	}
+
+	var resendRequest ResendRequest
+
+	err := validate.Decode(request.Body, &resendRequest)
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	attemptKeys := lockout.Keys(resendRequest.Username, request.RequestContext.Identity.SourceIP)
+	attempts, err := api.attempts.GetLoginAttempts(attemptKeys)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error checking login attempts %w", err))
+	}
+
+	if retryAfter := lockout.RetryAfter(attempts, time.Now()); retryAfter > 0 {
+		return response.Error(request, apierror.TooManyRequests("Too many failed login attempts", retryAfter))
+	}
+
+	user, err := api.dbStore.GetUser(resendRequest.Username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		api.passwords.CompareUnknownUser(resendRequest.Password)
+		return api.loginFailed(request, attemptKeys)
+	}
+	if err != nil {
+		return response.Error(request, err)
+	}
+
+	if !types.ValidatePassword(user.PasswordHash, resendRequest.Password) {
+		return api.loginFailed(request, attemptKeys)
+	}
+
+	if !user.IsUnverified() {
+		return response.Error(request, apierror.Conflict("Email address is already verified"))
+	}
+
+	err = api.sendVerification(user)
+	if err != nil {
+		return response.Error(request, fmt.Errorf("error sending verification email %w", err))
+	}
+
+	return response.Text(http.StatusOK, "Verification email sent"), nil
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"lambda-func/lockout"
+	"lambda-func/notify"
+	"lambda-func/types"
+	"net/http"
+	"shared/auth"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(message notify.Message) error {
+	return errStore
+}
+
+func newUnverifiedUser(t *testing.T, username string, email string) types.User {
+	t.Helper()
+	user := newTestUser(t, username, "secret", auth.RoleUser)
+	user.Email = email
+	user.Status = types.UserStatusUnverified
+	return user
+}
+
+func verificationToken(t *testing.T, user types.User) string {
+	t.Helper()
+	signingKey, err := testKeys.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := types.CreateEmailVerification(user, signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRegisterWithVerification(t *testing.T) {
+	store := newFakeUserStore()
+	queue := &fakeQueue{}
+	mailbox := notify.NewMailbox()
+	handler := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, mailbox, true)
+	login := events.APIGatewayProxyRequest{Body: `{"username":"bob","password":"correct horse"}`}
+
+	response, _ := handler.RegisterUser(events.APIGatewayProxyRequest{Body: `{"username":"bob","password":"correct horse"}`})
+	if response.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(response.Body, `"email":"is required"`) {
+		t.Fatalf("register without email = %d %q, want %d", response.StatusCode, response.Body, http.StatusUnprocessableEntity)
+	}
+
+	response, _ = handler.RegisterUser(events.APIGatewayProxyRequest{Body: `{"username":"bob","password":"correct horse","email":"bob@example.com"}`})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("register = %d %q, want %d", response.StatusCode, response.Body, http.StatusOK)
+	}
+	if !store.users["bob"].IsUnverified() {
+		t.Errorf("stored user = %+v, want it unverified", store.users["bob"])
+	}
+
+	messages := mailbox.Messages()
+	if len(messages) != 1 || messages[0].To != "bob@example.com" {
+		t.Fatalf("mails = %+v, want one to bob@example.com", messages)
+	}
+	lines := strings.Split(strings.TrimSpace(messages[0].Body), "\n")
+	token := lines[len(lines)-1]
+
+	response, _ = handler.LoginUser(login)
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("login before verification = %d, want %d", response.StatusCode, http.StatusForbidden)
+	}
+
+	response, _ = handler.VerifyEmail(events.APIGatewayProxyRequest{Body: `{"token":"` + token + `"}`})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("verify = %d %q, want %d", response.StatusCode, response.Body, http.StatusOK)
+	}
+	if store.users["bob"].Status != types.UserStatusActive {
+		t.Errorf("stored user = %+v, want it active", store.users["bob"])
+	}
+	if queue.messages[len(queue.messages)-1] != "Email verified for bob" {
+		t.Errorf("queue messages = %v", queue.messages)
+	}
+
+	response, _ = handler.LoginUser(login)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("login after verification = %d %q, want %d", response.StatusCode, response.Body, http.StatusOK)
+	}
+
+	response, _ = handler.VerifyEmail(events.APIGatewayProxyRequest{Body: `{"token":"` + token + `"}`})
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("verify again = %d, want %d", response.StatusCode, http.StatusConflict)
+	}
+}
+
+func TestRegisterNotifierFails(t *testing.T) {
+	store := newFakeUserStore()
+	queue := &fakeQueue{}
+	register := events.APIGatewayProxyRequest{Body: `{"username":"bob","password":"correct horse","email":"bob@example.com"}`}
+
+	response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, failingNotifier{}, true).RegisterUser(register)
+
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusInternalServerError)
+	}
+	if _, ok := store.users["bob"]; ok {
+		t.Error("user whose verification email failed was kept")
+	}
+	if len(queue.messages) != 0 {
+		t.Errorf("queue messages = %v, want none for a registration that was undone", queue.messages)
+	}
+
+	// the name is free again once mail works
+	response, _ = NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), queue, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, true).RegisterUser(register)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("register again = %d %q, want %d", response.StatusCode, response.Body, http.StatusOK)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	active := newTestUser(t, "alice", "secret", auth.RoleUser)
+	locked := types.LoginAttempts{Key: lockout.UserKey("bob"), Lockouts: 1, LockedUntil: time.Now().Add(time.Minute).Unix()}
+
+	tests := []struct {
+		name       string
+		body       string
+		attempts   []types.LoginAttempts
+		notifier   notify.Notifier
+		wantStatus int
+		wantMails  int
+	}{
+		{name: "success", body: `{"username":"bob","password":"secret"}`, wantStatus: http.StatusOK, wantMails: 1},
+		{name: "missing password", body: `{"username":"bob"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "wrong password", body: `{"username":"bob","password":"nope"}`, wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", body: `{"username":"carol","password":"secret"}`, wantStatus: http.StatusUnauthorized},
+		{name: "already verified", body: `{"username":"alice","password":"secret"}`, wantStatus: http.StatusConflict},
+		{name: "locked", body: `{"username":"bob","password":"secret"}`, attempts: []types.LoginAttempts{locked}, wantStatus: http.StatusTooManyRequests},
+		{name: "notifier fails", body: `{"username":"bob","password":"secret"}`, notifier: failingNotifier{}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailbox := notify.NewMailbox()
+			var notifier notify.Notifier = mailbox
+			if tt.notifier != nil {
+				notifier = tt.notifier
+			}
+			attempts := newFakeLoginAttemptStore(tt.attempts...)
+
+			response, _ := NewApiHandler(newFakeUserStore(newUnverifiedUser(t, "bob", "bob@example.com"), active), newFakeRefreshTokenStore(), newFakePasswordResetStore(), attempts, newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, notifier, true).ResendVerification(loginRequest(tt.body, "192.0.2.1"))
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			messages := mailbox.Messages()
+			if len(messages) != tt.wantMails {
+				t.Fatalf("mails = %+v, want %d", messages, tt.wantMails)
+			}
+			if tt.wantMails > 0 && messages[0].To != "bob@example.com" {
+				t.Errorf("mail to %q, want bob@example.com", messages[0].To)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && attempts.attempts[lockout.IPKey("192.0.2.1")].Failures != 1 {
+				t.Errorf("attempts = %+v, want the failure counted", attempts.attempts)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	bob := newUnverifiedUser(t, "bob", "bob@example.com")
+	otherAddress := newUnverifiedUser(t, "bob", "bob@example.org")
+	accessToken := func() string {
+		signingKey, _ := testKeys.SigningKey()
+		token, err := types.CreateToken(bob, "session-1", auth.UserApiAudience, nil, signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}()
+
+	tests := []struct {
+		name       string
+		body       string
+		storeErr   error
+		wantStatus int
+	}{
+		{name: "success", body: `{"token":"` + verificationToken(t, bob) + `"}`, wantStatus: http.StatusOK},
+		{name: "missing token", body: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "garbage token", body: `{"token":"not.a.token"}`, wantStatus: http.StatusUnauthorized},
+		{name: "access token", body: `{"token":"` + accessToken + `"}`, wantStatus: http.StatusUnauthorized},
+		{name: "other address", body: `{"token":"` + verificationToken(t, otherAddress) + `"}`, wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", body: `{"token":"` + verificationToken(t, newUnverifiedUser(t, "carol", "carol@example.com")) + `"}`, wantStatus: http.StatusUnauthorized},
+		{name: "store fails", body: `{"token":"` + verificationToken(t, bob) + `"}`, storeErr: errStore, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeUserStore(bob)
+			store.errs["VerifyEmail"] = tt.storeErr
+
+			response, _ := NewApiHandler(store, newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, true).VerifyEmail(events.APIGatewayProxyRequest{Body: tt.body})
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if verified := !store.users["bob"].IsUnverified(); verified != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("verified = %v after status %d", verified, response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestLoginUnverifiedWithoutVerification(t *testing.T) {
+	handler := NewApiHandler(newFakeUserStore(newUnverifiedUser(t, "bob", "bob@example.com")), newFakeRefreshTokenStore(), newFakePasswordResetStore(), newFakeLoginAttemptStore(), newFakeRevocationStore(), &fakeQueue{}, testKeys, testPolicy, testLockouts, testMfa, testRoles, testNotifier, false)
+
+	response, _ := handler.LoginUser(events.APIGatewayProxyRequest{Body: `{"username":"bob","password":"secret"}`})
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status = %d with verification turned off, want %d", response.StatusCode, http.StatusOK)
+	}
+}
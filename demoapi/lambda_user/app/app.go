@@ -2,20 +2,191 @@ package app
 
 import (
 	"lambda-func/api"
+	"lambda-func/common"
 	"lambda-func/database"
+	"lambda-func/lockout"
+	"lambda-func/mfa"
+	"lambda-func/notify"
+	"lambda-func/password"
 	"lambda-func/queue"
+	"lambda-func/signing"
+	"log"
+	"os"
+	"shared/auth"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type App struct {
 	ApiHandler api.ApiHandler
+	Middleware auth.Middleware
+	Roles      *auth.Roles
 }
 
-func NewApp() App {
-	db := database.NewDynamoDB()
-	q := queue.NewSqsClient()
-	apiHandler := api.NewApiHandler(db, q)
+type Config struct {
+	StoreBackend   string
+	QueueBackend   string
+	NotifyBackend  string
+	SigningKeyIds  []string
+	SigningKeyFile string
+	RolesFile      string
+
+	PasswordMinLength  int
+	PasswordMinClasses int
+	PasswordHashCost   int
+
+	LoginMaxFailures   int
+	LoginMaxIPFailures int
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+	LoginMaxLockout    time.Duration
+
+	MfaIssuer            string
+	MfaRequiredForAdmins bool
+
+	EmailVerificationRequired bool
+}
+
+func ConfigFromEnv() Config {
+	var signingKeyIds []string
+	for _, keyId := range strings.Split(os.Getenv(common.SigningKeyIdsEnv), ",") {
+		if keyId = strings.TrimSpace(keyId); keyId != "" {
+			signingKeyIds = append(signingKeyIds, keyId)
+		}
+	}
+
+	return Config{
+		StoreBackend:   os.Getenv(common.StoreBackendEnv),
+		QueueBackend:   os.Getenv(common.QueueBackendEnv),
+		NotifyBackend:  os.Getenv(common.NotifyBackendEnv),
+		SigningKeyIds:  signingKeyIds,
+		SigningKeyFile: os.Getenv(common.SigningKeyFileEnv),
+		RolesFile:      os.Getenv(common.RolesFileEnv),
+
+		PasswordMinLength:  intFromEnv(common.PasswordMinLengthEnv, common.DefaultPasswordMinLength),
+		PasswordMinClasses: intFromEnv(common.PasswordMinClassesEnv, common.DefaultPasswordMinClasses),
+		PasswordHashCost:   intFromEnv(common.PasswordHashCostEnv, common.DefaultPasswordHashCost),
+
+		LoginMaxFailures:   intFromEnv(common.LoginMaxFailuresEnv, common.DefaultLoginMaxFailures),
+		LoginMaxIPFailures: intFromEnv(common.LoginMaxIPFailuresEnv, common.DefaultLoginMaxIPFailures),
+		LoginFailureWindow: secondsFromEnv(common.LoginFailureWindowEnv, common.DefaultLoginFailureWindow),
+		LoginLockout:       secondsFromEnv(common.LoginLockoutEnv, common.DefaultLoginLockout),
+		LoginMaxLockout:    secondsFromEnv(common.LoginMaxLockoutEnv, common.DefaultLoginMaxLockout),
+
+		MfaIssuer:            envOrDefault(common.MfaIssuerEnv, common.DefaultMfaIssuer),
+		MfaRequiredForAdmins: os.Getenv(common.MfaRequiredForAdminsEnv) == "true",
+
+		EmailVerificationRequired: os.Getenv(common.EmailVerificationRequiredEnv) == "true",
+	}
+}
+
+func intFromEnv(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be a number, got %q", name, value)
+	}
+	return parsed
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func secondsFromEnv(name string, defaultSeconds int) time.Duration {
+	return time.Duration(intFromEnv(name, defaultSeconds)) * time.Second
+}
+
+func NewApp(config Config) App {
+	var db database.UserStore
+	var tokenStore database.RefreshTokenStore
+	var resetStore database.PasswordResetStore
+	var attempts database.LoginAttemptStore
+	var revocations database.RevocationStore
+	var roleSource auth.RoleSource
+	if config.StoreBackend == common.BackendMemory {
+		store := database.NewMemoryStore()
+		db, tokenStore, resetStore, attempts, revocations = store, store, store, store, store
+	} else {
+		store := database.NewDynamoDB()
+		db, tokenStore, resetStore, attempts, revocations = store, store, store, store, store
+		roleSource = store
+	}
+
+	// a roles file takes the place of the role table, local runs without either get the built-in roles
+	if config.RolesFile != "" {
+		roleSource = auth.NewFileRoleSource(config.RolesFile)
+	}
+	roles := auth.NewRoles(roleSource, common.RoleCacheTTL)
+
+	var q queue.MessageQueue
+	if config.QueueBackend == common.BackendMemory {
+		q = queue.NewMemoryQueue()
+	} else {
+		q = queue.NewSqsClient()
+	}
+
+	// verification emails go out through the queue unless the local mailbox stands in for SMTP
+	var notifier notify.Notifier
+	if config.NotifyBackend == common.BackendMemory {
+		notifier = notify.NewMailbox()
+	} else {
+		notifier = notify.NewQueueNotifier(q)
+	}
+
+	passwords, err := password.NewPolicy(config.PasswordMinLength, config.PasswordMinClasses, config.PasswordHashCost)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lockouts, err := lockout.NewPolicy(config.LoginMaxFailures, config.LoginMaxIPFailures, config.LoginFailureWindow, config.LoginLockout, config.LoginMaxLockout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys := newKeySet(config)
+	apiHandler := api.NewApiHandler(db, tokenStore, resetStore, attempts, revocations, q, keys, passwords, lockouts, mfa.NewPolicy(config.MfaIssuer, config.MfaRequiredForAdmins), roles, notifier, config.EmailVerificationRequired)
 
 	return App{
 		ApiHandler: apiHandler,
+		Middleware: auth.NewMiddleware(keys, revocations, auth.UserApiAudience),
+		Roles:      roles,
 	}
 }
+
+func newKeySet(config Config) signing.KeySet {
+	var keys signing.KeySet
+
+	switch {
+	case len(config.SigningKeyIds) > 0:
+		keys = signing.NewKMSKeySet(config.SigningKeyIds)
+	case config.SigningKeyFile != "":
+		keySet, err := signing.NewFileKeySet(config.SigningKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = keySet
+	default:
+		log.Printf("no signing key configured, tokens are signed with a key that is lost on exit")
+		keySet, err := signing.NewEphemeralKeySet()
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = keySet
+	}
+
+	// warm the cache during cold start so the first request does not pay for it
+	if _, err := keys.SigningKey(); err != nil {
+		log.Printf("signing key is not available yet: %v", err)
+	}
+
+	return keys
+}
@@ -1,7 +1,64 @@
 package common
 
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
 const QueueName = "JITestDemoQueue"
 const UserTableName = "JITestDemoUserTable"
-const TokenSecret = "very-strong-secret"
-const RoleUser = "user"
-const RoleAdmin = "admin"
+const RefreshTokenTableName = "JITestDemoRefreshTokenTable"
+const RefreshTokenFamilyIndex = "familyId-index"
+const RevokedTokenTableName = "JITestDemoRevokedTokenTable"
+const PasswordResetTableName = "JITestDemoPasswordResetTable"
+const LoginAttemptTableName = "JITestDemoLoginAttemptTable"
+const RoleTableName = "JITestDemoRoleTable"
+const LocalModeEnv = "LOCAL_MODE"
+const LocalAddrEnv = "LOCAL_ADDR"
+const DynamoDBEndpointEnv = "DYNAMODB_ENDPOINT"
+const StoreBackendEnv = "STORE_BACKEND"
+const QueueBackendEnv = "QUEUE_BACKEND"
+const BackendMemory = "memory"
+const SigningKeyIdsEnv = "SIGNING_KEY_IDS"
+const SigningKeyFileEnv = "SIGNING_KEY_FILE"
+const PasswordMinLengthEnv = "PASSWORD_MIN_LENGTH"
+const PasswordMinClassesEnv = "PASSWORD_MIN_CLASSES"
+const PasswordHashCostEnv = "PASSWORD_HASH_COST"
+const DefaultPasswordMinLength = 8
+const DefaultPasswordMinClasses = 2
+const DefaultPasswordHashCost = 10
+const LoginMaxFailuresEnv = "LOGIN_MAX_FAILURES"
+const LoginMaxIPFailuresEnv = "LOGIN_MAX_IP_FAILURES"
+const LoginFailureWindowEnv = "LOGIN_FAILURE_WINDOW_SECONDS"
+const LoginLockoutEnv = "LOGIN_LOCKOUT_SECONDS"
+const LoginMaxLockoutEnv = "LOGIN_MAX_LOCKOUT_SECONDS"
+const DefaultLoginMaxFailures = 5
+const DefaultLoginMaxIPFailures = 20
+const DefaultLoginFailureWindow = 15 * 60
+const DefaultLoginLockout = 60
+const DefaultLoginMaxLockout = 60 * 60
+const MfaIssuerEnv = "MFA_ISSUER"
+const MfaRequiredForAdminsEnv = "MFA_REQUIRED_FOR_ADMINS"
+const DefaultMfaIssuer = "JITestDemo"
+const EmailVerificationRequiredEnv = "EMAIL_VERIFICATION_REQUIRED"
+const NotifyBackendEnv = "NOTIFY_BACKEND"
+const RolesFileEnv = "ROLES_FILE"
+const DefaultPageSize = 50
+const MaxPageSize = 100
+const AccessTokenTTL = time.Hour
+const RefreshTokenTTL = 30 * 24 * time.Hour
+const PasswordResetTTL = time.Hour
+const LoginAttemptTTL = 24 * time.Hour
+const MfaChallengeTTL = 5 * time.Minute
+const EmailVerificationTTL = 24 * time.Hour
+const RoleCacheTTL = 5 * time.Minute
+
+// GenerateRandomToken returns size random bytes encoded as url-safe base64.
+func GenerateRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -1,36 +1,72 @@
 package database
 
 import (
-	"fmt"
+	"errors"
 	"lambda-func/common"
 	"lambda-func/types"
+	"os"
+	"shared/auth"
+	"shared/pagination"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+var ErrUserExists = errors.New("user already exists")
+
+// ErrInvalidCursor is returned for a cursor this store did not hand out.
+var ErrInvalidCursor = pagination.ErrInvalidCursor
+
 type UserStore interface {
 	DoesUserExist(username string) (bool, error)
+	// InsertUser fails with ErrUserExists rather than replace a user of the same name.
 	InsertUser(user types.User) error
 	GetUser(username string) (types.User, error)
+	// UpdateUser fails with ErrUserNotFound rather than create the user it changes.
 	UpdateUser(user types.User) error
+	UpdatePasswordHash(username string, passwordHash string) error
 	DeleteUser(user types.User) error
-	ListUsers() ([]types.User, error)
+	ListUsersPage(limit int, cursor string) ([]types.User, string, error)
+
+	// SetMfaSecret starts an enrollment; the secret only counts once EnableMfa confirms it.
+	SetMfaSecret(username string, secret string) error
+	// EnableMfa turns on the pending secret and fails with ErrMfaSetupChanged if it was replaced meanwhile.
+	EnableMfa(username string, secret string, recoveryCodeHashes []string, step int64) error
+	// UseMfaStep records the time step of an accepted code and fails with ErrMfaCodeUsed for a step already used.
+	UseMfaStep(username string, step int64) error
+	// UseRecoveryCode removes a recovery code and fails with ErrRecoveryCodeNotFound if it is not there.
+	UseRecoveryCode(username string, codeHash string) error
+
+	// VerifyEmail activates an unverified account with the given email and fails with ErrEmailNotPending otherwise.
+	VerifyEmail(username string, email string) error
 }
 
 type DynamoDBClient struct {
-	databaseStore *dynamodb.DynamoDB
+	// both lambdas read the revocation list and the role table the same way
+	auth.DynamoRevocations
+	auth.DynamoRoleSource
+
+	databaseStore dynamodbiface.DynamoDBAPI
 }
 
 func NewDynamoDB() DynamoDBClient {
-	dbSession := session.Must(session.NewSession())
+	config := aws.NewConfig()
+	if endpoint := os.Getenv(common.DynamoDBEndpointEnv); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+
+	dbSession := session.Must(session.NewSession(config))
 	db := dynamodb.New(dbSession)
 
 	return DynamoDBClient{
-		databaseStore: db,
+		DynamoRevocations: auth.NewDynamoRevocations(db, common.RevokedTokenTableName),
+		DynamoRoleSource:  auth.NewDynamoRoleSource(db, common.RoleTableName),
+		databaseStore:     db,
 	}
 }
 
@@ -56,33 +92,50 @@ func (u DynamoDBClient) DoesUserExist(username string) (bool, error) {
 }
 
 func (u DynamoDBClient) InsertUser(user types.User) error {
-	item := &dynamodb.PutItemInput{
-		TableName: aws.String(common.UserTableName),
-		Item: map[string]*dynamodb.AttributeValue{
-			"username": {
-				S: aws.String(user.Username),
-			},
-			"password": {
-				S: aws.String(user.PasswordHash),
-			},
-			"role": {
-				S: aws.String(user.Role),
-			},
-		},
+	item, err := dynamodbattribute.MarshalMap(user)
+	if err != nil {
+		return err
 	}
 
-	_, err := u.databaseStore.PutItem(item)
+	// DoesUserExist is only a fast path, two registrations can both get past it
+	condition := expression.AttributeNotExists(expression.Name("username"))
+	expr, err := expression.NewBuilder().WithCondition(condition).Build()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = u.databaseStore.PutItem(&dynamodb.PutItemInput{
+		TableName:                aws.String(common.UserTableName),
+		Item:                     item,
+		ExpressionAttributeNames: expr.Names(),
+		ConditionExpression:      expr.Condition(),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrUserExists
+	}
+
+	return err
 }
 
 func (u DynamoDBClient) UpdateUser(user types.User) error {
 
 	update := expression.Set(expression.Name("role"), expression.Value(user.Role))
-	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	condition := expression.AttributeExists(expression.Name("username"))
+
+	err := u.updateUserItem(user.Username, update, condition)
+	if isConditionalCheckFailed(err) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
+
+func (u DynamoDBClient) UpdatePasswordHash(username string, passwordHash string) error {
+
+	update := expression.Set(expression.Name("password"), expression.Value(passwordHash))
+	// UpdateItem would otherwise create a user that was removed in the meantime
+	condition := expression.AttributeExists(expression.Name("username"))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
 
 	if err != nil {
 		return err
@@ -92,20 +145,21 @@ func (u DynamoDBClient) UpdateUser(user types.User) error {
 		TableName: aws.String(common.UserTableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"username": {
-				S: aws.String(user.Username),
+				S: aws.String(username),
 			},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
 	}
 
 	_, err = u.databaseStore.UpdateItem(item)
-	if err != nil {
-		return err
+	if isConditionalCheckFailed(err) {
+		return ErrUserNotFound
 	}
 
-	return nil
+	return err
 }
 
 func (u DynamoDBClient) GetUser(username string) (types.User, error) {
@@ -125,7 +179,7 @@ func (u DynamoDBClient) GetUser(username string) (types.User, error) {
 	}
 
 	if result.Item == nil {
-		return user, fmt.Errorf("user not found")
+		return user, ErrUserNotFound
 	}
 
 	err = dynamodbattribute.UnmarshalMap(result.Item, &user)
@@ -155,27 +209,38 @@ func (u DynamoDBClient) DeleteUser(user types.User) error {
 	return nil
 }
 
-func (u DynamoDBClient) ListUsers() ([]types.User, error) {
-	var users []types.User
+func (u DynamoDBClient) ListUsersPage(limit int, cursor string) ([]types.User, string, error) {
+	exclusiveStartKey, err := pagination.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
 
 	result, err := u.databaseStore.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(common.UserTableName),
+		TableName:         aws.String(common.UserTableName),
+		Limit:             aws.Int64(int64(limit)),
+		ExclusiveStartKey: exclusiveStartKey,
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
+	var users []types.User
 	for _, i := range result.Items {
 		item := types.User{}
 		err = dynamodbattribute.UnmarshalMap(i, &item)
 
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		users = append(users, item)
 	}
 
-	return users, nil
+	nextCursor, err := pagination.EncodeCursor(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return users, nextCursor, nil
 }
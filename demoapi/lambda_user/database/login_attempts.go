@@ -0,0 +1,154 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/common"
+	"lambda-func/types"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var ErrLoginAttemptCheckIncomplete = errors.New("login attempt check incomplete")
+
+// LoginAttemptStore counts failed logins per username and per source IP.
+// Records expire through the table TTL, expired ones are ignored until then.
+type LoginAttemptStore interface {
+	// GetLoginAttempts returns the live records among keys.
+	GetLoginAttempts(keys []string) ([]types.LoginAttempts, error)
+	// RecordLoginFailure adds a failure to key and returns the updated record;
+	// the count starts over once window has passed since its first failure.
+	RecordLoginFailure(key string, window time.Duration) (types.LoginAttempts, error)
+	LockLogin(attempts types.LoginAttempts) error
+	ClearLoginAttempts(key string) error
+}
+
+func (u DynamoDBClient) GetLoginAttempts(keys []string) ([]types.LoginAttempts, error) {
+	var requestKeys []map[string]*dynamodb.AttributeValue
+	for _, key := range keys {
+		requestKeys = append(requestKeys, map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(key)},
+		})
+	}
+
+	result, err := u.databaseStore.BatchGetItem(&dynamodb.BatchGetItemInput{
+		RequestItems: map[string]*dynamodb.KeysAndAttributes{
+			common.LoginAttemptTableName: {
+				Keys:           requestKeys,
+				ConsistentRead: aws.Bool(true),
+			},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.UnprocessedKeys) > 0 {
+		return nil, ErrLoginAttemptCheckIncomplete
+	}
+
+	var attempts []types.LoginAttempts
+	for _, item := range result.Responses[common.LoginAttemptTableName] {
+		var attempt types.LoginAttempts
+		err = dynamodbattribute.UnmarshalMap(item, &attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		if !attempt.IsExpired() {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (u DynamoDBClient) RecordLoginFailure(key string, window time.Duration) (types.LoginAttempts, error) {
+	now := time.Now()
+	expiresAt := now.Add(common.LoginAttemptTTL).Unix()
+
+	// count on while the window is open; a missing record fails the condition too
+	condition := expression.Name("windowStart").GreaterThan(expression.Value(now.Add(-window).Unix()))
+	update := expression.Add(expression.Name("failures"), expression.Value(1)).
+		Set(expression.Name("expiresAt"), expression.Value(expiresAt))
+
+	attempts, err := u.updateLoginAttempts(key, expression.NewBuilder().WithCondition(condition).WithUpdate(update))
+
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) || awsErr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return attempts, err
+	}
+
+	// concurrent failures opening a new window may both start at one, which only errs on the lenient side
+	update = expression.Set(expression.Name("failures"), expression.Value(1)).
+		Set(expression.Name("windowStart"), expression.Value(now.Unix())).
+		Set(expression.Name("expiresAt"), expression.Value(expiresAt))
+
+	return u.updateLoginAttempts(key, expression.NewBuilder().WithUpdate(update))
+}
+
+func (u DynamoDBClient) updateLoginAttempts(key string, builder expression.Builder) (types.LoginAttempts, error) {
+	var attempts types.LoginAttempts
+
+	expr, err := builder.Build()
+	if err != nil {
+		return attempts, err
+	}
+
+	result, err := u.databaseStore.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(common.LoginAttemptTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(key),
+			},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+
+	if err != nil {
+		return attempts, err
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &attempts)
+	if err != nil {
+		return attempts, err
+	}
+
+	return attempts, nil
+}
+
+func (u DynamoDBClient) LockLogin(attempts types.LoginAttempts) error {
+	item, err := dynamodbattribute.MarshalMap(attempts)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.databaseStore.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(common.LoginAttemptTableName),
+		Item:      item,
+	})
+
+	return err
+}
+
+func (u DynamoDBClient) ClearLoginAttempts(key string) error {
+	_, err := u.databaseStore.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(common.LoginAttemptTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(key),
+			},
+		},
+	})
+
+	return err
+}
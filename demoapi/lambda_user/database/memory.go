@@ -0,0 +1,380 @@
+package database
+
+import (
+	"lambda-func/common"
+	"lambda-func/types"
+	"shared/pagination"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// MemoryStore is a UserStore, RefreshTokenStore, PasswordResetStore, LoginAttemptStore and RevocationStore kept in process memory, used for local runs and tests.
+type MemoryStore struct {
+	mu              sync.RWMutex
+	users           map[string]types.User
+	refreshTokens   map[string]types.RefreshToken
+	passwordResets  map[string]types.PasswordReset
+	loginAttempts   map[string]types.LoginAttempts
+	revokedSessions map[string]bool
+	revokedUsers    map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:           map[string]types.User{},
+		refreshTokens:   map[string]types.RefreshToken{},
+		passwordResets:  map[string]types.PasswordReset{},
+		loginAttempts:   map[string]types.LoginAttempts{},
+		revokedSessions: map[string]bool{},
+		revokedUsers:    map[string]int64{},
+	}
+}
+
+func (m *MemoryStore) DoesUserExist(username string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.users[username]
+	return ok, nil
+}
+
+func (m *MemoryStore) InsertUser(user types.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.Username]; ok {
+		return ErrUserExists
+	}
+	m.users[user.Username] = user
+	return nil
+}
+
+func (m *MemoryStore) GetUser(username string) (types.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return types.User{}, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (m *MemoryStore) UpdateUser(user types.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.users[user.Username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	existing.Role = user.Role
+	m.users[user.Username] = existing
+
+	return nil
+}
+
+func (m *MemoryStore) UpdatePasswordHash(username string, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	m.users[username] = user
+
+	return nil
+}
+
+func (m *MemoryStore) SetMfaSecret(username string, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	user.MfaSecret = secret
+	m.users[username] = user
+	return nil
+}
+
+func (m *MemoryStore) EnableMfa(username string, secret string, recoveryCodeHashes []string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok || user.MfaSecret != secret {
+		return ErrMfaSetupChanged
+	}
+
+	user.MfaEnabled = true
+	user.MfaLastStep = step
+	user.RecoveryCodes = recoveryCodeHashes
+	m.users[username] = user
+	return nil
+}
+
+func (m *MemoryStore) UseMfaStep(username string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok || !user.MfaEnabled || user.MfaLastStep >= step {
+		return ErrMfaCodeUsed
+	}
+
+	user.MfaLastStep = step
+	m.users[username] = user
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(username string, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return ErrRecoveryCodeNotFound
+	}
+
+	for i, stored := range user.RecoveryCodes {
+		if stored == codeHash {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			m.users[username] = user
+			return nil
+		}
+	}
+
+	return ErrRecoveryCodeNotFound
+}
+
+func (m *MemoryStore) VerifyEmail(username string, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok || !user.IsUnverified() || user.Email != email {
+		return ErrEmailNotPending
+	}
+
+	user.Status = types.UserStatusActive
+	m.users[username] = user
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(user types.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.users, user.Username)
+	return nil
+}
+
+func (m *MemoryStore) ListUsersPage(limit int, cursor string) ([]types.User, string, error) {
+	startKey, err := pagination.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.RLock()
+	users := make([]types.User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	start := 0
+	if startKey != nil {
+		after := startKey["username"]
+		if after == nil || after.S == nil {
+			return nil, "", ErrInvalidCursor
+		}
+		start = sort.Search(len(users), func(i int) bool {
+			return users[i].Username > *after.S
+		})
+	}
+
+	end := start + limit
+	if end >= len(users) {
+		return users[start:], "", nil
+	}
+
+	nextCursor, err := pagination.EncodeCursor(map[string]*dynamodb.AttributeValue{
+		"username": {S: aws.String(users[end-1].Username)},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return users[start:end], nextCursor, nil
+}
+
+func (m *MemoryStore) InsertRefreshToken(token types.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *MemoryStore) GetRefreshToken(tokenHash string) (types.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return types.RefreshToken{}, ErrRefreshTokenNotFound
+	}
+
+	return token, nil
+}
+
+func (m *MemoryStore) RotateRefreshToken(tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok || token.Rotated || token.Revoked {
+		return ErrRefreshTokenReused
+	}
+
+	token.Rotated = true
+	m.refreshTokens[tokenHash] = token
+	return nil
+}
+
+func (m *MemoryStore) RevokeRefreshTokenFamily(familyId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for tokenHash, token := range m.refreshTokens {
+		if token.FamilyId == familyId {
+			token.Revoked = true
+			m.refreshTokens[tokenHash] = token
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) InsertPasswordReset(reset types.PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.passwordResets[reset.TokenHash] = reset
+	return nil
+}
+
+func (m *MemoryStore) GetPasswordReset(tokenHash string) (types.PasswordReset, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reset, ok := m.passwordResets[tokenHash]
+	if !ok {
+		return types.PasswordReset{}, ErrPasswordResetNotFound
+	}
+
+	return reset, nil
+}
+
+func (m *MemoryStore) ConsumePasswordReset(tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwordResets[tokenHash]; !ok {
+		return ErrPasswordResetNotFound
+	}
+
+	delete(m.passwordResets, tokenHash)
+	return nil
+}
+
+func (m *MemoryStore) GetLoginAttempts(keys []string) ([]types.LoginAttempts, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var attempts []types.LoginAttempts
+	for _, key := range keys {
+		if attempt, ok := m.loginAttempts[key]; ok && !attempt.IsExpired() {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (m *MemoryStore) RecordLoginFailure(key string, window time.Duration) (types.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	attempts := m.loginAttempts[key]
+	attempts.Key = key
+	if attempts.WindowStart > now.Add(-window).Unix() {
+		attempts.Failures++
+	} else {
+		attempts.Failures = 1
+		attempts.WindowStart = now.Unix()
+	}
+	attempts.ExpiresAt = now.Add(common.LoginAttemptTTL).Unix()
+	m.loginAttempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (m *MemoryStore) LockLogin(attempts types.LoginAttempts) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loginAttempts[attempts.Key] = attempts
+	return nil
+}
+
+func (m *MemoryStore) ClearLoginAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginAttempts, key)
+	return nil
+}
+
+func (m *MemoryStore) RevokeSession(sessionId string, expiresAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokedSessions[sessionId] = true
+	return nil
+}
+
+func (m *MemoryStore) RevokeUserTokens(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokedUsers[username] = time.Now().UnixMilli()
+	return nil
+}
+
+func (m *MemoryStore) IsRevoked(sessionId string, username string, issuedAt int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if sessionId != "" && m.revokedSessions[sessionId] {
+		return true, nil
+	}
+
+	revokedBefore, ok := m.revokedUsers[username]
+	return ok && issuedAt < revokedBefore, nil
+}
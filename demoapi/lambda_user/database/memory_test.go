@@ -0,0 +1,116 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/types"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreListUsersPage(t *testing.T) {
+	store := NewMemoryStore()
+	for _, username := range []string{"dave", "alice", "carol", "bob", "erin"} {
+		store.InsertUser(types.User{Username: username})
+	}
+
+	var got []string
+	cursor := ""
+	pages := 0
+	for {
+		users, nextCursor, err := store.ListUsersPage(2, cursor)
+		if err != nil {
+			t.Fatalf("ListUsersPage: %v", err)
+		}
+		for _, user := range users {
+			got = append(got, user.Username)
+		}
+		pages++
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	want := []string{"alice", "bob", "carol", "dave", "erin"}
+	if len(got) != len(want) || pages != 3 {
+		t.Fatalf("got %v in %d pages, want %v in 3 pages", got, pages, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	if _, _, err := store.ListUsersPage(2, "not-a-cursor!"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestMemoryStoreGetUserNotFound(t *testing.T) {
+	store := NewMemoryStore()
+
+	if _, err := store.GetUser("nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestMemoryStoreConditionalWrites(t *testing.T) {
+	store := NewMemoryStore()
+	store.InsertUser(types.User{Username: "alice", PasswordHash: "first"})
+
+	if err := store.InsertUser(types.User{Username: "alice", PasswordHash: "second"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("err = %v, want ErrUserExists", err)
+	}
+	if user, _ := store.GetUser("alice"); user.PasswordHash != "first" {
+		t.Errorf("password hash = %q, the second registration overwrote the first", user.PasswordHash)
+	}
+
+	if err := store.UpdateUser(types.User{Username: "nobody", Role: "admin"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("err = %v, want ErrUserNotFound", err)
+	}
+	if exists, _ := store.DoesUserExist("nobody"); exists {
+		t.Error("UpdateUser created a user")
+	}
+}
+
+func TestMemoryStoreRecordLoginFailure(t *testing.T) {
+	store := NewMemoryStore()
+
+	for i := 1; i <= 3; i++ {
+		attempts, err := store.RecordLoginFailure("user#alice", time.Minute)
+		if err != nil || attempts.Failures != i {
+			t.Fatalf("failure %d recorded as %+v, %v", i, attempts, err)
+		}
+	}
+
+	// a failure after the window starts a new count
+	store.loginAttempts["user#alice"] = types.LoginAttempts{Key: "user#alice", Failures: 3, WindowStart: time.Now().Add(-2 * time.Minute).Unix(), Lockouts: 2}
+	attempts, err := store.RecordLoginFailure("user#alice", time.Minute)
+	if err != nil || attempts.Failures != 1 || attempts.Lockouts != 2 {
+		t.Errorf("failure after the window recorded as %+v, %v", attempts, err)
+	}
+
+	store.loginAttempts["user#bob"] = types.LoginAttempts{Key: "user#bob", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+	found, err := store.GetLoginAttempts([]string{"user#alice", "user#bob", "ip#192.0.2.1"})
+	if err != nil || len(found) != 1 || found[0].Key != "user#alice" {
+		t.Errorf("GetLoginAttempts = %+v, %v, want only the live record of alice", found, err)
+	}
+}
+
+func TestMemoryStoreVerifyEmail(t *testing.T) {
+	store := NewMemoryStore()
+	store.InsertUser(types.User{Username: "bob", Email: "bob@example.com", Status: types.UserStatusUnverified})
+
+	if err := store.VerifyEmail("bob", "bob@example.org"); !errors.Is(err, ErrEmailNotPending) {
+		t.Errorf("other address: err = %v, want ErrEmailNotPending", err)
+	}
+	if err := store.VerifyEmail("bob", "bob@example.com"); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if user, _ := store.GetUser("bob"); user.Status != types.UserStatusActive {
+		t.Errorf("status = %q, want %q", user.Status, types.UserStatusActive)
+	}
+	if err := store.VerifyEmail("bob", "bob@example.com"); !errors.Is(err, ErrEmailNotPending) {
+		t.Errorf("second time: err = %v, want ErrEmailNotPending", err)
+	}
+}
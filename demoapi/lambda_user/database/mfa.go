@@ -0,0 +1,102 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/common"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var ErrMfaSetupChanged = errors.New("mfa setup changed")
+var ErrMfaCodeUsed = errors.New("mfa code already used")
+var ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+
+// stringSet marshals as a DynamoDB string set, which ADD and DELETE updates need.
+type stringSet []string
+
+func (s stringSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	av.SS = aws.StringSlice(s)
+	return nil
+}
+
+func (u DynamoDBClient) SetMfaSecret(username string, secret string) error {
+	update := expression.Set(expression.Name("mfaSecret"), expression.Value(secret))
+	condition := expression.AttributeExists(expression.Name("username"))
+
+	err := u.updateUserItem(username, update, condition)
+	if isConditionalCheckFailed(err) {
+		return ErrUserNotFound
+	}
+
+	return err
+}
+
+func (u DynamoDBClient) EnableMfa(username string, secret string, recoveryCodeHashes []string, step int64) error {
+	update := expression.Set(expression.Name("mfaEnabled"), expression.Value(true)).
+		Set(expression.Name("mfaLastStep"), expression.Value(step)).
+		Set(expression.Name("recoveryCodes"), expression.Value(stringSet(recoveryCodeHashes)))
+	// a second setup may have replaced the secret since the code was checked
+	condition := expression.Name("mfaSecret").Equal(expression.Value(secret))
+
+	err := u.updateUserItem(username, update, condition)
+	if isConditionalCheckFailed(err) {
+		return ErrMfaSetupChanged
+	}
+
+	return err
+}
+
+func (u DynamoDBClient) UseMfaStep(username string, step int64) error {
+	update := expression.Set(expression.Name("mfaLastStep"), expression.Value(step))
+	condition := expression.Name("mfaEnabled").Equal(expression.Value(true)).
+		And(expression.Name("mfaLastStep").LessThan(expression.Value(step)))
+
+	err := u.updateUserItem(username, update, condition)
+	if isConditionalCheckFailed(err) {
+		return ErrMfaCodeUsed
+	}
+
+	return err
+}
+
+func (u DynamoDBClient) UseRecoveryCode(username string, codeHash string) error {
+	update := expression.Delete(expression.Name("recoveryCodes"), expression.Value(stringSet{codeHash}))
+	condition := expression.Contains(expression.Name("recoveryCodes"), codeHash)
+
+	err := u.updateUserItem(username, update, condition)
+	if isConditionalCheckFailed(err) {
+		return ErrRecoveryCodeNotFound
+	}
+
+	return err
+}
+
+func (u DynamoDBClient) updateUserItem(username string, update expression.UpdateBuilder, condition expression.ConditionBuilder) error {
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = u.databaseStore.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(common.UserTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	})
+
+	return err
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
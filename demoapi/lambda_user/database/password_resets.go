@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/common"
+	"lambda-func/types"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var ErrPasswordResetNotFound = errors.New("password reset not found")
+
+type PasswordResetStore interface {
+	InsertPasswordReset(reset types.PasswordReset) error
+	GetPasswordReset(tokenHash string) (types.PasswordReset, error)
+	// ConsumePasswordReset deletes a reset token and fails with ErrPasswordResetNotFound
+	// if it is already gone, so each token changes a password at most once.
+	ConsumePasswordReset(tokenHash string) error
+}
+
+func (u DynamoDBClient) InsertPasswordReset(reset types.PasswordReset) error {
+	item, err := dynamodbattribute.MarshalMap(reset)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.databaseStore.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(common.PasswordResetTableName),
+		Item:      item,
+	})
+
+	return err
+}
+
+func (u DynamoDBClient) GetPasswordReset(tokenHash string) (types.PasswordReset, error) {
+	var reset types.PasswordReset
+
+	result, err := u.databaseStore.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(common.PasswordResetTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"tokenHash": {
+				S: aws.String(tokenHash),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	if err != nil {
+		return reset, err
+	}
+
+	if result.Item == nil {
+		return reset, ErrPasswordResetNotFound
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Item, &reset)
+	if err != nil {
+		return reset, err
+	}
+
+	return reset, nil
+}
+
+func (u DynamoDBClient) ConsumePasswordReset(tokenHash string) error {
+	condition := expression.AttributeExists(expression.Name("tokenHash"))
+	expr, err := expression.NewBuilder().WithCondition(condition).Build()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = u.databaseStore.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(common.PasswordResetTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"tokenHash": {
+				S: aws.String(tokenHash),
+			},
+		},
+		ExpressionAttributeNames: expr.Names(),
+		ConditionExpression:      expr.Condition(),
+	})
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrPasswordResetNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/common"
+	"lambda-func/types"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+var ErrRefreshTokenReused = errors.New("refresh token already rotated")
+
+type RefreshTokenStore interface {
+	InsertRefreshToken(token types.RefreshToken) error
+	GetRefreshToken(tokenHash string) (types.RefreshToken, error)
+	// RotateRefreshToken marks a token as used and fails with ErrRefreshTokenReused
+	// if it was already rotated or revoked.
+	RotateRefreshToken(tokenHash string) error
+	RevokeRefreshTokenFamily(familyId string) error
+}
+
+func (u DynamoDBClient) InsertRefreshToken(token types.RefreshToken) error {
+	item, err := dynamodbattribute.MarshalMap(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.databaseStore.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(common.RefreshTokenTableName),
+		Item:      item,
+	})
+
+	return err
+}
+
+func (u DynamoDBClient) GetRefreshToken(tokenHash string) (types.RefreshToken, error) {
+	var token types.RefreshToken
+
+	result, err := u.databaseStore.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(common.RefreshTokenTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"tokenHash": {
+				S: aws.String(tokenHash),
+			},
+		},
+	})
+
+	if err != nil {
+		return token, err
+	}
+
+	if result.Item == nil {
+		return token, ErrRefreshTokenNotFound
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Item, &token)
+	if err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+func (u DynamoDBClient) RotateRefreshToken(tokenHash string) error {
+	update := expression.Set(expression.Name("rotated"), expression.Value(true))
+	condition := expression.Name("rotated").Equal(expression.Value(false)).
+		And(expression.Name("revoked").Equal(expression.Value(false)))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = u.databaseStore.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(common.RefreshTokenTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"tokenHash": {
+				S: aws.String(tokenHash),
+			},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	})
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrRefreshTokenReused
+	}
+
+	return err
+}
+
+func (u DynamoDBClient) RevokeRefreshTokenFamily(familyId string) error {
+	keyCondition := expression.Key("familyId").Equal(expression.Value(familyId))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCondition).Build()
+
+	if err != nil {
+		return err
+	}
+
+	var tokenHashes []string
+	err = u.databaseStore.QueryPages(&dynamodb.QueryInput{
+		TableName:                 aws.String(common.RefreshTokenTableName),
+		IndexName:                 aws.String(common.RefreshTokenFamilyIndex),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if hash := item["tokenHash"]; hash != nil && hash.S != nil {
+				tokenHashes = append(tokenHashes, *hash.S)
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, tokenHash := range tokenHashes {
+		// a token the TTL removed since the query is gone already, revoking it must not bring it back
+		update := expression.Set(expression.Name("revoked"), expression.Value(true))
+		condition := expression.AttributeExists(expression.Name("tokenHash"))
+		expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+
+		if err != nil {
+			return err
+		}
+
+		_, err = u.databaseStore.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(common.RefreshTokenTableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"tokenHash": {
+					S: aws.String(tokenHash),
+				},
+			},
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+		})
+
+		if err != nil && !isConditionalCheckFailed(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// refreshTokenTable answers the family query with every hash and fails the conditional
+// update of the hashes that expired since.
+type refreshTokenTable struct {
+	dynamodbiface.DynamoDBAPI
+	family  []string
+	expired map[string]bool
+	updates []*dynamodb.UpdateItemInput
+}
+
+func (r *refreshTokenTable) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	var items []map[string]*dynamodb.AttributeValue
+	for _, tokenHash := range r.family {
+		items = append(items, map[string]*dynamodb.AttributeValue{"tokenHash": {S: aws.String(tokenHash)}})
+	}
+	fn(&dynamodb.QueryOutput{Items: items}, true)
+	return nil
+}
+
+func (r *refreshTokenTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	r.updates = append(r.updates, input)
+	if r.expired[*input.Key["tokenHash"].S] {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestRevokeRefreshTokenFamilySkipsExpiredTokens(t *testing.T) {
+	table := &refreshTokenTable{
+		family:  []string{"first", "second", "third"},
+		expired: map[string]bool{"second": true},
+	}
+	store := DynamoDBClient{databaseStore: table}
+
+	err := store.RevokeRefreshTokenFamily("family")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(table.updates) != 3 {
+		t.Fatalf("expected every token of the family revoked, got %d updates", len(table.updates))
+	}
+	for _, update := range table.updates {
+		if update.ConditionExpression == nil {
+			t.Errorf("revoking %s would create it if it expired meanwhile", *update.Key["tokenHash"].S)
+		}
+	}
+}
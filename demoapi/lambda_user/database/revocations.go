@@ -0,0 +1,59 @@
+package database
+
+import (
+	"lambda-func/common"
+	"shared/auth"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// RevocationStore tracks access tokens that must be refused before they expire.
+// Sessions are keyed by their sid claim; revoking a user stores a cut-off time
+// so every token issued to them before it is refused.
+type RevocationStore interface {
+	RevokeSession(sessionId string, expiresAt int64) error
+	RevokeUserTokens(username string) error
+	// IsRevoked reports whether a token is revoked; issuedAt is in unix milliseconds.
+	IsRevoked(sessionId string, username string, issuedAt int64) (bool, error)
+}
+
+func (u DynamoDBClient) RevokeSession(sessionId string, expiresAt int64) error {
+	_, err := u.databaseStore.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(common.RevokedTokenTableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(auth.SessionRevocationKey(sessionId)),
+			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(expiresAt, 10)),
+			},
+		},
+	})
+
+	return err
+}
+
+func (u DynamoDBClient) RevokeUserTokens(username string) error {
+	now := time.Now()
+
+	// the cut-off only has to outlive the longest lived token issued before it
+	_, err := u.databaseStore.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(common.RevokedTokenTableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(auth.UserRevocationKey(username)),
+			},
+			"revokedBefore": {
+				N: aws.String(strconv.FormatInt(now.UnixMilli(), 10)),
+			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(now.Add(common.RefreshTokenTTL).Unix(), 10)),
+			},
+		},
+	})
+
+	return err
+}
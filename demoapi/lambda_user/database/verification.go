@@ -0,0 +1,23 @@
+package database
+
+import (
+	"errors"
+	"lambda-func/types"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+var ErrEmailNotPending = errors.New("email is not waiting for verification")
+
+func (u DynamoDBClient) VerifyEmail(username string, email string) error {
+	update := expression.Set(expression.Name("status"), expression.Value(types.UserStatusActive))
+	condition := expression.Name("status").Equal(expression.Value(types.UserStatusUnverified)).
+		And(expression.Name("email").Equal(expression.Value(email)))
+
+	err := u.updateUserItem(username, update, condition)
+	if isConditionalCheckFailed(err) {
+		return ErrEmailNotPending
+	}
+
+	return err
+}
@@ -0,0 +1,116 @@
+package lockout
+
+import (
+	"fmt"
+	"lambda-func/common"
+	"lambda-func/types"
+	"strings"
+	"time"
+)
+
+const userKeyPrefix = "user#"
+const ipKeyPrefix = "ip#"
+
+// Policy decides when failed logins lock a username or a source IP out, and for how long.
+// Each lock in a row lasts twice as long as the one before, up to MaxLockout.
+type Policy struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Window        time.Duration
+	BaseLockout   time.Duration
+	MaxLockout    time.Duration
+}
+
+func NewPolicy(maxFailures int, maxIPFailures int, window time.Duration, baseLockout time.Duration, maxLockout time.Duration) (*Policy, error) {
+	if maxFailures < 1 || maxIPFailures < 1 {
+		return nil, fmt.Errorf("failure limits %d and %d must be at least 1", maxFailures, maxIPFailures)
+	}
+	if window <= 0 || baseLockout <= 0 {
+		return nil, fmt.Errorf("failure window %s and lockout %s must be positive", window, baseLockout)
+	}
+	// the attempt record has to outlive the lock it carries
+	if maxLockout < baseLockout || maxLockout > common.LoginAttemptTTL {
+		return nil, fmt.Errorf("max lockout %s is outside %s-%s", maxLockout, baseLockout, common.LoginAttemptTTL)
+	}
+
+	return &Policy{
+		MaxFailures:   maxFailures,
+		MaxIPFailures: maxIPFailures,
+		Window:        window,
+		BaseLockout:   baseLockout,
+		MaxLockout:    maxLockout,
+	}, nil
+}
+
+func UserKey(username string) string {
+	return userKeyPrefix + username
+}
+
+func IPKey(sourceIP string) string {
+	return ipKeyPrefix + sourceIP
+}
+
+// Keys returns the keys a login of username from sourceIP counts against.
+func Keys(username string, sourceIP string) []string {
+	keys := []string{UserKey(username)}
+	if sourceIP != "" {
+		keys = append(keys, IPKey(sourceIP))
+	}
+	return keys
+}
+
+// Describe turns a key back into "user alice" or "ip 192.0.2.1" for messages.
+func Describe(key string) string {
+	if username, ok := strings.CutPrefix(key, userKeyPrefix); ok {
+		return "user " + username
+	}
+	if sourceIP, ok := strings.CutPrefix(key, ipKeyPrefix); ok {
+		return "ip " + sourceIP
+	}
+	return key
+}
+
+// RetryAfter returns how long the longest of the locks still lasts at now, or zero.
+func RetryAfter(attempts []types.LoginAttempts, now time.Time) time.Duration {
+	var longest time.Duration
+	for _, attempt := range attempts {
+		if lockedFor := attempt.LockedFor(now); lockedFor > longest {
+			longest = lockedFor
+		}
+	}
+	return longest
+}
+
+// Lock returns attempts locked from now on if they reached the failure limit of their key.
+func (p *Policy) Lock(attempts types.LoginAttempts, now time.Time) (types.LoginAttempts, bool) {
+	maxFailures := p.MaxFailures
+	if strings.HasPrefix(attempts.Key, ipKeyPrefix) {
+		// addresses are shared behind NAT, so they get more room than a single account
+		maxFailures = p.MaxIPFailures
+	}
+	if attempts.Failures < maxFailures {
+		return attempts, false
+	}
+
+	// the backoff starts over once the last lock is long forgotten
+	lockouts := attempts.Lockouts
+	if now.Sub(time.Unix(attempts.LockedUntil, 0)) > p.MaxLockout {
+		lockouts = 0
+	}
+	lockouts++
+
+	duration := p.BaseLockout
+	for i := 1; i < lockouts && duration < p.MaxLockout; i++ {
+		duration *= 2
+	}
+	if duration > p.MaxLockout {
+		duration = p.MaxLockout
+	}
+
+	return types.LoginAttempts{
+		Key:         attempts.Key,
+		Lockouts:    lockouts,
+		LockedUntil: now.Add(duration).Unix(),
+		ExpiresAt:   now.Add(common.LoginAttemptTTL).Unix(),
+	}, true
+}
@@ -0,0 +1,81 @@
+package lockout
+
+import (
+	"lambda-func/types"
+	"testing"
+	"time"
+)
+
+func TestPolicyLock(t *testing.T) {
+	policy, err := NewPolicy(3, 5, time.Minute, time.Minute, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name         string
+		attempts     types.LoginAttempts
+		wantLocked   bool
+		wantDuration time.Duration
+		wantLockouts int
+	}{
+		{name: "below the limit", attempts: types.LoginAttempts{Key: UserKey("alice"), Failures: 2}},
+		{name: "first lock", attempts: types.LoginAttempts{Key: UserKey("alice"), Failures: 3}, wantLocked: true, wantDuration: time.Minute, wantLockouts: 1},
+		{name: "backoff doubles", attempts: types.LoginAttempts{Key: UserKey("alice"), Failures: 3, Lockouts: 2, LockedUntil: now.Add(-time.Minute).Unix()}, wantLocked: true, wantDuration: 4 * time.Minute, wantLockouts: 3},
+		{name: "backoff is capped", attempts: types.LoginAttempts{Key: UserKey("alice"), Failures: 3, Lockouts: 10, LockedUntil: now.Add(-time.Minute).Unix()}, wantLocked: true, wantDuration: 5 * time.Minute, wantLockouts: 11},
+		{name: "backoff starts over", attempts: types.LoginAttempts{Key: UserKey("alice"), Failures: 3, Lockouts: 4, LockedUntil: now.Add(-time.Hour).Unix()}, wantLocked: true, wantDuration: time.Minute, wantLockouts: 1},
+		{name: "addresses get more room", attempts: types.LoginAttempts{Key: IPKey("192.0.2.1"), Failures: 4}},
+		{name: "address lock", attempts: types.LoginAttempts{Key: IPKey("192.0.2.1"), Failures: 5}, wantLocked: true, wantDuration: time.Minute, wantLockouts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locked, ok := policy.Lock(tt.attempts, now)
+
+			if ok != tt.wantLocked {
+				t.Fatalf("Lock locked = %v, want %v", ok, tt.wantLocked)
+			}
+			if !ok {
+				return
+			}
+			if locked.Key != tt.attempts.Key || locked.Failures != 0 || locked.Lockouts != tt.wantLockouts {
+				t.Errorf("locked = %+v, want %d lockouts and the failures reset", locked, tt.wantLockouts)
+			}
+			if got := locked.LockedFor(now); got != tt.wantDuration {
+				t.Errorf("locked for %s, want %s", got, tt.wantDuration)
+			}
+			if locked.ExpiresAt <= locked.LockedUntil {
+				t.Errorf("record expires at %d, before the lock ends at %d", locked.ExpiresAt, locked.LockedUntil)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	attempts := []types.LoginAttempts{
+		{Key: UserKey("alice"), LockedUntil: now.Add(time.Minute).Unix()},
+		{Key: IPKey("192.0.2.1"), LockedUntil: now.Add(3 * time.Minute).Unix()},
+		{Key: UserKey("bob"), LockedUntil: now.Add(-time.Minute).Unix()},
+	}
+
+	if got := RetryAfter(attempts, now); got != 3*time.Minute {
+		t.Errorf("RetryAfter = %s, want %s", got, 3*time.Minute)
+	}
+	if got := RetryAfter(attempts[2:], now); got != 0 {
+		t.Errorf("RetryAfter of an expired lock = %s, want 0", got)
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	if _, err := NewPolicy(0, 5, time.Minute, time.Minute, time.Hour); err == nil {
+		t.Error("a failure limit of 0 was accepted")
+	}
+	if _, err := NewPolicy(3, 5, time.Minute, time.Hour, time.Minute); err == nil {
+		t.Error("a max lockout below the first lockout was accepted")
+	}
+	if _, err := NewPolicy(3, 5, time.Minute, time.Minute, 48*time.Hour); err == nil {
+		t.Error("a max lockout outliving the attempt record was accepted")
+	}
+}
@@ -1,36 +1,68 @@
 package main
 
 import (
+	"flag"
 	"lambda-func/app"
-	"lambda-func/middleware"
+	"lambda-func/common"
+	"log"
 	"net/http"
+	"os"
+	"shared/auth"
+	"shared/router"
+	"shared/server"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
 func main() {
-	lambdaApp := app.NewApp()
-	lambda.Start(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-		switch request.Path {
-		case "/register":
-			return lambdaApp.ApiHandler.RegisterUser(request)
-		case "/login":
-			return lambdaApp.ApiHandler.LoginUser(request)
-		case "/me":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.GetUser)(request)
-		case "/role":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.UpdateRole)(request)
-		case "/list":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.ListUsers)(request)
-		case "/remove":
-			return middleware.ValidateJWTMiddleware(lambdaApp.ApiHandler.RemoveUser)(request)
-		default:
-			return events.APIGatewayProxyResponse{
-				Body:       "Not found",
-				StatusCode: http.StatusNotFound,
-			}, nil
-		}
-
-	})
+	localMode := flag.Bool("local", os.Getenv(common.LocalModeEnv) == "true", "serve the API over plain HTTP instead of running as a lambda")
+	localAddr := flag.String("addr", envOrDefault(common.LocalAddrEnv, ":8080"), "listen address in local mode")
+	flag.Parse()
+
+	lambdaApp := app.NewApp(app.ConfigFromEnv())
+	handler := newHandler(lambdaApp)
+
+	if *localMode {
+		log.Fatal(server.ListenAndServe(*localAddr, handler))
+	}
+
+	lambda.Start(handler)
+}
+
+func newHandler(lambdaApp app.App) server.Handler {
+	h := lambdaApp.ApiHandler
+	authenticate := lambdaApp.Middleware.Authenticate
+
+	// user management runs behind the caller's permissions, and behind a second factor when the policy asks for it
+	readUsers := []router.Middleware{authenticate, lambdaApp.Roles.RequirePermission(auth.PermissionUsersRead), h.RequireMfa(auth.PermissionUsersRead)}
+	writeUsers := []router.Middleware{authenticate, lambdaApp.Roles.RequirePermission(auth.PermissionUsersWrite), h.RequireMfa(auth.PermissionUsersWrite)}
+
+	r := router.New()
+	r.Handle(http.MethodPost, "/register", h.RegisterUser)
+	r.Handle(http.MethodPost, "/verify", h.VerifyEmail)
+	r.Handle(http.MethodPost, "/verify/resend", h.ResendVerification)
+	r.Handle(http.MethodPost, "/login", h.LoginUser)
+	r.Handle(http.MethodPost, "/login/mfa", h.LoginMfa)
+	r.Handle(http.MethodPost, "/refresh", h.RefreshToken)
+	r.Handle(http.MethodGet, "/.well-known/jwks.json", h.JWKS)
+	r.Handle(http.MethodPost, "/password/reset", h.ResetPassword)
+	r.Handle(http.MethodPost, "/password/reset-token", auth.WithUser(h.IssuePasswordReset), writeUsers...)
+	r.Handle(http.MethodPost, "/password", auth.WithUser(h.ChangePassword), authenticate)
+	r.Handle(http.MethodPost, "/mfa/setup", auth.WithUser(h.SetupMfa), authenticate)
+	r.Handle(http.MethodPost, "/mfa/verify", auth.WithUser(h.VerifyMfa), authenticate)
+	r.Handle(http.MethodPost, "/logout", auth.WithUser(h.Logout), authenticate)
+	r.Handle(http.MethodGet, "/me", auth.WithUser(h.GetUser), authenticate)
+	r.Handle(http.MethodPut, "/role", auth.WithUser(h.UpdateRole), writeUsers...)
+	r.Handle(http.MethodGet, "/list", auth.WithUser(h.ListUsers), readUsers...)
+	r.Handle(http.MethodPost, "/unlock", auth.WithUser(h.UnlockUser), writeUsers...)
+	r.Handle(http.MethodDelete, "/remove", auth.WithUser(h.RemoveUser), writeUsers...)
+
+	return r.Serve
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"lambda-func/app"
+	"lambda-func/common"
+	"lambda-func/signing"
+	"lambda-func/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shared/auth"
+	"shared/server"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type testApp struct {
+	handler server.Handler
+	key     signing.Key
+}
+
+// newTestApp runs the real routes on the memory backends, signing with a key the test can mint tokens with.
+func newTestApp(t *testing.T, configure func(config *app.Config)) testApp {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := app.ConfigFromEnv()
+	config.StoreBackend = common.BackendMemory
+	config.QueueBackend = common.BackendMemory
+	config.NotifyBackend = common.BackendMemory
+	config.SigningKeyFile = keyFile
+	config.PasswordHashCost = 4
+	if configure != nil {
+		configure(&config)
+	}
+
+	keys, err := signing.NewFileKeySet(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keys.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testApp{
+		handler: newHandler(app.NewApp(config)),
+		key:     key,
+	}
+}
+
+// call sends a request as role, or anonymously when role is empty.
+func (a testApp) call(t *testing.T, method string, path string, role string, mfa bool, body string) events.APIGatewayProxyResponse {
+	t.Helper()
+
+	request := events.APIGatewayProxyRequest{HTTPMethod: method, Path: path, Body: body, Headers: map[string]string{}}
+	if role != "" {
+		authMethods := []string{auth.AuthMethodPassword}
+		if mfa {
+			authMethods = append(authMethods, auth.AuthMethodOTP)
+		}
+
+		token, err := types.CreateToken(types.User{Username: "caller", Role: role}, "session-1", auth.UserApiAudience, authMethods, a.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Headers["Authorization"] = "Bearer " + token
+	}
+
+	response, err := a.handler(request)
+	if err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	return response
+}
+
+func TestRoutes(t *testing.T) {
+	testApp := newTestApp(t, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		role       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{name: "public", method: http.MethodGet, path: "/.well-known/jwks.json", wantStatus: http.StatusOK},
+		{name: "anonymous", method: http.MethodGet, path: "/list", wantStatus: http.StatusUnauthorized},
+		{name: "read without permission", method: http.MethodGet, path: "/list", role: auth.RoleUser, wantStatus: http.StatusForbidden},
+		{name: "read as support", method: http.MethodGet, path: "/list", role: auth.RoleSupport, wantStatus: http.StatusOK},
+		{name: "read as admin", method: http.MethodGet, path: "/list", role: auth.RoleAdmin, wantStatus: http.StatusOK},
+		{name: "role change as support", method: http.MethodPut, path: "/role", role: auth.RoleSupport, wantStatus: http.StatusForbidden},
+		{name: "remove as user", method: http.MethodDelete, path: "/remove", role: auth.RoleUser, wantStatus: http.StatusForbidden},
+		{name: "unlock as product manager", method: http.MethodPost, path: "/unlock", role: auth.RoleProductManager, wantStatus: http.StatusForbidden},
+		{name: "reset token as support", method: http.MethodPost, path: "/password/reset-token", role: auth.RoleSupport, wantStatus: http.StatusForbidden},
+		{name: "own account", method: http.MethodGet, path: "/me", role: auth.RoleUser, wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodGet, path: "/role", role: auth.RoleAdmin, wantStatus: http.StatusMethodNotAllowed, wantAllow: http.MethodPut},
+		{name: "wrong method on public route", method: http.MethodGet, path: "/login", wantStatus: http.StatusMethodNotAllowed, wantAllow: http.MethodPost},
+		{name: "unknown path", method: http.MethodGet, path: "/nope", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := testApp.call(t, tt.method, tt.path, tt.role, false, "")
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if response.Headers["Allow"] != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", response.Headers["Allow"], tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestRoutesRequireMfa(t *testing.T) {
+	testApp := newTestApp(t, func(config *app.Config) {
+		config.MfaRequiredForAdmins = true
+	})
+
+	tests := []struct {
+		name       string
+		role       string
+		mfa        bool
+		wantStatus int
+	}{
+		{name: "admin with password only", role: auth.RoleAdmin, wantStatus: http.StatusForbidden},
+		{name: "admin with second factor", role: auth.RoleAdmin, mfa: true, wantStatus: http.StatusOK},
+		{name: "support with password only", role: auth.RoleSupport, wantStatus: http.StatusForbidden},
+		{name: "support with second factor", role: auth.RoleSupport, mfa: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := testApp.call(t, http.MethodGet, "/list", tt.role, tt.mfa, "")
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+		})
+	}
+}
+
+func TestRolesFile(t *testing.T) {
+	rolesFile := filepath.Join(t.TempDir(), "roles.json")
+	err := os.WriteFile(rolesFile, []byte(`{"auditor": ["users:read"]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testApp := newTestApp(t, func(config *app.Config) {
+		config.RolesFile = rolesFile
+	})
+
+	response := testApp.call(t, http.MethodPost, "/register", "", false, `{"username":"alice","password":"Correct-Horse-42"}`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("register status = %d (body %q)", response.StatusCode, response.Body)
+	}
+
+	if response := testApp.call(t, http.MethodGet, "/list", "auditor", false, ""); response.StatusCode != http.StatusOK {
+		t.Errorf("list as auditor = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	if response := testApp.call(t, http.MethodPut, "/role", auth.RoleAdmin, false, `{"username":"alice","newrole":"auditor"}`); response.StatusCode != http.StatusOK {
+		t.Errorf("assigning a defined role = %d, want %d (body %q)", response.StatusCode, http.StatusOK, response.Body)
+	}
+	if response := testApp.call(t, http.MethodPut, "/role", auth.RoleAdmin, false, `{"username":"alice","newrole":"owner"}`); response.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("assigning an undefined role = %d, want %d", response.StatusCode, http.StatusUnprocessableEntity)
+	}
+}
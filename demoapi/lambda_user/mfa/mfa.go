@@ -0,0 +1,129 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const secretSize = 20
+const period = 30 * time.Second
+const digits = 6
+const recoveryCodeCount = 10
+
+// codes from the step before and after the current one are accepted for clock drift
+const skewSteps = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Policy holds the TOTP settings (RFC 6238: SHA-1, 6 digits, 30 second steps,
+// as authenticator apps expect) and whether roles that manage users have to use a second factor.
+type Policy struct {
+	Issuer           string
+	RequireForAdmins bool
+}
+
+func NewPolicy(issuer string, requireForAdmins bool) *Policy {
+	return &Policy{
+		Issuer:           issuer,
+		RequireForAdmins: requireForAdmins,
+	}
+}
+
+// NewSecret returns a random base32 secret for an authenticator app.
+func (p *Policy) NewSecret() (string, error) {
+	buf := make([]byte, secretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// URI returns the otpauth URI authenticator apps read from a QR code.
+func (p *Policy) URI(username string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", p.Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(int(period.Seconds())))
+
+	label := url.PathEscape(p.Issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Verify checks code against secret at now and returns the time step it belongs to.
+// Callers store the step and refuse steps up to it, so each code works only once.
+func (p *Policy) Verify(secret string, code string, now time.Time) (int64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != digits {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(period.Seconds())
+	for step := current - skewSteps; step <= current+skewSteps; step++ {
+		if subtle.ConstantTimeCompare([]byte(generate(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// Code returns the code for secret at t, as an authenticator app shows it.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return generate(key, t.Unix()/int64(period.Seconds())), nil
+}
+
+// generate is the HOTP value (RFC 4226) of key for counter.
+func generate(key []byte, counter int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
+
+// NewRecoveryCodes returns single-use codes for the user and the hashes to store.
+func (p *Policy) NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, HashRecoveryCode(raw))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code the way it is stored, ignoring case, dashes and spaces.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
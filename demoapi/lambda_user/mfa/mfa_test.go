@@ -0,0 +1,102 @@
+package mfa
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA-1 test secret of RFC 6238, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the last six digits of the RFC 6238 appendix B values
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range tests {
+		got, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Errorf("Code at %d = %q, %v, want %q", unix, got, err, want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	policy := NewPolicy("JITestDemo", false)
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOk   bool
+	}{
+		{name: "current step", code: "050471", wantStep: 37037037, wantOk: true},
+		{name: "previous step", code: "081804", wantStep: 37037036, wantOk: true},
+		{name: "two steps ago", code: "287082"},
+		{name: "wrong code", code: "123456"},
+		{name: "too short", code: "05047"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := policy.Verify(rfcSecret, tt.code, now)
+			if ok != tt.wantOk || (ok && step != tt.wantStep) {
+				t.Errorf("Verify = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	policy := NewPolicy("JITestDemo", false)
+
+	secret, err := policy.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := Code(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Code with a new secret: %v", err)
+	}
+	if _, ok := policy.Verify(secret, code, time.Now()); !ok {
+		t.Error("a code of the new secret does not verify")
+	}
+
+	uri, err := url.Parse(policy.URI("alice", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/JITestDemo:alice" || uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "JITestDemo" {
+		t.Errorf("URI = %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewPolicy("JITestDemo", false).NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("code %q is malformed or repeated", code)
+		}
+		seen[code] = true
+		if HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))) != hashes[i] {
+			t.Errorf("hash of %q does not ignore case and separators", code)
+		}
+	}
+}
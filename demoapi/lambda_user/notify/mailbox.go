@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"log"
+	"sync"
+)
+
+// Mailbox is a Notifier that stands in for an SMTP server in local runs: it logs
+// every message the way a mail would look and keeps it in process memory.
+type Mailbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMailbox() *Mailbox {
+	return &Mailbox{}
+}
+
+func (m *Mailbox) Notify(message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Printf("mail to %s\nSubject: %s\n\n%s", message.To, message.Subject, message.Body)
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *Mailbox) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
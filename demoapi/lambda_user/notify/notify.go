@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"lambda-func/common"
+	"lambda-func/queue"
+)
+
+// Message is an email to a user. Delivering it is up to the Notifier.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type Notifier interface {
+	Notify(message Message) error
+}
+
+// QueueNotifier hands messages to the queue as JSON, for a mail sender consuming it.
+type QueueNotifier struct {
+	msgQueue queue.MessageQueue
+}
+
+func NewQueueNotifier(msgQueue queue.MessageQueue) QueueNotifier {
+	return QueueNotifier{
+		msgQueue: msgQueue,
+	}
+}
+
+func (n QueueNotifier) Notify(message Message) error {
+	type emailMessage struct {
+		Type string `json:"type"`
+		Message
+	}
+
+	body, err := json.Marshal(emailMessage{Type: "email", Message: message})
+	if err != nil {
+		return err
+	}
+
+	return n.msgQueue.SendMessage(string(body))
+}
+
+// VerificationEmail is the message that carries an email verification token to username.
+func VerificationEmail(username string, email string, token string) Message {
+	return Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nplease confirm your email address by sending this token to /verify within %d hours:\n\n%s\n",
+			username, int(common.EmailVerificationTTL.Hours()), token),
+	}
+}
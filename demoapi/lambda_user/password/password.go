@@ -0,0 +1,121 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed denylist.txt
+var denylistFile string
+
+var ErrTooCommon = errors.New("is too common")
+var ErrContainsUsername = errors.New("must not contain the username")
+
+// Policy decides which passwords are accepted and how they are hashed.
+type Policy struct {
+	MinLength  int
+	MinClasses int
+	Cost       int
+
+	denylist map[string]bool
+
+	unknownUserOnce sync.Once
+	unknownUserHash []byte
+}
+
+func NewPolicy(minLength int, minClasses int, cost int) (*Policy, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost %d is outside %d-%d", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if minClasses < 0 || minClasses > 4 {
+		return nil, fmt.Errorf("character classes %d is outside 0-4", minClasses)
+	}
+
+	return &Policy{
+		MinLength:  minLength,
+		MinClasses: minClasses,
+		Cost:       cost,
+		denylist:   parseDenylist(denylistFile),
+	}, nil
+}
+
+func parseDenylist(content string) map[string]bool {
+	denylist := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denylist[strings.ToLower(line)] = true
+	}
+	return denylist
+}
+
+// Check returns the first rule password breaks for the given username, or nil.
+func (p *Policy) Check(username string, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("must be at least %d characters", p.MinLength)
+	}
+
+	if classes(password) < p.MinClasses {
+		return fmt.Errorf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)
+	}
+
+	if p.denylist[strings.ToLower(password)] {
+		return ErrTooCommon
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return ErrContainsUsername
+	}
+
+	return nil
+}
+
+func classes(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+func (p *Policy) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// NeedsRehash reports whether hash was made with a lower cost than the policy asks for.
+func (p *Policy) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < p.Cost
+}
+
+// CompareUnknownUser spends the same bcrypt work as checking a real password,
+// so a login for a user that does not exist cannot be told apart by its timing.
+func (p *Policy) CompareUnknownUser(password string) {
+	p.unknownUserOnce.Do(func() {
+		p.unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), p.Cost)
+	})
+	bcrypt.CompareHashAndPassword(p.unknownUserHash, []byte(password))
+}
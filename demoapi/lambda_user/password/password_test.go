@@ -0,0 +1,76 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy, err := NewPolicy(10, 3, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+		want     error
+	}{
+		{name: "strong", username: "alice", password: "Tr0ub4dor&3"},
+		{name: "too short", username: "alice", password: "Sh0rt!", wantErr: true},
+		{name: "too few classes", username: "alice", password: "lowercaseonly1", wantErr: true},
+		{name: "length counts characters", username: "alice", password: "Ünïcödé12", wantErr: true},
+		{name: "denylisted", username: "alice", password: "Password1234", want: ErrTooCommon},
+		{name: "denylisted any case", username: "alice", password: "PASSword123", want: ErrTooCommon},
+		{name: "contains username", username: "alice", password: "Hello-Alice-2024", want: ErrContainsUsername},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.username, tt.password)
+
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Check = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	if _, err := NewPolicy(8, 2, bcrypt.MaxCost+1); err == nil {
+		t.Error("cost above bcrypt.MaxCost was accepted")
+	}
+	if _, err := NewPolicy(8, 5, bcrypt.DefaultCost); err == nil {
+		t.Error("more character classes than exist was accepted")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	policy, err := NewPolicy(8, 2, bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weak, _ := bcrypt.GenerateFromPassword([]byte("Tr0ub4dor&3"), bcrypt.MinCost)
+	current, _ := policy.Hash("Tr0ub4dor&3")
+
+	if !policy.NeedsRehash(string(weak)) {
+		t.Error("hash with a lower cost does not need a rehash")
+	}
+	if policy.NeedsRehash(current) {
+		t.Error("hash with the current cost needs a rehash")
+	}
+	if policy.NeedsRehash("not a hash") {
+		t.Error("malformed hash needs a rehash")
+	}
+}
@@ -0,0 +1,32 @@
+package queue
+
+import (
+	"log"
+	"sync"
+)
+
+// MemoryQueue is a MessageQueue that keeps messages in process memory, used for local runs and tests.
+type MemoryQueue struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+func (m *MemoryQueue) SendMessage(message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Printf("queue message: %s", message)
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *MemoryQueue) Messages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.messages...)
+}
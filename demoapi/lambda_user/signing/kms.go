@@ -0,0 +1,132 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// KMSKeySet signs with asymmetric KMS keys, so the private key never leaves KMS.
+// The first key id signs; the others only verify until their tokens have expired.
+// Public keys are fetched on first use and kept for the lifetime of the lambda container.
+type KMSKeySet struct {
+	client *kms.KMS
+	keyIds []string
+
+	mu     sync.Mutex
+	cached []Key
+}
+
+func NewKMSKeySet(keyIds []string) *KMSKeySet {
+	sess := session.Must(session.NewSession())
+
+	return &KMSKeySet{
+		client: kms.New(sess),
+		keyIds: keyIds,
+	}
+}
+
+func (k *KMSKeySet) SigningKey() (Key, error) {
+	keys, err := k.load()
+	if err != nil {
+		return Key{}, err
+	}
+	return keys[0], nil
+}
+
+func (k *KMSKeySet) PublicKeys() (map[string]*rsa.PublicKey, error) {
+	keys, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeys := map[string]*rsa.PublicKey{}
+	for _, key := range keys {
+		publicKeys[key.Id] = key.Signer.Public().(*rsa.PublicKey)
+	}
+	return publicKeys, nil
+}
+
+func (k *KMSKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	return lookupPublicKey(k, kid)
+}
+
+func (k *KMSKeySet) load() ([]Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.cached != nil {
+		return k.cached, nil
+	}
+
+	if len(k.keyIds) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	var keys []Key
+	for _, keyId := range k.keyIds {
+		result, err := k.client.GetPublicKey(&kms.GetPublicKeyInput{
+			KeyId: aws.String(keyId),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("loading public key of %s %w", keyId, err)
+		}
+
+		publicKey, err := x509.ParsePKIXPublicKey(result.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key of %s %w", keyId, err)
+		}
+
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an RSA key", keyId)
+		}
+
+		keys = append(keys, Key{
+			Id: KeyId(rsaKey),
+			Signer: kmsSigner{
+				client:    k.client,
+				keyId:     keyId,
+				publicKey: rsaKey,
+			},
+		})
+	}
+
+	k.cached = keys
+	return k.cached, nil
+}
+
+type kmsSigner struct {
+	client    *kms.KMS
+	keyId     string
+	publicKey *rsa.PublicKey
+}
+
+func (s kmsSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+func (s kmsSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+
+	result, err := s.client.Sign(&kms.SignInput{
+		KeyId:            aws.String(s.keyId),
+		Message:          digest,
+		MessageType:      aws.String(kms.MessageTypeDigest),
+		SigningAlgorithm: aws.String(kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Signature, nil
+}
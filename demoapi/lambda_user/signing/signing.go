@@ -0,0 +1,220 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"shared/auth"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no signing key configured")
+
+// Key signs access tokens; Id is published as the kid header and in the JWKS.
+type Key struct {
+	Id     string
+	Signer crypto.Signer
+}
+
+// KeySet supplies the key that signs new tokens and every public key that may
+// still verify one. Keeping retired keys in the set lets tokens signed before a
+// rotation live out their lifetime.
+type KeySet interface {
+	SigningKey() (Key, error)
+	PublicKeys() (map[string]*rsa.PublicKey, error)
+	PublicKey(kid string) (*rsa.PublicKey, error)
+}
+
+type StaticKeySet struct {
+	keys []Key
+}
+
+// NewStaticKeySet serves fixed RSA keys, the first one signs. Used for local runs and tests.
+func NewStaticKeySet(signers ...crypto.Signer) (StaticKeySet, error) {
+	var keys []Key
+	for _, signer := range signers {
+		publicKey, ok := signer.Public().(*rsa.PublicKey)
+		if !ok {
+			return StaticKeySet{}, fmt.Errorf("signing key must be an RSA key, got %T", signer.Public())
+		}
+		keys = append(keys, Key{Id: KeyId(publicKey), Signer: signer})
+	}
+
+	return StaticKeySet{keys: keys}, nil
+}
+
+// NewFileKeySet reads PEM encoded RSA private keys from a local file, the first one signs.
+func NewFileKeySet(path string) (StaticKeySet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return StaticKeySet{}, fmt.Errorf("reading signing key file %w", err)
+	}
+
+	var signers []crypto.Signer
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+
+		signer, err := parsePrivateKey(block)
+		if err != nil {
+			return StaticKeySet{}, fmt.Errorf("parsing signing key file %w", err)
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return StaticKeySet{}, fmt.Errorf("no PEM private key in %s", path)
+	}
+
+	return NewStaticKeySet(signers...)
+}
+
+// NewEphemeralKeySet generates a throwaway key, so tokens stop verifying once the process exits.
+func NewEphemeralKeySet() (StaticKeySet, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return StaticKeySet{}, err
+	}
+
+	return NewStaticKeySet(privateKey)
+}
+
+func (s StaticKeySet) SigningKey() (Key, error) {
+	if len(s.keys) == 0 {
+		return Key{}, ErrNoSigningKey
+	}
+	return s.keys[0], nil
+}
+
+func (s StaticKeySet) PublicKeys() (map[string]*rsa.PublicKey, error) {
+	publicKeys := map[string]*rsa.PublicKey{}
+	for _, key := range s.keys {
+		publicKeys[key.Id] = key.Signer.Public().(*rsa.PublicKey)
+	}
+	return publicKeys, nil
+}
+
+func (s StaticKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	return lookupPublicKey(s, kid)
+}
+
+func lookupPublicKey(keys KeySet, kid string) (*rsa.PublicKey, error) {
+	publicKeys, err := keys.PublicKeys()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", auth.ErrKeySetUnavailable, err)
+	}
+
+	publicKey, ok := publicKeys[kid]
+	if !ok {
+		return nil, auth.ErrUnknownKey
+	}
+	return publicKey, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key must be an RSA key, got %T", key)
+		}
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+}
+
+// SignToken signs claims with RS256 and sets the kid header of the token.
+func SignToken(key Key, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.Id
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	// signing through crypto.Signer instead of jwt's SignedString lets the key live in KMS
+	digest := sha256.Sum256([]byte(signingString))
+	signature, err := key.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("signing token %w", err)
+	}
+
+	return signingString + "." + token.EncodeSegment(signature), nil
+}
+
+// KeyId is the RFC 7638 thumbprint of the public key.
+func KeyId(publicKey *rsa.PublicKey) string {
+	jwk := NewJSONWebKey("", publicKey)
+	// members in lexicographic order, as the thumbprint requires
+	thumbprint := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+
+	hash := sha256.Sum256([]byte(thumbprint))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func NewJSONWebKey(kid string, publicKey *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// MarshalKeySet renders the public keys of keys as a JWKS document.
+func MarshalKeySet(keys KeySet) ([]byte, error) {
+	publicKeys, err := keys.PublicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kids := make([]string, 0, len(publicKeys))
+	for kid := range publicKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	document := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, kid := range kids {
+		document.Keys = append(document.Keys, NewJSONWebKey(kid, publicKeys[kid]))
+	}
+
+	return json.Marshal(document)
+}
@@ -1,45 +1,124 @@
 package types
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"lambda-func/common"
+	"lambda-func/signing"
+	"shared/auth"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// UserStatusUnverified marks an account whose email address is not confirmed yet.
+// Accounts without a status are active.
+const UserStatusUnverified = "unverified"
+const UserStatusActive = "active"
+
 type RegisterUser struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,min=3,max=32,username"`
+	Password string `json:"password" validate:"required,maxbytes=72"`
+	Email    string `json:"email,omitempty" validate:"max=254,email"`
 }
 
+// User is the stored account. MfaSecret is set by /mfa/setup and only counts
+// once MfaEnabled; recovery codes are stored hashed as a string set.
 type User struct {
-	Username     string `json:"username"`
-	PasswordHash string `json:"password"`
-	Role         string `json:"role"`
-}
-
-type UserContext struct {
-	Username string
-	Role     string
+	Username      string   `json:"username"`
+	PasswordHash  string   `json:"password"`
+	Role          string   `json:"role"`
+	Email         string   `json:"email,omitempty"`
+	Status        string   `json:"status,omitempty"`
+	MfaSecret     string   `json:"mfaSecret,omitempty"`
+	MfaEnabled    bool     `json:"mfaEnabled,omitempty"`
+	MfaLastStep   int64    `json:"mfaLastStep,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty" dynamodbav:"recoveryCodes,stringset,omitempty"`
 }
 
 type UserResponse struct {
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	Email    string `json:"email,omitempty"`
+	Status   string `json:"status,omitempty"`
 }
 
-func NewUser(registerUser RegisterUser) (User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(registerUser.Password), 10)
-	if err != nil {
-		return User{}, err
-	}
+type RefreshToken struct {
+	TokenHash string `json:"tokenHash"`
+	Username  string `json:"username"`
+	FamilyId  string `json:"familyId"`
+	ExpiresAt int64  `json:"expiresAt"`
+	IssuedAt  int64  `json:"issuedAt"`
+	Rotated   bool   `json:"rotated"`
+	Revoked   bool   `json:"revoked"`
+	// Mfa carries the second factor of the login over to refreshed tokens.
+	Mfa bool `json:"mfa,omitempty"`
+}
+
+// PasswordReset is a one-time token an admin hands to a user so they can set a new password.
+// Like refresh tokens, only a hash of the token is stored.
+type PasswordReset struct {
+	TokenHash string `json:"tokenHash"`
+	Username  string `json:"username"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type PasswordResetResponse struct {
+	ResetToken string `json:"reset_token"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// LoginAttempts counts failed logins against a username or a source IP. Key is
+// "user#<username>" or "ip#<address>"; Lockouts drives the backoff of the next lock.
+type LoginAttempts struct {
+	Key         string `json:"id"`
+	Failures    int    `json:"failures"`
+	WindowStart int64  `json:"windowStart"`
+	Lockouts    int    `json:"lockouts"`
+	LockedUntil int64  `json:"lockedUntil"`
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+// MfaChallengeResponse answers a correct password of an account with MFA;
+// the token is exchanged at /login/mfa together with a code.
+type MfaChallengeResponse struct {
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+type MfaSetupResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type MfaRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
 
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ProductToken string `json:"product_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type UserListResponse struct {
+	Items      []UserResponse `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+func NewUser(registerUser RegisterUser, passwordHash string) User {
 	return User{
 		Username:     registerUser.Username,
-		PasswordHash: string(hashedPassword),
-		Role:         common.RoleUser,
-	}, nil
+		PasswordHash: passwordHash,
+		Role:         auth.RoleUser,
+		Email:        registerUser.Email,
+	}
+}
+
+func (u User) IsUnverified() bool {
+	return u.Status == UserStatusUnverified
 }
 
 func ValidatePassword(hashedPassword, plainTextPassword string) bool {
@@ -47,24 +126,142 @@ func ValidatePassword(hashedPassword, plainTextPassword string) bool {
 	return err == nil
 }
 
-func CreateToken(user User) string {
+// CreateToken signs an access token for user that is only accepted by the API named in audience.
+// authMethods end up in the amr claim and tell whether the login used a second factor.
+func CreateToken(user User, sessionId string, audience string, authMethods []string, key signing.Key) (string, error) {
+	return createToken(user, sessionId, audience, authMethods, common.AccessTokenTTL, key)
+}
+
+// CreateMfaChallenge signs the short-lived token that stands for a correct password until the second factor follows.
+func CreateMfaChallenge(user User, key signing.Key) (string, time.Time, error) {
+	challengeId, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, err := createToken(user, challengeId, auth.MfaChallengeAudience, []string{auth.AuthMethodPassword}, common.MfaChallengeTTL, key)
+	return token, time.Now().Add(common.MfaChallengeTTL), err
+}
+
+// CreateEmailVerification signs the token that confirms user owns their email address.
+// The address is part of the token, so it only confirms the address it was sent to.
+func CreateEmailVerification(user User, key signing.Key) (string, error) {
+	verificationId, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := newClaims(user, verificationId, auth.EmailVerificationAudience, nil, common.EmailVerificationTTL)
+	if err != nil {
+		return "", err
+	}
+	claims.Email = user.Email
+
+	return signing.SignToken(key, claims)
+}
+
+func createToken(user User, sessionId string, audience string, authMethods []string, ttl time.Duration, key signing.Key) (string, error) {
+	claims, err := newClaims(user, sessionId, audience, authMethods, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return signing.SignToken(key, claims)
+}
+
+func newClaims(user User, sessionId string, audience string, authMethods []string, ttl time.Duration) (auth.TokenClaims, error) {
 	now := time.Now()
-	validUntil := now.Add(time.Hour * 1).Unix()
 
-	claims := jwt.MapClaims{
-		"user":    user.Username,
-		"role":    user.Role,
-		"expires": validUntil,
+	tokenId, err := common.GenerateRandomToken(16)
+	if err != nil {
+		return auth.TokenClaims{}, err
+	}
+
+	return auth.TokenClaims{
+		Role:        user.Role,
+		SessionId:   sessionId,
+		AuthMethods: authMethods,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId,
+			Issuer:    auth.TokenIssuer,
+			Subject:   user.Username,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}, nil
+}
+
+// NewRefreshToken returns the plain token for the client and the hashed record to store.
+// An empty familyId starts a new token family.
+func NewRefreshToken(username string, familyId string, mfa bool) (string, RefreshToken, error) {
+	plainToken, err := common.GenerateRandomToken(32)
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+
+	if familyId == "" {
+		familyId, err = common.GenerateRandomToken(16)
+		if err != nil {
+			return "", RefreshToken{}, err
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims, nil)
+	return plainToken, RefreshToken{
+		TokenHash: HashRefreshToken(plainToken),
+		Username:  username,
+		FamilyId:  familyId,
+		ExpiresAt: time.Now().Add(common.RefreshTokenTTL).Unix(),
+		IssuedAt:  time.Now().UnixMilli(),
+		Mfa:       mfa,
+	}, nil
+}
 
-	secret := common.TokenSecret
+func HashRefreshToken(plainToken string) string {
+	return hashToken(plainToken)
+}
 
-	tokenString, err := token.SignedString([]byte(secret))
+func (r RefreshToken) IsExpired() bool {
+	return time.Now().Unix() > r.ExpiresAt
+}
+
+// NewPasswordReset returns a reset token for username and the record to store for it.
+func NewPasswordReset(username string) (string, PasswordReset, error) {
+	plainToken, err := common.GenerateRandomToken(32)
 	if err != nil {
-		return ""
+		return "", PasswordReset{}, err
+	}
+
+	return plainToken, PasswordReset{
+		TokenHash: HashPasswordResetToken(plainToken),
+		Username:  username,
+		ExpiresAt: time.Now().Add(common.PasswordResetTTL).Unix(),
+	}, nil
+}
+
+func HashPasswordResetToken(plainToken string) string {
+	return hashToken(plainToken)
+}
+
+func (r PasswordReset) IsExpired() bool {
+	return time.Now().Unix() > r.ExpiresAt
+}
+
+func (a LoginAttempts) IsExpired() bool {
+	return time.Now().Unix() > a.ExpiresAt
+}
+
+// LockedFor returns how much longer the lock lasts at now, or zero when there is none.
+func (a LoginAttempts) LockedFor(now time.Time) time.Duration {
+	remaining := time.Unix(a.LockedUntil, 0).Sub(now)
+	if remaining < 0 {
+		return 0
 	}
+	return remaining
+}
 
-	return tokenString
+func hashToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(hash[:])
 }
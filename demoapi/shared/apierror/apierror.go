@@ -0,0 +1,147 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+type Code string
+
+const (
+	CodeBadRequest   Code = "bad_request"
+	CodeValidation   Code = "validation_failed"
+	CodeUnauthorized Code = "unauthorized"
+	CodeForbidden    Code = "forbidden"
+	CodeNotFound     Code = "not_found"
+	CodeMethod       Code = "method_not_allowed"
+	CodeMediaType    Code = "unsupported_media_type"
+	CodeConflict     Code = "conflict"
+	CodePrecondition Code = "precondition_failed"
+	CodeNoCondition  Code = "precondition_required"
+	CodeTooMany      Code = "too_many_requests"
+	CodeInternal     Code = "internal_error"
+)
+
+// Error is an error the client is meant to see. Anything that is not an *Error
+// is treated as an internal error and never shown to the client.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string
+	// RetryAfter is sent as the Retry-After header when it is set.
+	RetryAfter time.Duration
+	// Allow is sent as the Allow header when it is set.
+	Allow []string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap keeps err as the cause for logs without exposing it to the client.
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// StatusCode is the one place error codes are mapped to HTTP status codes.
+func (c Code) StatusCode() int {
+	switch c {
+	case CodeBadRequest:
+		return http.StatusBadRequest
+	case CodeValidation:
+		return http.StatusUnprocessableEntity
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeMethod:
+		return http.StatusMethodNotAllowed
+	case CodeMediaType:
+		return http.StatusUnsupportedMediaType
+	case CodeConflict:
+		return http.StatusConflict
+	case CodePrecondition:
+		return http.StatusPreconditionFailed
+	case CodeNoCondition:
+		return http.StatusPreconditionRequired
+	case CodeTooMany:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return New(CodeBadRequest, message)
+}
+
+// Validation reports field level problems, keyed by the JSON name of the field.
+func Validation(message string, details map[string]string) *Error {
+	return &Error{Code: CodeValidation, Message: message, Details: details}
+}
+
+func Unauthorized(message string) *Error {
+	return New(CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(CodeNotFound, message)
+}
+
+// MethodNotAllowed names the methods the resource does answer to.
+func MethodNotAllowed(message string, allow []string) *Error {
+	return &Error{Code: CodeMethod, Message: message, Allow: allow}
+}
+
+func UnsupportedMediaType(message string) *Error {
+	return New(CodeMediaType, message)
+}
+
+func Conflict(message string) *Error {
+	return New(CodeConflict, message)
+}
+
+// PreconditionFailed is a conditional request, such as one with If-Match, whose condition does not hold.
+func PreconditionFailed(message string) *Error {
+	return New(CodePrecondition, message)
+}
+
+// PreconditionRequired is a request that has to be conditional but is not.
+func PreconditionRequired(message string) *Error {
+	return New(CodeNoCondition, message)
+}
+
+// TooManyRequests tells the client to back off for retryAfter before trying again.
+func TooManyRequests(message string, retryAfter time.Duration) *Error {
+	return &Error{Code: CodeTooMany, Message: message, RetryAfter: retryAfter}
+}
+
+// From returns the client facing error in err's chain, or an internal error if there is none.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return &Error{Code: CodeInternal, Message: "Internal server error", Err: err}
+}
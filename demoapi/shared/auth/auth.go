@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const RoleUser = "user"
+const RoleAdmin = "admin"
+
+const TokenIssuer = "jitestdemo-user-api"
+const UserApiAudience = "jitestdemo-user-api"
+const ProductApiAudience = "jitestdemo-product-api"
+const MfaChallengeAudience = "jitestdemo-mfa-challenge"
+const EmailVerificationAudience = "jitestdemo-email-verification"
+const TokenLeeway = 30 * time.Second
+
+// authentication methods listed in the amr claim (RFC 8176)
+const AuthMethodPassword = "pwd"
+const AuthMethodOTP = "otp"
+
+func init() {
+	// revocation cut-offs are kept in milliseconds, so every service that mints or reads
+	// tokens must keep iat in milliseconds too instead of jwt's default whole seconds
+	jwt.TimePrecision = time.Millisecond
+}
+
+var ErrUnknownKey = errors.New("unknown signing key")
+var ErrKeySetUnavailable = errors.New("key set unavailable")
+var ErrMissingUserContext = errors.New("user context is missing")
+
+// UserContext is the caller identity the middleware hands to protected handlers.
+type UserContext struct {
+	Username  string
+	Role      string
+	SessionId string
+	// Mfa is set when the session was started with a second factor.
+	Mfa bool
+}
+
+// TokenClaims are the claims of an access token. The subject is the username
+// and the session id is the refresh token family the token was issued from.
+// Email is only set on email verification tokens.
+type TokenClaims struct {
+	Role        string   `json:"role"`
+	SessionId   string   `json:"sid"`
+	AuthMethods []string `json:"amr,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// KeyResolver returns the public key named by the kid header of a token.
+type KeyResolver interface {
+	PublicKey(kid string) (*rsa.PublicKey, error)
+}
+
+// RevocationChecker reports whether a token was revoked; issuedAt is in unix milliseconds.
+type RevocationChecker interface {
+	IsRevoked(sessionId string, username string, issuedAt int64) (bool, error)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"shared/apierror"
+	"shared/response"
+	"shared/router"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Middleware authenticates requests with access tokens minted by the user service.
+// Each API only accepts tokens issued for its own audience.
+type Middleware struct {
+	keys        KeyResolver
+	revocations RevocationChecker
+	audience    string
+}
+
+func NewMiddleware(keys KeyResolver, revocations RevocationChecker, audience string) Middleware {
+	return Middleware{
+		keys:        keys,
+		revocations: revocations,
+		audience:    audience,
+	}
+}
+
+// ContextHandler is a handler that needs to know who is calling.
+type ContextHandler func(request events.APIGatewayProxyRequest, userContext UserContext) (events.APIGatewayProxyResponse, error)
+
+// Authenticate is a route middleware that checks the access token of a request and
+// hands the caller on in the authorizer context of the request, see UserContextFrom.
+func (m Middleware) Authenticate(next router.Handler) router.Handler {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+		tokenString := extractTokenFromHeaders(request.Headers)
+		if tokenString == "" {
+			return response.Error(request, apierror.Unauthorized("Missing Auth token"))
+		}
+
+		claims, err := ParseToken(tokenString, m.keys, m.audience)
+		if errors.Is(err, ErrKeySetUnavailable) {
+			return response.Error(request, err)
+		}
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return response.Error(request, apierror.Unauthorized("token expired"))
+		}
+		if err != nil {
+			return response.Error(request, apierror.Unauthorized("User Unauthorized"))
+		}
+
+		userContext := UserContext{
+			Username:  claims.Subject,
+			Role:      claims.Role,
+			SessionId: claims.SessionId,
+			Mfa:       slices.Contains(claims.AuthMethods, AuthMethodOTP),
+		}
+
+		// has this token been revoked by a logout or an account change
+		revoked, err := m.revocations.IsRevoked(userContext.SessionId, userContext.Username, claims.IssuedAt.UnixMilli())
+		if err != nil {
+			return response.Error(request, err)
+		}
+
+		if revoked {
+			return response.Error(request, apierror.Unauthorized("token revoked"))
+		}
+
+		return next(WithUserContext(request, userContext))
+	}
+}
+
+// ValidateJWTMiddleware authenticates the request and calls next with the caller.
+func (m Middleware) ValidateJWTMiddleware(next ContextHandler) router.Handler {
+	return m.Authenticate(WithUser(next))
+}
+
+// WithUser adapts a handler that takes the caller to a route handler behind Authenticate.
+func WithUser(next ContextHandler) router.Handler {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return next(request, UserContextFrom(request))
+	}
+}
+
+// UserContextFrom returns the caller Authenticate found, or an empty context for anonymous requests.
+func UserContextFrom(request events.APIGatewayProxyRequest) UserContext {
+	authorizer := request.RequestContext.Authorizer
+
+	username, _ := authorizer["username"].(string)
+	role, _ := authorizer["role"].(string)
+	sessionId, _ := authorizer["sessionId"].(string)
+	mfa, _ := authorizer["mfa"].(bool)
+
+	return UserContext{
+		Username:  username,
+		Role:      role,
+		SessionId: sessionId,
+		Mfa:       mfa,
+	}
+}
+
+// WithUserContext returns request carrying userContext the way Authenticate hands it on.
+func WithUserContext(request events.APIGatewayProxyRequest, userContext UserContext) events.APIGatewayProxyRequest {
+	authorizer := map[string]interface{}{}
+	for key, value := range request.RequestContext.Authorizer {
+		authorizer[key] = value
+	}
+
+	authorizer["username"] = userContext.Username
+	authorizer["role"] = userContext.Role
+	authorizer["sessionId"] = userContext.SessionId
+	authorizer["mfa"] = userContext.Mfa
+
+	request.RequestContext.Authorizer = authorizer
+	return request
+}
+
+func extractTokenFromHeaders(headers map[string]string) string {
+	authHeader := router.Header(headers, "Authorization")
+
+	splitToken := strings.Split(authHeader, "Bearer ")
+	if len(splitToken) != 2 {
+		return ""
+	}
+
+	return splitToken[1]
+}
+
+// ParseToken verifies the signature and the registered claims of a token
+// minted by the user service for the given audience.
+func ParseToken(tokenString string, keys KeyResolver, audience string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.PublicKey(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(TokenLeeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject == "" || claims.Role == "" || claims.SessionId == "" || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("token is missing required claims - unauthorized")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/response"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	activeKey  = newTestKey("active")
+	retiredKey = newTestKey("retired")
+	errStore   = errors.New("store unavailable")
+)
+
+type testKey struct {
+	kid        string
+	privateKey *rsa.PrivateKey
+}
+
+func newTestKey(kid string) testKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return testKey{kid: kid, privateKey: privateKey}
+}
+
+type staticKeys map[string]*rsa.PublicKey
+
+func (s staticKeys) PublicKey(kid string) (*rsa.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func newKeySet(keys ...testKey) staticKeys {
+	keySet := staticKeys{}
+	for _, key := range keys {
+		keySet[key.kid] = &key.privateKey.PublicKey
+	}
+	return keySet
+}
+
+type unavailableKeySet struct{}
+
+func (unavailableKeySet) PublicKey(kid string) (*rsa.PublicKey, error) {
+	return nil, fmt.Errorf("%w: connection refused", ErrKeySetUnavailable)
+}
+
+type fakeRevocationStore struct {
+	revoked bool
+	err     error
+}
+
+func (f fakeRevocationStore) IsRevoked(sessionId string, username string, issuedAt int64) (bool, error) {
+	return f.revoked, f.err
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":  "alice",
+		"role": RoleUser,
+		"sid":  "session-1",
+		"iss":  TokenIssuer,
+		"aud":  []string{ProductApiAudience},
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+}
+
+func sign(t *testing.T, key testKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.privateKey)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// signHMAC signs with the public key as an HMAC secret, the classic algorithm confusion attack.
+func signHMAC(t *testing.T, key testKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.privateKey.N.Bytes())
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func withClaim(name string, value interface{}) jwt.MapClaims {
+	claims := validClaims()
+	if value == nil {
+		delete(claims, name)
+	} else {
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestValidateJWTMiddleware(t *testing.T) {
+	now := time.Now()
+	keys := newKeySet(activeKey, retiredKey)
+	issued := sign(t, activeKey, validClaims())
+
+	tests := []struct {
+		name        string
+		header      string
+		headerName  string
+		keys        KeyResolver
+		revocations fakeRevocationStore
+		wantStatus  int
+		wantMessage string
+		wantMfa     bool
+	}{
+		{name: "valid token", header: "Bearer " + issued, wantStatus: http.StatusOK},
+		{name: "lower case header name", header: "Bearer " + issued, headerName: "authorization", wantStatus: http.StatusOK},
+		{name: "missing header", wantStatus: http.StatusUnauthorized, wantMessage: "Missing Auth token"},
+		{name: "not a bearer token", header: "Basic abc", wantStatus: http.StatusUnauthorized, wantMessage: "Missing Auth token"},
+		{name: "garbage token", header: "Bearer not.a.token", wantStatus: http.StatusUnauthorized},
+		{name: "token for another api", header: "Bearer " + sign(t, activeKey, withClaim("aud", []string{UserApiAudience})), wantStatus: http.StatusUnauthorized},
+		{name: "mfa challenge", header: "Bearer " + sign(t, activeKey, withClaim("aud", []string{MfaChallengeAudience})), wantStatus: http.StatusUnauthorized},
+		{name: "second factor", header: "Bearer " + sign(t, activeKey, withClaim("amr", []string{AuthMethodPassword, AuthMethodOTP})), wantStatus: http.StatusOK, wantMfa: true},
+		{name: "wrong issuer", header: "Bearer " + sign(t, activeKey, withClaim("iss", "someone-else")), wantStatus: http.StatusUnauthorized},
+		{name: "retired key", header: "Bearer " + sign(t, retiredKey, validClaims()), wantStatus: http.StatusOK},
+		{name: "unknown key", header: "Bearer " + sign(t, newTestKey("other"), validClaims()), wantStatus: http.StatusUnauthorized},
+		{name: "hmac with public key", header: "Bearer " + signHMAC(t, activeKey), wantStatus: http.StatusUnauthorized},
+		{name: "keys unavailable", header: "Bearer " + issued, keys: unavailableKeySet{}, wantStatus: http.StatusInternalServerError},
+		{name: "expired", header: "Bearer " + sign(t, activeKey, withClaim("exp", now.Add(-time.Minute).Unix())), wantStatus: http.StatusUnauthorized, wantMessage: "token expired"},
+		{name: "expired within leeway", header: "Bearer " + sign(t, activeKey, withClaim("exp", now.Add(-5*time.Second).Unix())), wantStatus: http.StatusOK},
+		{name: "not yet valid", header: "Bearer " + sign(t, activeKey, withClaim("nbf", now.Add(time.Hour).Unix())), wantStatus: http.StatusUnauthorized},
+		{name: "missing expiry", header: "Bearer " + sign(t, activeKey, withClaim("exp", nil)), wantStatus: http.StatusUnauthorized},
+		{name: "missing subject", header: "Bearer " + sign(t, activeKey, withClaim("sub", nil)), wantStatus: http.StatusUnauthorized},
+		{name: "missing session", header: "Bearer " + sign(t, activeKey, withClaim("sid", nil)), wantStatus: http.StatusUnauthorized},
+		{name: "role of wrong type", header: "Bearer " + sign(t, activeKey, withClaim("role", 42)), wantStatus: http.StatusUnauthorized},
+		{name: "expiry of wrong type", header: "Bearer " + sign(t, activeKey, withClaim("exp", "tomorrow")), wantStatus: http.StatusUnauthorized},
+		{name: "revoked", header: "Bearer " + issued, revocations: fakeRevocationStore{revoked: true}, wantStatus: http.StatusUnauthorized, wantMessage: "token revoked"},
+		{name: "revocation check fails", header: "Bearer " + issued, revocations: fakeRevocationStore{err: errStore}, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got UserContext
+			next := func(request events.APIGatewayProxyRequest, userContext UserContext) (events.APIGatewayProxyResponse, error) {
+				got = userContext
+				return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+			}
+			request := events.APIGatewayProxyRequest{
+				Headers:        map[string]string{},
+				RequestContext: events.APIGatewayProxyRequestContext{RequestID: "request-1"},
+			}
+			if tt.header != "" {
+				headerName := tt.headerName
+				if headerName == "" {
+					headerName = "Authorization"
+				}
+				request.Headers[headerName] = tt.header
+			}
+
+			keySet := tt.keys
+			if keySet == nil {
+				keySet = keys
+			}
+
+			handler := NewMiddleware(keySet, tt.revocations, ProductApiAudience).ValidateJWTMiddleware(next)
+			result, err := handler(request)
+
+			if result.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", result.StatusCode, tt.wantStatus, result.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var body response.ErrorBody
+				if err := json.Unmarshal([]byte(result.Body), &body); err != nil {
+					t.Fatalf("body %q is not an error envelope: %v", result.Body, err)
+				}
+				if body.RequestId != "request-1" || (tt.wantMessage != "" && body.Message != tt.wantMessage) {
+					t.Errorf("body = %+v, want message %q", body, tt.wantMessage)
+				}
+			}
+			if err != nil {
+				t.Errorf("err = %v, want the error in the response only", err)
+			}
+			if tt.wantStatus == http.StatusOK && (got.Username != "alice" || got.Role != RoleUser || got.SessionId != "session-1" || got.Mfa != tt.wantMfa) {
+				t.Errorf("user context = %+v", got)
+			}
+		})
+	}
+}
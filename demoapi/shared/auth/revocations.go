@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrRevocationCheckIncomplete = errors.New("revocation check incomplete")
+
+// SessionRevocationKey is the revocation table id of a revoked session.
+func SessionRevocationKey(sessionId string) string {
+	return "sid#" + sessionId
+}
+
+// UserRevocationKey is the revocation table id of the cut-off time of a user's tokens.
+func UserRevocationKey(username string) string {
+	return "user#" + username
+}
+
+// DynamoRevocations reads the revocation list the user service writes to a DynamoDB
+// table, making it a RevocationChecker for both lambdas.
+type DynamoRevocations struct {
+	db    dynamodbiface.DynamoDBAPI
+	table string
+}
+
+func NewDynamoRevocations(db dynamodbiface.DynamoDBAPI, table string) DynamoRevocations {
+	return DynamoRevocations{
+		db:    db,
+		table: table,
+	}
+}
+
+func (d DynamoRevocations) IsRevoked(sessionId string, username string, issuedAt int64) (bool, error) {
+	keys := []map[string]*dynamodb.AttributeValue{
+		{"id": {S: aws.String(UserRevocationKey(username))}},
+	}
+	if sessionId != "" {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(SessionRevocationKey(sessionId))},
+		})
+	}
+
+	result, err := d.db.BatchGetItem(&dynamodb.BatchGetItemInput{
+		RequestItems: map[string]*dynamodb.KeysAndAttributes{
+			d.table: {
+				Keys:           keys,
+				ConsistentRead: aws.Bool(true),
+			},
+		},
+	})
+
+	if err != nil {
+		return true, err
+	}
+
+	if len(result.UnprocessedKeys) > 0 {
+		return true, ErrRevocationCheckIncomplete
+	}
+
+	for _, item := range result.Responses[d.table] {
+		if aws.StringValue(item["id"].S) == SessionRevocationKey(sessionId) {
+			return true, nil
+		}
+
+		if item["revokedBefore"] == nil {
+			continue
+		}
+
+		revokedBefore, err := strconv.ParseInt(aws.StringValue(item["revokedBefore"].N), 10, 64)
+		if err != nil {
+			return true, err
+		}
+		if issuedAt < revokedBefore {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"shared/apierror"
+	"shared/response"
+	"shared/router"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const PermissionUsersRead = "users:read"
+const PermissionUsersWrite = "users:write"
+const PermissionProductsWrite = "products:write"
+const PermissionProductsDelete = "products:delete"
+
+const RoleProductManager = "product_manager"
+const RoleSupport = "support"
+
+var ErrMissingPermission = errors.New("role does not grant the permission")
+
+var permissions = []string{PermissionUsersRead, PermissionUsersWrite, PermissionProductsWrite, PermissionProductsDelete}
+
+func IsPermission(permission string) bool {
+	return slices.Contains(permissions, permission)
+}
+
+// builtinRoles are there without any configuration. Defined roles may replace
+// all of them but admin, which always holds every permission.
+func builtinRoles() map[string][]string {
+	return map[string][]string{
+		RoleAdmin:          slices.Clone(permissions),
+		RoleUser:           {},
+		RoleProductManager: {PermissionProductsWrite, PermissionProductsDelete},
+		RoleSupport:        {PermissionUsersRead},
+	}
+}
+
+// RoleSource loads role definitions kept outside the code, role name to permissions.
+type RoleSource interface {
+	LoadRoles() (map[string][]string, error)
+}
+
+// Roles maps roles to permissions: the built-in roles plus the ones of the source,
+// reloaded at most every ttl so a changed definition reaches warm lambdas too.
+type Roles struct {
+	source RoleSource
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	roles    map[string][]string
+	loadedAt time.Time
+}
+
+// NewRoles returns the roles of source on top of the built-in ones; a nil source means built-in roles only.
+func NewRoles(source RoleSource, ttl time.Duration) *Roles {
+	return &Roles{
+		source: source,
+		ttl:    ttl,
+	}
+}
+
+// Permissions returns the permissions of role and whether the role exists at all.
+func (r *Roles) Permissions(role string) ([]string, bool, error) {
+	roles, err := r.load()
+	if err != nil {
+		return nil, false, err
+	}
+
+	granted, ok := roles[role]
+	return granted, ok, nil
+}
+
+func (r *Roles) IsRole(role string) (bool, error) {
+	_, ok, err := r.Permissions(role)
+	return ok, err
+}
+
+// Check turns away callers that are not signed in or whose role does not grant permission.
+func (r *Roles) Check(userContext UserContext, permission string) error {
+	if userContext.Username == "" {
+		return apierror.Unauthorized("User Unauthorized").Wrap(ErrMissingUserContext)
+	}
+
+	granted, _, err := r.Permissions(userContext.Role)
+	if err != nil {
+		return fmt.Errorf("error loading roles %w", err)
+	}
+
+	if !slices.Contains(granted, permission) {
+		return apierror.Forbidden("Permission " + permission + " required").Wrap(ErrMissingPermission)
+	}
+
+	return nil
+}
+
+// CanGrant tells whether the caller may hand role to a user or take it away from one.
+// Only admins grant admin; anyone else only roles whose permissions they hold themselves,
+// so holding users:write is no way to raise your own or anyone else's permissions.
+func (r *Roles) CanGrant(userContext UserContext, role string) (bool, error) {
+	if userContext.Role == RoleAdmin {
+		return true, nil
+	}
+	if role == RoleAdmin {
+		return false, nil
+	}
+
+	held, _, err := r.Permissions(userContext.Role)
+	if err != nil {
+		return false, fmt.Errorf("error loading roles %w", err)
+	}
+	granted, _, err := r.Permissions(role)
+	if err != nil {
+		return false, fmt.Errorf("error loading roles %w", err)
+	}
+
+	for _, permission := range granted {
+		if !slices.Contains(held, permission) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// RequirePermission is a route middleware for Check. It goes after Authenticate.
+func (r *Roles) RequirePermission(permission string) router.Middleware {
+	return func(next router.Handler) router.Handler {
+		return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			err := r.Check(UserContextFrom(request), permission)
+			if err != nil {
+				return response.Error(request, err)
+			}
+
+			return next(request)
+		}
+	}
+}
+
+func (r *Roles) load() (map[string][]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.roles != nil && (r.source == nil || time.Since(r.loadedAt) < r.ttl) {
+		return r.roles, nil
+	}
+
+	roles := builtinRoles()
+	if r.source != nil {
+		defined, err := r.source.LoadRoles()
+		if err != nil && r.roles == nil {
+			return nil, err
+		}
+		if err != nil {
+			// an outage of the source should not take authorization down with it
+			log.Printf("reloading roles failed, keeping the previous ones: %v", err)
+			r.loadedAt = time.Now()
+			return r.roles, nil
+		}
+
+		for role, granted := range defined {
+			if role == RoleAdmin || role == "" {
+				continue
+			}
+			roles[role] = knownPermissions(role, granted)
+		}
+	}
+
+	r.roles = roles
+	r.loadedAt = time.Now()
+	return roles, nil
+}
+
+func knownPermissions(role string, granted []string) []string {
+	known := []string{}
+	for _, permission := range granted {
+		if !IsPermission(permission) {
+			log.Printf("role %s lists unknown permission %q, ignoring it", role, permission)
+			continue
+		}
+		known = append(known, permission)
+	}
+	return known
+}
+
+// FileRoleSource reads role definitions from a JSON file such as
+//
+//	{"auditor": ["users:read"], "support": ["users:read", "users:write"]}
+type FileRoleSource struct {
+	path string
+}
+
+func NewFileRoleSource(path string) FileRoleSource {
+	return FileRoleSource{
+		path: path,
+	}
+}
+
+func (f FileRoleSource) LoadRoles() (map[string][]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles map[string][]string
+	err = json.Unmarshal(data, &roles)
+	if err != nil {
+		return nil, fmt.Errorf("roles file %s: %w", f.path, err)
+	}
+
+	return roles, nil
+}
+
+type roleItem struct {
+	Role        string   `dynamodbav:"role"`
+	Permissions []string `dynamodbav:"permissions,stringset"`
+}
+
+// DynamoRoleSource reads role definitions from a DynamoDB table of items such as
+//
+//	{"role": "auditor", "permissions": ["users:read"]}
+//
+// with the permissions kept as a string set.
+type DynamoRoleSource struct {
+	db    dynamodbiface.DynamoDBAPI
+	table string
+}
+
+func NewDynamoRoleSource(db dynamodbiface.DynamoDBAPI, table string) DynamoRoleSource {
+	return DynamoRoleSource{
+		db:    db,
+		table: table,
+	}
+}
+
+func (d DynamoRoleSource) LoadRoles() (map[string][]string, error) {
+	roles := map[string][]string{}
+	var unmarshalErr error
+
+	err := d.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(d.table),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			item := roleItem{}
+			unmarshalErr = dynamodbattribute.UnmarshalMap(i, &item)
+
+			if unmarshalErr != nil {
+				return false
+			}
+
+			roles[item.Role] = item.Permissions
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type fakeRoleSource struct {
+	roles map[string][]string
+	err   error
+	loads int
+}
+
+func (f *fakeRoleSource) LoadRoles() (map[string][]string, error) {
+	f.loads++
+	return f.roles, f.err
+}
+
+func TestBuiltinRoles(t *testing.T) {
+	roles := NewRoles(nil, time.Minute)
+
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{role: RoleAdmin, permission: PermissionUsersWrite, want: true},
+		{role: RoleAdmin, permission: PermissionProductsDelete, want: true},
+		{role: RoleUser, permission: PermissionUsersRead},
+		{role: RoleProductManager, permission: PermissionProductsWrite, want: true},
+		{role: RoleProductManager, permission: PermissionUsersRead},
+		{role: RoleSupport, permission: PermissionUsersRead, want: true},
+		{role: RoleSupport, permission: PermissionUsersWrite},
+		{role: "owner", permission: PermissionUsersRead},
+	}
+
+	for _, tt := range tests {
+		granted, _, err := roles.Permissions(tt.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(granted, tt.permission) != tt.want {
+			t.Errorf("%s has %s = %v, want %v", tt.role, tt.permission, !tt.want, tt.want)
+		}
+	}
+
+	if ok, _ := roles.IsRole("owner"); ok {
+		t.Error("an undefined role exists")
+	}
+}
+
+func TestDefinedRoles(t *testing.T) {
+	source := &fakeRoleSource{roles: map[string][]string{
+		"auditor":   {PermissionUsersRead, "users:impersonate"},
+		RoleSupport: {PermissionUsersRead, PermissionUsersWrite},
+		RoleAdmin:   {},
+	}}
+	roles := NewRoles(source, time.Minute)
+
+	auditor, ok, err := roles.Permissions("auditor")
+	if err != nil || !ok || !slices.Equal(auditor, []string{PermissionUsersRead}) {
+		t.Errorf("auditor = %v, %v, %v, want only the known permission", auditor, ok, err)
+	}
+	if support, _, _ := roles.Permissions(RoleSupport); !slices.Contains(support, PermissionUsersWrite) {
+		t.Errorf("support = %v, want the defined permissions", support)
+	}
+	if admin, _, _ := roles.Permissions(RoleAdmin); !slices.Contains(admin, PermissionUsersWrite) {
+		t.Errorf("admin = %v, want every permission whatever the source says", admin)
+	}
+	if manager, _, _ := roles.Permissions(RoleProductManager); !slices.Contains(manager, PermissionProductsWrite) {
+		t.Errorf("product_manager = %v, want the built-in permissions", manager)
+	}
+
+	roles.IsRole("auditor")
+	if source.loads != 1 {
+		t.Errorf("source loaded %d times, want once within the ttl", source.loads)
+	}
+}
+
+func TestRolesReload(t *testing.T) {
+	source := &fakeRoleSource{roles: map[string][]string{"auditor": {PermissionUsersRead}}}
+	roles := NewRoles(source, time.Millisecond)
+
+	if ok, _ := roles.IsRole("auditor"); !ok {
+		t.Fatal("auditor is not defined")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	source.roles, source.err = nil, errStore
+	if ok, err := roles.IsRole("auditor"); !ok || err != nil {
+		t.Errorf("IsRole = %v, %v while the source is down, want the previous roles", ok, err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	source.roles, source.err = map[string][]string{}, nil
+	if ok, _ := roles.IsRole("auditor"); ok {
+		t.Error("a removed role is still there after the ttl")
+	}
+
+	if _, err := NewRoles(&fakeRoleSource{err: errStore}, time.Minute).IsRole(RoleUser); err == nil {
+		t.Error("a source that never loaded is not reported")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	roles := NewRoles(nil, time.Minute)
+	next := func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}
+
+	tests := []struct {
+		name       string
+		context    *UserContext
+		wantStatus int
+	}{
+		{name: "granted", context: &UserContext{Username: "sam", Role: RoleSupport}, wantStatus: http.StatusOK},
+		{name: "not granted", context: &UserContext{Username: "alice", Role: RoleUser}, wantStatus: http.StatusForbidden},
+		{name: "unknown role", context: &UserContext{Username: "alice", Role: "owner"}, wantStatus: http.StatusForbidden},
+		{name: "anonymous", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{}
+			if tt.context != nil {
+				request = WithUserContext(request, *tt.context)
+			}
+
+			response, _ := roles.RequirePermission(PermissionUsersRead)(next)(request)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	failing := NewRoles(&fakeRoleSource{err: errStore}, time.Minute)
+	response, _ := failing.RequirePermission(PermissionUsersRead)(next)(WithUserContext(events.APIGatewayProxyRequest{}, UserContext{Username: "sam", Role: RoleSupport}))
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d without roles, want %d", response.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestCanGrant(t *testing.T) {
+	roles := NewRoles(&fakeRoleSource{roles: map[string][]string{
+		RoleSupport: {PermissionUsersRead, PermissionUsersWrite},
+		"auditor":   {PermissionUsersRead},
+	}}, time.Minute)
+	support := UserContext{Username: "sam", Role: RoleSupport}
+
+	tests := []struct {
+		name    string
+		context UserContext
+		role    string
+		want    bool
+	}{
+		{name: "admin grants admin", context: UserContext{Username: "root", Role: RoleAdmin}, role: RoleAdmin, want: true},
+		{name: "support grants admin", context: support, role: RoleAdmin},
+		{name: "support grants more than it holds", context: support, role: RoleProductManager},
+		{name: "support grants a lesser role", context: support, role: "auditor", want: true},
+		{name: "support grants its own role", context: support, role: RoleSupport, want: true},
+		{name: "support grants no permissions", context: support, role: RoleUser, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := roles.CanGrant(tt.context, tt.role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("CanGrant = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileRoleSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := os.WriteFile(path, []byte(`{"auditor": ["users:read"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := NewFileRoleSource(path).LoadRoles()
+	if err != nil || !slices.Equal(roles["auditor"], []string{PermissionUsersRead}) {
+		t.Errorf("LoadRoles = %v, %v", roles, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`["auditor"]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileRoleSource(path).LoadRoles(); err == nil {
+		t.Error("a malformed file is accepted")
+	}
+}
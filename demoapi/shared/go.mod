@@ -0,0 +1,11 @@
+module shared
+
+go 1.21.3
+
+require (
+	github.com/aws/aws-lambda-go v1.47.0
+	github.com/aws/aws-sdk-go v1.55.5
+	github.com/golang-jwt/jwt/v5 v5.2.1
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
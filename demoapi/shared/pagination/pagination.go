@@ -0,0 +1,57 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor turns a scan's LastEvaluatedKey into an opaque token for clients.
+func EncodeCursor(lastEvaluatedKey map[string]*dynamodb.AttributeValue) (string, error) {
+	if len(lastEvaluatedKey) == 0 {
+		return "", nil
+	}
+
+	var key map[string]interface{}
+	err := dynamodbattribute.UnmarshalMap(lastEvaluatedKey, &key)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// DecodeCursor turns a token of EncodeCursor back into an ExclusiveStartKey.
+func DecodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var key map[string]interface{}
+	err = json.Unmarshal(raw, &key)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	exclusiveStartKey, err := dynamodbattribute.MarshalMap(key)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return exclusiveStartKey, nil
+}
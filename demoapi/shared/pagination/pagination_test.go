@@ -0,0 +1,50 @@
+package pagination
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := map[string]*dynamodb.AttributeValue{
+		"id":    {S: aws.String("p1")},
+		"price": {N: aws.String("10")},
+	}
+
+	token, err := EncodeCursor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeCursor(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, key) {
+		t.Errorf("decoded = %v, want %v", decoded, key)
+	}
+}
+
+func TestEmptyCursor(t *testing.T) {
+	token, err := EncodeCursor(nil)
+	if err != nil || token != "" {
+		t.Errorf("EncodeCursor(nil) = %q, %v", token, err)
+	}
+
+	key, err := DecodeCursor("")
+	if err != nil || key != nil {
+		t.Errorf("DecodeCursor(\"\") = %v, %v", key, err)
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	for _, token := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := DecodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) err = %v, want ErrInvalidCursor", token, err)
+		}
+	}
+}
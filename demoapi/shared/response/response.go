@@ -0,0 +1,82 @@
+package response
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"shared/apierror"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ErrorBody is the envelope of every error response of both APIs.
+type ErrorBody struct {
+	Code      apierror.Code     `json:"code"`
+	Message   string            `json:"message"`
+	RequestId string            `json:"requestId"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+func Text(statusCode int, body string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       body,
+		StatusCode: statusCode,
+	}
+}
+
+// JSON marshals v as the response body, falling back to a 500 if it cannot be encoded.
+func JSON(statusCode int, v interface{}) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:       `{"code":"internal_error","message":"Internal server error"}`,
+			StatusCode: http.StatusInternalServerError,
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+			},
+		}, err
+	}
+
+	return events.APIGatewayProxyResponse{
+		Body:       string(body),
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}, nil
+}
+
+// Error answers with the JSON error envelope for err. Client errors are expected
+// and internal ones are logged here, so the returned Go error is always nil and
+// API Gateway passes the envelope on instead of reporting a lambda failure.
+func Error(request events.APIGatewayProxyRequest, err error) (events.APIGatewayProxyResponse, error) {
+	apiErr := apierror.From(err)
+	requestId := request.RequestContext.RequestID
+
+	if apiErr.Code == apierror.CodeInternal {
+		log.Printf("request %s %s %s failed: %v", requestId, request.HTTPMethod, request.Path, err)
+	}
+
+	response, err := JSON(apiErr.Code.StatusCode(), ErrorBody{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		RequestId: requestId,
+		Details:   apiErr.Details,
+	})
+	if err != nil {
+		log.Printf("request %s error response could not be encoded: %v", requestId, err)
+	}
+
+	if apiErr.RetryAfter > 0 {
+		// Retry-After is in whole seconds, rounded up so clients never retry too early
+		seconds := int64((apiErr.RetryAfter + time.Second - 1) / time.Second)
+		response.Headers["Retry-After"] = strconv.FormatInt(seconds, 10)
+	}
+	if len(apiErr.Allow) > 0 {
+		response.Headers["Allow"] = strings.Join(apiErr.Allow, ", ")
+	}
+	return response, nil
+}
@@ -0,0 +1,73 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"shared/apierror"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    apierror.Code
+		wantMessage string
+		wantDetails map[string]string
+		wantRetry   string
+		wantAllow   string
+	}{
+		{name: "bad request", err: apierror.BadRequest("Invalid Request"), wantStatus: http.StatusBadRequest, wantCode: apierror.CodeBadRequest, wantMessage: "Invalid Request"},
+		{name: "validation", err: apierror.Validation("Invalid Request", map[string]string{"name": "is required"}), wantStatus: http.StatusUnprocessableEntity, wantCode: apierror.CodeValidation, wantMessage: "Invalid Request", wantDetails: map[string]string{"name": "is required"}},
+		{name: "not found", err: apierror.NotFound("product not found"), wantStatus: http.StatusNotFound, wantCode: apierror.CodeNotFound, wantMessage: "product not found"},
+		{name: "wrapped client error", err: fmt.Errorf("updating product %w", apierror.Conflict("product changed")), wantStatus: http.StatusConflict, wantCode: apierror.CodeConflict, wantMessage: "product changed"},
+		{name: "cause stays hidden", err: apierror.Forbidden("Admin role required").Wrap(errors.New("role user")), wantStatus: http.StatusForbidden, wantCode: apierror.CodeForbidden, wantMessage: "Admin role required"},
+		{name: "too many requests", err: apierror.TooManyRequests("Too many failed login attempts", 1500*time.Millisecond), wantStatus: http.StatusTooManyRequests, wantCode: apierror.CodeTooMany, wantMessage: "Too many failed login attempts", wantRetry: "2"},
+		{name: "method not allowed", err: apierror.MethodNotAllowed("Method not allowed", []string{"GET", "PUT"}), wantStatus: http.StatusMethodNotAllowed, wantCode: apierror.CodeMethod, wantMessage: "Method not allowed", wantAllow: "GET, PUT"},
+		{name: "precondition failed", err: apierror.PreconditionFailed("Product has changed"), wantStatus: http.StatusPreconditionFailed, wantCode: apierror.CodePrecondition, wantMessage: "Product has changed"},
+		{name: "precondition required", err: apierror.PreconditionRequired("If-Match is required"), wantStatus: http.StatusPreconditionRequired, wantCode: apierror.CodeNoCondition, wantMessage: "If-Match is required"},
+		{name: "internal", err: errors.New("table is gone"), wantStatus: http.StatusInternalServerError, wantCode: apierror.CodeInternal, wantMessage: "Internal server error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{RequestID: "request-1"},
+			}
+
+			result, err := Error(request, tt.err)
+
+			if err != nil {
+				t.Errorf("err = %v, want nil", err)
+			}
+			if result.StatusCode != tt.wantStatus || result.Headers["Content-Type"] != "application/json" {
+				t.Fatalf("status = %d, headers %v, want %d", result.StatusCode, result.Headers, tt.wantStatus)
+			}
+			if result.Headers["Retry-After"] != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", result.Headers["Retry-After"], tt.wantRetry)
+			}
+			if result.Headers["Allow"] != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", result.Headers["Allow"], tt.wantAllow)
+			}
+
+			var body ErrorBody
+			if err := json.Unmarshal([]byte(result.Body), &body); err != nil {
+				t.Fatalf("body %q is not valid JSON: %v", result.Body, err)
+			}
+			if body.Code != tt.wantCode || body.Message != tt.wantMessage || body.RequestId != "request-1" || len(body.Details) != len(tt.wantDetails) {
+				t.Errorf("body = %+v", body)
+			}
+			for field, message := range tt.wantDetails {
+				if body.Details[field] != message {
+					t.Errorf("details[%s] = %q, want %q", field, body.Details[field], message)
+				}
+			}
+		})
+	}
+}
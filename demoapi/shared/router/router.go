@@ -0,0 +1,152 @@
+package router
+
+import (
+	"net/url"
+	"shared/apierror"
+	"shared/response"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type Handler func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Middleware wraps a handler, for example to authenticate the caller before it runs.
+type Middleware func(next Handler) Handler
+
+// Router dispatches on the method and the path of a request. Patterns are paths
+// whose segments may be parameters in braces, like /products/{id}; a parameter
+// matches one whole segment and is handed over in request.PathParameters.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  Handler
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method and pattern. The middleware wraps the handler
+// in the order given, so the first one sees the request first.
+func (r *Router) Handle(method string, pattern string, handler Handler, middleware ...Middleware) {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	r.routes = append(r.routes, route{
+		method:   method,
+		segments: split(pattern),
+		handler:  handler,
+	})
+}
+
+// Serve answers with the route for the method and path of request, a 405 with an
+// Allow header when only other methods are registered for the path, or a 404.
+func (r *Router) Serve(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	segments := split(request.Path)
+
+	var best *route
+	var bestParams map[string]string
+	var allow []string
+
+	for i := range r.routes {
+		candidate := &r.routes[i]
+
+		params, ok := candidate.match(segments)
+		if !ok {
+			continue
+		}
+
+		if candidate.method != request.HTTPMethod {
+			if !slices.Contains(allow, candidate.method) {
+				allow = append(allow, candidate.method)
+			}
+			continue
+		}
+
+		if best == nil || candidate.moreSpecificThan(best) {
+			best, bestParams = candidate, params
+		}
+	}
+
+	if best != nil {
+		request.PathParameters = bestParams
+		return best.handler(request)
+	}
+
+	if len(allow) > 0 {
+		slices.Sort(allow)
+		return response.Error(request, apierror.MethodNotAllowed("Method not allowed", allow))
+	}
+
+	return response.Error(request, apierror.NotFound("Not found"))
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, segment := range rt.segments {
+		if name, ok := parameter(segment); ok {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[name] = value
+			continue
+		}
+
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// moreSpecificThan prefers the route with a literal segment where the other has a
+// parameter first, so /products/search wins over /products/{id}.
+func (rt *route) moreSpecificThan(other *route) bool {
+	for i := range rt.segments {
+		_, isParam := parameter(rt.segments[i])
+		_, otherIsParam := parameter(other.segments[i])
+		if isParam != otherIsParam {
+			return otherIsParam
+		}
+	}
+	return false
+}
+
+func parameter(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// Header looks a header up ignoring the case of its name, as API Gateway passes
+// on whatever case the client sent.
+func Header(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// named answers with its name and the path parameters, so tests can tell which route ran.
+func named(name string) Handler {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		body := name
+		if id := request.PathParameters["id"]; id != "" {
+			body += " " + id
+		}
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: body}, nil
+	}
+}
+
+// tag appends its name to the body on the way out, so the body records the middleware order.
+func tag(name string) Middleware {
+	return func(next Handler) Handler {
+		return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			response, err := next(request)
+			response.Body += " <" + name
+			return response, err
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	r := New()
+	r.Handle(http.MethodPost, "/login", named("login"))
+	r.Handle(http.MethodGet, "/products", named("list"))
+	r.Handle(http.MethodPost, "/products", named("create"))
+	r.Handle(http.MethodGet, "/products/{id}", named("get"))
+	r.Handle(http.MethodDelete, "/products/{id}", named("delete"))
+	r.Handle(http.MethodGet, "/products/search", named("search"))
+	r.Handle(http.MethodGet, "/products/{id}/reviews", named("reviews"))
+	r.Handle(http.MethodGet, "/", named("root"))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{name: "literal", method: http.MethodPost, path: "/login", wantStatus: http.StatusOK, wantBody: "login"},
+		{name: "same path other method", method: http.MethodPost, path: "/products", wantStatus: http.StatusOK, wantBody: "create"},
+		{name: "path parameter", method: http.MethodGet, path: "/products/p-1", wantStatus: http.StatusOK, wantBody: "get p-1"},
+		{name: "escaped parameter", method: http.MethodDelete, path: "/products/a%20b", wantStatus: http.StatusOK, wantBody: "delete a b"},
+		{name: "literal before parameter", method: http.MethodGet, path: "/products/search", wantStatus: http.StatusOK, wantBody: "search"},
+		{name: "trailing slash", method: http.MethodGet, path: "/products/", wantStatus: http.StatusOK, wantBody: "list"},
+		{name: "root", method: http.MethodGet, path: "/", wantStatus: http.StatusOK, wantBody: "root"},
+		{name: "wrong method", method: http.MethodGet, path: "/login", wantStatus: http.StatusMethodNotAllowed, wantAllow: "POST"},
+		{name: "wrong method on parameter", method: http.MethodPut, path: "/products/p-1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "DELETE, GET"},
+		{name: "unknown path", method: http.MethodGet, path: "/nope", wantStatus: http.StatusNotFound},
+		{name: "nested parameter", method: http.MethodGet, path: "/products/p-1/reviews", wantStatus: http.StatusOK, wantBody: "reviews p-1"},
+		{name: "too deep", method: http.MethodGet, path: "/products/p-1/reviews/r-1", wantStatus: http.StatusNotFound},
+		{name: "empty parameter", method: http.MethodGet, path: "/products//reviews", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := r.Serve(events.APIGatewayProxyRequest{HTTPMethod: tt.method, Path: tt.path})
+
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantBody != "" && response.Body != tt.wantBody {
+				t.Errorf("body = %q, want %q", response.Body, tt.wantBody)
+			}
+			if response.Headers["Allow"] != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", response.Headers["Allow"], tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	r := New()
+	r.Handle(http.MethodGet, "/me", named("me"), tag("outer"), tag("inner"))
+
+	response, _ := r.Serve(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/me"})
+
+	if response.Body != "me <inner <outer" {
+		t.Errorf("body = %q, want the first middleware outermost", response.Body)
+	}
+}
+
+func TestMiddlewareCanStopRequest(t *testing.T) {
+	deny := func(next Handler) Handler {
+		return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusForbidden}, nil
+		}
+	}
+
+	r := New()
+	r.Handle(http.MethodGet, "/me", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		t.Error("handler ran behind a middleware that refused the request")
+		return events.APIGatewayProxyResponse{}, nil
+	}, deny)
+
+	response, _ := r.Serve(events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/me"})
+
+	if response.StatusCode != http.StatusForbidden || strings.Contains(response.Body, "me") {
+		t.Errorf("response = %+v, want the middleware's 403", response)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	headers := map[string]string{"authorization": "Bearer token", "If-Match": `"1"`}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Authorization", want: "Bearer token"},
+		{name: "if-match", want: `"1"`},
+		{name: "Content-Type", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := Header(headers, tt.name); got != tt.want {
+			t.Errorf("Header(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
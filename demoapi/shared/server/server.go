@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type Handler func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// ListenAndServe runs the lambda handler behind a plain net/http server so the
+// service can be used locally without API Gateway.
+func ListenAndServe(addr string, handler Handler) error {
+	log.Printf("local server listening on %s", addr)
+	return http.ListenAndServe(addr, NewHTTPHandler(handler))
+}
+
+func NewHTTPHandler(handler Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, err := newProxyRequest(r)
+		if err != nil {
+			http.Error(w, "Invalid Request", http.StatusBadRequest)
+			return
+		}
+
+		response, err := handler(request)
+		if err != nil {
+			// API Gateway answers with a bare 502 whenever the lambda returns an error
+			log.Printf("%s %s failed: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, `{"message": "Internal server error"}`)
+			return
+		}
+
+		writeProxyResponse(w, response)
+	})
+}
+
+func newProxyRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	headers := map[string]string{}
+	multiValueHeaders := map[string][]string{}
+	for name, values := range r.Header {
+		headers[name] = strings.Join(values, ",")
+		multiValueHeaders[name] = values
+	}
+
+	query := r.URL.Query()
+	queryStringParameters := map[string]string{}
+	for name, values := range query {
+		queryStringParameters[name] = values[len(values)-1]
+	}
+
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	return events.APIGatewayProxyRequest{
+		Resource:                        r.URL.Path,
+		Path:                            r.URL.Path,
+		HTTPMethod:                      r.Method,
+		Headers:                         headers,
+		MultiValueHeaders:               multiValueHeaders,
+		QueryStringParameters:           queryStringParameters,
+		MultiValueQueryStringParameters: query,
+		Body:                            string(body),
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:        newRequestID(),
+			Stage:            "local",
+			Path:             r.URL.Path,
+			HTTPMethod:       r.Method,
+			RequestTimeEpoch: time.Now().UnixMilli(),
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP,
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}, nil
+}
+
+func writeProxyResponse(w http.ResponseWriter, response events.APIGatewayProxyResponse) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusBadGateway
+	}
+	w.WriteHeader(statusCode)
+
+	if response.IsBase64Encoded {
+		body, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			log.Printf("invalid base64 response body: %v", err)
+			return
+		}
+		w.Write(body)
+		return
+	}
+
+	io.WriteString(w, response.Body)
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,216 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"shared/apierror"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules are declared on request structs with a validate tag, for example
+//
+//	Username string `json:"username" validate:"required,min=3,max=32,username"`
+//
+// required  the string must not be blank, a pointer must not be nil but may point at a blank string
+// min=N     strings have at least N characters, numbers are at least N
+// max=N     strings have at most N characters, numbers are at most N
+// maxbytes=N strings are at most N bytes long in UTF-8, for limits like bcrypt's 72 bytes
+// username  letters, digits, '.', '_' and '-' only
+// email     a plain address like alice@example.com, without a display name
+// oneof=a b the value is one of the listed words
+//
+// Fields are reported under their JSON name with the message of the first rule they break.
+// Pointer fields tell a field that was left out from a zero value: a nil pointer only
+// breaks required, the other rules check the value it points to.
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Decode reads a JSON request body into v, rejecting unknown fields and trailing
+// data with a 400, then validates v and reports broken rules with a 422.
+func Decode(body string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return decodeError(err)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return apierror.BadRequest("Request body must be a single JSON object")
+	}
+
+	return Struct(v)
+}
+
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return &apierror.Error{
+			Code:    apierror.CodeBadRequest,
+			Message: "Invalid Request",
+			Details: map[string]string{typeErr.Field: "must be a " + typeErr.Type.Kind().String()},
+			Err:     err,
+		}
+	}
+
+	// encoding/json has no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		name, _ := strconv.Unquote(field)
+		return &apierror.Error{
+			Code:    apierror.CodeBadRequest,
+			Message: "Invalid Request",
+			Details: map[string]string{name: "unknown field"},
+			Err:     err,
+		}
+	}
+
+	return apierror.BadRequest("Invalid Request").Wrap(err)
+}
+
+// Struct checks the validate tags of v, a struct or a pointer to one.
+func Struct(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("validate: %T is not a struct", v)
+	}
+
+	details := map[string]string{}
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		rules, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		message, err := check(value.Field(i), rules)
+		if err != nil {
+			return fmt.Errorf("validate: field %s %w", field.Name, err)
+		}
+		if message != "" {
+			details[jsonName(field)] = message
+		}
+	}
+
+	if len(details) > 0 {
+		return apierror.Validation("Invalid Request", details)
+	}
+	return nil
+}
+
+// Value checks a single value against rules written as in a validate tag, for input
+// that is not decoded into a tagged struct. It returns the message of the first broken rule.
+func Value(v interface{}, rules string) (string, error) {
+	return check(reflect.ValueOf(v), rules)
+}
+
+// check returns the message of the first rule the field breaks, or an error for a rule it does not understand.
+func check(field reflect.Value, rules string) (string, error) {
+	pointer := field.Kind() == reflect.Pointer
+	missing := pointer && field.IsNil()
+	if pointer && !missing {
+		field = field.Elem()
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+
+		if missing {
+			if name == "required" {
+				return "is required", nil
+			}
+			continue
+		}
+
+		switch name {
+		case "required":
+			if !pointer && field.Kind() == reflect.String && strings.TrimSpace(field.String()) == "" {
+				return "is required", nil
+			}
+		case "min", "max":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				return "", fmt.Errorf("rule %s needs a number", rule)
+			}
+			if message := checkLimit(field, name, limit); message != "" {
+				return message, nil
+			}
+		case "maxbytes":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				return "", fmt.Errorf("rule %s needs a number", rule)
+			}
+			if field.Kind() == reflect.String && len(field.String()) > limit {
+				return fmt.Sprintf("must be at most %d bytes", limit), nil
+			}
+		case "username":
+			if field.String() != "" && !usernamePattern.MatchString(field.String()) {
+				return "may only contain letters, digits, '.', '_' and '-'", nil
+			}
+		case "email":
+			if field.String() != "" && !isEmail(field.String()) {
+				return "must be an email address", nil
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			if !contains(allowed, fmt.Sprint(field.Interface())) {
+				return "must be one of " + strings.Join(allowed, ", "), nil
+			}
+		default:
+			return "", fmt.Errorf("unknown rule %s", rule)
+		}
+	}
+
+	return "", nil
+}
+
+func checkLimit(field reflect.Value, name string, limit int) string {
+	switch field.Kind() {
+	case reflect.String:
+		length := utf8.RuneCountInString(field.String())
+		if name == "min" && length < limit {
+			return fmt.Sprintf("must be at least %d characters", limit)
+		}
+		if name == "max" && length > limit {
+			return fmt.Sprintf("must be at most %d characters", limit)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if name == "min" && field.Int() < int64(limit) {
+			return fmt.Sprintf("must be at least %d", limit)
+		}
+		if name == "max" && field.Int() > int64(limit) {
+			return fmt.Sprintf("must be at most %d", limit)
+		}
+	}
+	return ""
+}
+
+func isEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
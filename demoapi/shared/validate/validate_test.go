@@ -0,0 +1,149 @@
+package validate
+
+import (
+	"net/http"
+	"shared/apierror"
+	"testing"
+)
+
+type signUp struct {
+	Username string `json:"username" validate:"required,min=3,max=12,username"`
+	Password string `json:"password" validate:"required,min=8,maxbytes=16"`
+	Role     string `json:"role" validate:"oneof=user admin"`
+	Age      int    `json:"age" validate:"min=0,max=150"`
+	Nickname string `json:"nickname,omitempty" validate:"max=5"`
+	Email    string `json:"email,omitempty" validate:"max=254,email"`
+	Note     string `json:"note"`
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantDetails map[string]string
+	}{
+		{name: "valid", body: `{"username":"alice.b","password":"long enough","role":"user","age":30}`},
+		{name: "malformed", body: `{"username":`, wantStatus: http.StatusBadRequest},
+		{name: "empty body", body: ``, wantStatus: http.StatusBadRequest},
+		{name: "trailing data", body: `{"username":"alice","password":"long enough","role":"user"} {}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", body: `{"username":"alice","password":"long enough","role":"user","admin":true}`, wantStatus: http.StatusBadRequest, wantDetails: map[string]string{"admin": "unknown field"}},
+		{name: "wrong type", body: `{"username":"alice","password":"long enough","role":"user","age":"old"}`, wantStatus: http.StatusBadRequest, wantDetails: map[string]string{"age": "must be a int"}},
+		{
+			name:       "every rule",
+			body:       `{"username":"al ice","password":"short","role":"owner","age":-1,"nickname":"toolong"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetails: map[string]string{
+				"username": "may only contain letters, digits, '.', '_' and '-'",
+				"password": "must be at least 8 characters",
+				"role":     "must be one of user, admin",
+				"age":      "must be at least 0",
+				"nickname": "must be at most 5 characters",
+			},
+		},
+		{name: "blank is missing", body: `{"username":"   ","password":"long enough","role":"user"}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"username": "is required"}},
+		{name: "email", body: `{"username":"alice","password":"long enough","role":"user","email":"alice@example.com"}`},
+		{name: "display name is not an email", body: `{"username":"alice","password":"long enough","role":"user","email":"Alice <alice@example.com>"}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"email": "must be an email address"}},
+		{name: "length counts characters", body: `{"username":"alice","password":"long enough","role":"user","nickname":"ééééé"}`},
+		{name: "bytes fit", body: `{"username":"alice","password":"éééééééé","role":"user"}`},
+		{name: "too many bytes", body: `{"username":"alice","password":"ééééééééé","role":"user"}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"password": "must be at most 16 bytes"}},
+		{name: "too long", body: `{"username":"abcdefghijklm","password":"long enough","role":"user","age":151}`, wantStatus: http.StatusUnprocessableEntity, wantDetails: map[string]string{"username": "must be at most 12 characters", "age": "must be at most 150"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v signUp
+			err := Decode(tt.body, &v)
+
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("Decode = %v", err)
+				}
+				return
+			}
+
+			apiErr := apierror.From(err)
+			if apiErr.Code.StatusCode() != tt.wantStatus {
+				t.Fatalf("Decode = %v with status %d, want %d", err, apiErr.Code.StatusCode(), tt.wantStatus)
+			}
+			if tt.wantDetails == nil {
+				return
+			}
+			if len(apiErr.Details) != len(tt.wantDetails) {
+				t.Errorf("details = %v, want %v", apiErr.Details, tt.wantDetails)
+			}
+			for field, message := range tt.wantDetails {
+				if apiErr.Details[field] != message {
+					t.Errorf("details[%s] = %q, want %q", field, apiErr.Details[field], message)
+				}
+			}
+		})
+	}
+}
+
+func TestPointerFields(t *testing.T) {
+	type price struct {
+		Amount *int    `json:"amount" validate:"required,min=0"`
+		Note   *string `json:"note" validate:"max=5"`
+		Label  *string `json:"label" validate:"required"`
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantDetails map[string]string
+	}{
+		{name: "zero is there", body: `{"amount":0,"label":"a"}`},
+		{name: "blank string is there", body: `{"amount":1,"label":""}`},
+		{name: "left out", body: `{"label":"a"}`, wantDetails: map[string]string{"amount": "is required"}},
+		{name: "null is left out", body: `{"amount":null,"label":null}`, wantDetails: map[string]string{"amount": "is required", "label": "is required"}},
+		{name: "value is checked", body: `{"amount":-1,"note":"toolong","label":"a"}`, wantDetails: map[string]string{"amount": "must be at least 0", "note": "must be at most 5 characters"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v price
+			err := Decode(tt.body, &v)
+
+			if tt.wantDetails == nil {
+				if err != nil {
+					t.Fatalf("Decode = %v", err)
+				}
+				return
+			}
+
+			details := apierror.From(err).Details
+			if len(details) != len(tt.wantDetails) {
+				t.Errorf("details = %v, want %v", details, tt.wantDetails)
+			}
+			for field, message := range tt.wantDetails {
+				if details[field] != message {
+					t.Errorf("details[%s] = %q, want %q", field, details[field], message)
+				}
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	if message, err := Value("", "required,max=3"); err != nil || message != "is required" {
+		t.Errorf("Value = %q, %v", message, err)
+	}
+	if message, err := Value(4, "min=0,max=3"); err != nil || message != "must be at most 3" {
+		t.Errorf("Value = %q, %v", message, err)
+	}
+	if message, err := Value("ok", "required,max=3"); err != nil || message != "" {
+		t.Errorf("Value = %q, %v, want no message", message, err)
+	}
+}
+
+func TestStructUnknownRule(t *testing.T) {
+	v := struct {
+		Name string `validate:"shiny"`
+	}{}
+
+	err := Struct(&v)
+	if err == nil || apierror.From(err).Code != apierror.CodeInternal {
+		t.Errorf("Struct = %v, want an internal error for a bad tag", err)
+	}
+}